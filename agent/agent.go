@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,6 +24,10 @@ type processedTrace struct {
 	Root      *model.Span
 	Env       string
 	Sublayers []model.SublayerValue
+
+	// TopLevelSublayers are the sublayers of the top-level spans other
+	// than the root, keyed by span ID, if enabled
+	TopLevelSublayers map[uint64][]model.SublayerValue
 }
 
 func (pt *processedTrace) weight() float64 {
@@ -35,10 +40,12 @@ func (pt *processedTrace) weight() float64 {
 // Agent struct holds all the sub-routines structs and make the data flow between them
 type Agent struct {
 	Receiver     *HTTPReceiver
+	Assembler    *TraceAssembler // nil unless trace assembly is enabled
 	Concentrator *Concentrator
 	Filters      []filters.Filter
 	Sampler      *Sampler
 	Writer       *Writer
+	TraceBuffer  *TraceBuffer // nil unless the debug trace buffer is enabled
 
 	// config
 	conf *config.AgentConfig
@@ -46,6 +53,16 @@ type Agent struct {
 	// Used to synchronize on a clean exit
 	exit chan struct{}
 
+	// tracks the traces handed over to the concentrator and the sampler
+	// so that we can wait for them before the final flush
+	processWG sync.WaitGroup
+
+	// resource usage level, updated by the watchdog
+	degradation degradation
+
+	// now returns the current time of the pipeline, simulated when replaying
+	now func() time.Time
+
 	die func(format string, args ...interface{})
 }
 
@@ -56,23 +73,43 @@ func NewAgent(conf *config.AgentConfig) *Agent {
 	r := NewHTTPReceiver(conf)
 	c := NewConcentrator(
 		conf.ExtraAggregators,
+		conf.ExtraMetrics,
 		conf.BucketInterval.Nanoseconds(),
+		conf.StatsTopLevelOnly,
 	)
+	c.LimitGrains(conf.MaxGrains, conf.MaxServiceGrains)
+	c.HandleLateSpans(conf.BucketsKeptOpen, conf.LateSpanPolicy)
+	if conf.StatsDistribution == config.SketchDistribution {
+		c.UseSketches(conf.SketchRelativeAccuracy)
+	}
 	f := filters.Setup(conf)
 	s := NewSampler(conf)
 
 	w := NewWriter(conf)
 	w.inServices = r.services
 
+	var ta *TraceAssembler
+	if conf.AssemblerEnabled {
+		ta = NewTraceAssembler(conf.AssemblerTimeout, conf.AssemblerMaxSpans)
+	}
+
+	var tb *TraceBuffer
+	if conf.DebugTraceBufferSize > 0 {
+		tb = NewTraceBuffer(conf.DebugTraceBufferSize)
+	}
+
 	return &Agent{
 		Receiver:     r,
+		Assembler:    ta,
 		Concentrator: c,
 		Filters:      f,
 		Sampler:      s,
 		Writer:       w,
+		TraceBuffer:  tb,
 		conf:         conf,
 		exit:         exit,
 		die:          die,
+		now:          time.Now,
 	}
 }
 
@@ -87,9 +124,26 @@ func (a *Agent) Run() {
 	watchdogTicker := time.NewTicker(a.conf.WatchdogInterval)
 	defer watchdogTicker.Stop()
 
+	statsTicker := time.NewTicker(processStatsInterval)
+	defer statsTicker.Stop()
+
+	// only tick when there are assembled traces to expire
+	var assemblerTick <-chan time.Time
+	if a.Assembler != nil {
+		assemblerTicker := time.NewTicker(assemblerExpireInterval)
+		defer assemblerTicker.Stop()
+		assemblerTick = assemblerTicker.C
+	}
+
 	// update the data served by expvar so that we don't expose a 0 sample rate
 	updatePreSampler(*a.Receiver.preSampler.Stats())
 
+	// served on the receiver port, along with the collector API
+	http.HandleFunc("/debug/service_graph", a.Concentrator.handleServiceGraph)
+	if a.TraceBuffer != nil {
+		http.HandleFunc("/debug/traces", a.TraceBuffer.handleTraces)
+	}
+
 	a.Receiver.Run()
 	a.Writer.Run()
 	a.Sampler.Run()
@@ -97,41 +151,117 @@ func (a *Agent) Run() {
 	for {
 		select {
 		case t := <-a.Receiver.traces:
-			a.Process(t)
+			a.receive(t)
+		case now := <-assemblerTick:
+			a.processAssembled(a.Assembler.Expire(now))
+			updateAssemblerStats(a.Assembler.Stats())
 		case <-flushTicker.C:
-			p := model.AgentPayload{
-				HostName: a.conf.HostName,
-				Env:      a.conf.DefaultEnv,
-			}
-			var wg sync.WaitGroup
-			wg.Add(2)
-			go func() {
-				defer watchdog.LogOnPanic()
-				p.Stats = a.Concentrator.Flush()
-				wg.Done()
-			}()
-			go func() {
-				defer watchdog.LogOnPanic()
-				p.Traces = a.Sampler.Flush()
-				wg.Done()
-			}()
-
-			wg.Wait()
-			p.SetExtra(languageHeaderKey, a.Receiver.Languages())
-
-			a.Writer.inPayloads <- &p
+			a.Writer.inPayloads <- a.flush(false)
+			a.flushRelayed()
 		case <-watchdogTicker.C:
 			a.watchdog()
+		case <-statsTicker.C:
+			a.Concentrator.logStats()
 		case <-a.exit:
 			log.Info("exiting")
-			close(a.Receiver.exit)
-			a.Writer.Stop()
-			a.Sampler.Stop()
+			a.shutdown()
 			return
 		}
 	}
 }
 
+// flush builds a payload out of the stats and the sampled traces we
+// accumulated so far. If force is true, all the concentrator buckets are
+// flushed, including the ones still opened.
+func (a *Agent) flush(force bool) *model.AgentPayload {
+	p := model.AgentPayload{
+		HostName: a.conf.HostName,
+		Env:      a.conf.DefaultEnv,
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer watchdog.LogOnPanic()
+		p.Stats = a.Concentrator.flush(a.now().UnixNano(), force)
+		wg.Done()
+	}()
+	go func() {
+		defer watchdog.LogOnPanic()
+		p.Traces = a.Sampler.Flush()
+		wg.Done()
+	}()
+
+	wg.Wait()
+	p.SetExtra(languageHeaderKey, a.Receiver.Languages())
+
+	return &p
+}
+
+// flushRelayed hands the payloads relayed by other agents since the last
+// flush to the writer.
+func (a *Agent) flushRelayed() {
+	for _, p := range a.Receiver.relay.Flush() {
+		a.Writer.inPayloads <- p
+	}
+}
+
+// shutdown stops accepting new data, processes what has already been
+// received and hands a last payload containing everything left over
+// to the writer before stopping it.
+func (a *Agent) shutdown() {
+	close(a.Receiver.exit)
+	if a.Receiver.capture != nil {
+		a.Receiver.capture.Enable(false)
+	}
+
+	// drain the traces which were received but not processed yet
+	drained := 0
+	for done := false; !done; {
+		select {
+		case t := <-a.Receiver.traces:
+			a.receive(t)
+			drained++
+		default:
+			done = true
+		}
+	}
+	log.Infof("processed %d remaining traces", drained)
+
+	if a.Assembler != nil {
+		a.processAssembled(a.Assembler.FlushAll())
+	}
+
+	a.processWG.Wait()
+
+	a.Writer.inPayloads <- a.flush(true)
+	a.flushRelayed()
+	a.Writer.Stop()
+	a.Sampler.Stop()
+}
+
+// receive hands a trace coming from the receiver to the assembler, if
+// enabled, and processes the traces which are ready.
+func (a *Agent) receive(t taggedTrace) {
+	if a.Assembler == nil {
+		a.Process(t.trace)
+		return
+	}
+	a.processAssembled(a.Assembler.Add(t.trace, t.tags, a.now()))
+}
+
+// processAssembled repairs the traces released by the assembler, which the
+// receiver left as they were since they could be fragments, and processes
+// them. Their anomalies and drops are accounted for with the tags they were
+// received with.
+func (a *Agent) processAssembled(traces []taggedTrace) {
+	for _, t := range traces {
+		ts := a.Receiver.stats.getTagStats(t.tags)
+		for _, t := range a.Receiver.repairTrace(ts, t.trace) {
+			a.Process(t)
+		}
+	}
+}
+
 // Process is the default work unit that receives a trace, transforms it and
 // passes it downstream
 func (a *Agent) Process(t model.Trace) {
@@ -143,7 +273,8 @@ func (a *Agent) Process(t model.Trace) {
 	}
 
 	root := t.GetRoot()
-	if root.End() < model.Now()-2*a.conf.BucketInterval.Nanoseconds() {
+	if a.conf.LateSpanPolicy == config.LateSpanDrop &&
+		root.End() < a.now().UnixNano()-int64(a.conf.BucketsKeptOpen)*a.conf.BucketInterval.Nanoseconds() {
 		log.Errorf("skipping trace with root too far in past, root:%v", *root)
 
 		// We get the address of the struct holding the stats associated to the tags
@@ -171,11 +302,30 @@ func (a *Agent) Process(t model.Trace) {
 	rate *= a.Receiver.preSampler.Rate()
 	sampler.SetTraceAppliedSampleRate(root, rate)
 
+	if a.conf.ClockSkewCorrection || len(a.conf.ClockSkewServices) > 0 {
+		if n := t.CorrectClockSkew(a.conf.ClockSkewCorrectionEnabled); n > 0 {
+			log.Debugf("corrected clock skew of %d subtrees, traceID:%v", n, root.TraceID)
+		}
+	}
+
 	t.ComputeTopLevel()
 
-	sublayers := model.ComputeSublayers(t)
+	sublayers := model.ComputeSublayers(t, a.conf.SublayerBreakdowns...)
+	if a.conf.CriticalPath {
+		sublayers = append(sublayers, model.ComputeCriticalPath(t)...)
+	}
 	model.SetSublayersOnSpan(root, sublayers)
 
+	var topLevelSublayers map[uint64][]model.SublayerValue
+	if a.conf.SublayersTopLevel {
+		topLevelSublayers = model.ComputeTopLevelSublayers(t, a.conf.SublayerBreakdowns...)
+		for i := range t {
+			if values, ok := topLevelSublayers[t[i].SpanID]; ok {
+				model.SetSublayersOnSpan(&t[i], values)
+			}
+		}
+	}
+
 	for i := range t {
 		t[i] = quantizer.Quantize(t[i])
 	}
@@ -185,6 +335,8 @@ func (a *Agent) Process(t model.Trace) {
 		Root:      root,
 		Env:       a.conf.DefaultEnv,
 		Sublayers: sublayers,
+
+		TopLevelSublayers: topLevelSublayers,
 	}
 	if tenv := t.GetEnv(); tenv != "" {
 		pt.Env = tenv
@@ -194,14 +346,20 @@ func (a *Agent) Process(t model.Trace) {
 	// as they access the Metrics map, which is not thread safe.
 	t.ComputeWeight(*root)
 	t.ComputeTopLevel()
+	t.ComputeTrackedMetrics(a.conf.ExtraMetrics)
+	a.processWG.Add(2)
 	go func() {
 		defer watchdog.LogOnPanic()
+		defer a.processWG.Done()
 		a.Concentrator.Add(pt)
-
 	}()
 	go func() {
 		defer watchdog.LogOnPanic()
-		a.Sampler.Add(pt)
+		defer a.processWG.Done()
+		sampled := a.Sampler.Add(pt)
+		if a.TraceBuffer != nil {
+			a.TraceBuffer.Add(pt, sampled)
+		}
 	}()
 }
 
@@ -210,26 +368,36 @@ func (a *Agent) watchdog() {
 	wi.CPU = watchdog.CPU()
 	wi.Mem = watchdog.Mem()
 	wi.Net = watchdog.Net()
+	wi.Cgroup = watchdog.Cgroup()
 
-	if float64(wi.Mem.Alloc) > a.conf.MaxMemory && a.conf.MaxMemory > 0 {
-		a.die("exceeded max memory (current=%d, max=%d)", wi.Mem.Alloc, int64(a.conf.MaxMemory))
-	}
-	if int(wi.Net.Connections) > a.conf.MaxConnections && a.conf.MaxConnections > 0 {
-		a.die("exceeded max connections (current=%d, max=%d)", wi.Net.Connections, a.conf.MaxConnections)
-	}
+	a.degrade(wi)
 
 	updateWatchdogInfo(wi)
 
 	// Adjust pre-sampling dynamically
-	rate, err := sampler.CalcPreSampleRate(a.conf.MaxCPU, wi.CPU.UserAvg, a.Receiver.preSampler.RealRate())
+	signals := sampler.PreSampleSignals{
+		MaxUserAvg:   a.conf.MaxCPU,
+		UserAvg:      wi.CPU.UserAvg,
+		MaxAlloc:     a.conf.MaxMemory * a.conf.PreSampleMemoryTarget,
+		Alloc:        float64(wi.Mem.Alloc),
+		MaxQueueFill: a.conf.PreSampleQueueTarget,
+		QueueFill:    float64(len(a.Receiver.traces)) / float64(cap(a.Receiver.traces)),
+	}
+	rate, limiting, err := sampler.CalcMultiPreSampleRate(signals, a.Receiver.preSampler.RealRate())
 	if rate > a.conf.PreSampleRate {
 		rate = a.conf.PreSampleRate
+		limiting = ""
+	}
+	if a.degradation.level != levelNormal {
+		// shed load by pre-sampling more
+		rate *= degradedPreSampleFactor
 	}
 	if err != nil {
 		log.Warnf("problem computing pre-sample rate: %v", err)
 	}
 	a.Receiver.preSampler.SetRate(rate)
 	a.Receiver.preSampler.SetError(err)
+	a.Receiver.preSampler.SetLimitingSignal(limiting)
 
 	updatePreSampler(*a.Receiver.preSampler.Stats())
 }
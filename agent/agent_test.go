@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"runtime"
 	"strings"
 	"testing"
@@ -12,6 +15,8 @@ import (
 
 	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestWatchdog(t *testing.T) {
@@ -73,6 +78,84 @@ func TestWatchdog(t *testing.T) {
 	buf[len(buf)-1] = 2
 }
 
+func TestAgentShutdownFlushesAll(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make(chan dataFromAPI, 1)
+	server := newTestServer(t, data)
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+
+	agent := NewAgent(conf)
+	agent.Writer.Run()
+
+	// a trace which was received but not processed yet
+	span := fixtures.RandomSpan()
+	span.Start = model.Now() - span.Duration
+	agent.Receiver.traces <- taggedTrace{trace: model.Trace{span}}
+
+	agent.shutdown()
+
+	assert.Len(agent.Receiver.traces, 0)
+	select {
+	case received := <-data:
+		assert.Equal("/api/v0.1/collector", received.urlPath)
+	default:
+		t.Fatal("the last payload should have been flushed on shutdown")
+	}
+	assert.Len(agent.Concentrator.FlushAll(), 0)
+}
+
+func TestAgentRepairsAssembledTraces(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "key"
+	conf.AssemblerEnabled = true
+	agent := NewAgent(conf)
+	handler := agent.Receiver.httpHandleWithVersion(v03, agent.Receiver.handleTraces)
+
+	// a trace split across two payloads, neither fragment being valid alone,
+	// and a fragment whose root never comes
+	now := model.Now()
+	span := func(traceID, spanID, parentID uint64) model.Span {
+		return model.Span{TraceID: traceID, SpanID: spanID, ParentID: parentID, Service: "s", Name: "n", Resource: "r", Start: now, Duration: 1}
+	}
+	for _, traces := range []model.Traces{
+		{{span(1, 3, 2), span(1, 4, 2)}},
+		{{span(1, 1, 0), span(1, 2, 1)}},
+		{{span(2, 3, 2), span(2, 4, 2)}},
+	} {
+		data, err := json.Marshal(traces)
+		assert.Nil(err)
+		req := httptest.NewRequest("POST", "/v0.3/traces", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Datadog-Meta-Lang", "go")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		assert.Equal(http.StatusOK, w.Code)
+		agent.receive(<-agent.Receiver.traces)
+	}
+	agent.processWG.Wait()
+
+	ts := agent.Receiver.stats.getTagStats(Tags{Lang: "go"})
+	assert.Equal(int64(3), ts.TracesReceived)
+	assert.Equal(int64(0), ts.SpansOrphan)
+	assert.Equal(int64(0), ts.TracesMultipleRoots)
+	assert.Equal(int64(0), ts.SpansDropped)
+	assert.Equal(int64(1), agent.Assembler.Stats().TracesCompleted)
+
+	// the anomalies of the trace released without its root are accounted
+	// for with the tags it was received with
+	agent.processAssembled(agent.Assembler.FlushAll())
+	agent.processWG.Wait()
+	assert.Equal(int64(1), ts.SpansOrphan)
+	assert.Equal(int64(0), agent.Receiver.stats.getTagStats(Tags{}).SpansOrphan)
+}
+
 func BenchmarkAgentTraceProcessing(b *testing.B) {
 	c := config.NewDefaultAgentConfig()
 	c.APIKey = "test"
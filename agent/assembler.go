@@ -0,0 +1,212 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// assemblerExpireInterval is the delay between two checks for expired
+// pending traces.
+const assemblerExpireInterval = time.Second
+
+// pendingTrace holds the spans received so far for a trace we are still
+// waiting the root of.
+type pendingTrace struct {
+	spans     model.Trace
+	spanIDs   map[uint64]struct{}
+	firstSeen time.Time
+	tags      Tags // of the first payload the trace was received in
+}
+
+func (pt *pendingTrace) add(t model.Trace) (hasRoot bool, dups int) {
+	for _, s := range t {
+		if _, ok := pt.spanIDs[s.SpanID]; ok {
+			// the same span was reported twice, keep the first one
+			dups++
+			continue
+		}
+		pt.spanIDs[s.SpanID] = struct{}{}
+		pt.spans = append(pt.spans, s)
+		if s.ParentID == 0 {
+			hasRoot = true
+		}
+	}
+	return hasRoot, dups
+}
+
+// assemblerStats contains statistics about the trace assembler.
+// Its fields require to be accessed in an atomic way.
+type assemblerStats struct {
+	// PendingTraces is the number of traces currently waiting for their root.
+	PendingTraces int64
+	// PendingSpans is the number of spans currently buffered.
+	PendingSpans int64
+	// TracesCompleted is the number of traces released because their root arrived.
+	TracesCompleted int64
+	// TracesExpired is the number of traces released without a root after the timeout.
+	TracesExpired int64
+	// TracesEvicted is the number of traces released early to respect the memory limit.
+	TracesEvicted int64
+	// SpansDuplicated is the number of spans dropped because they were already buffered.
+	SpansDuplicated int64
+}
+
+// TraceAssembler buffers the spans of traces reported across several
+// payloads, keyed by trace ID, until the root span arrives or a timeout
+// expires. This way the rest of the pipeline (sublayers, top-level,
+// sampling, stats) works on whole traces instead of fragments.
+//
+// The local part of a distributed trace has no root span, its local root
+// has a parent in another service. As it can't be told apart from a partial
+// flush missing its root, it is released once the timeout expires.
+type TraceAssembler struct {
+	timeout  time.Duration // how long we wait for the root of a trace
+	maxSpans int           // maximum number of spans kept in memory
+
+	pending map[uint64]*pendingTrace
+	nspans  int
+	mu      sync.Mutex
+
+	stats assemblerStats
+}
+
+// NewTraceAssembler returns a new TraceAssembler waiting at most timeout
+// for a trace to complete and buffering at most maxSpans spans.
+func NewTraceAssembler(timeout time.Duration, maxSpans int) *TraceAssembler {
+	return &TraceAssembler{
+		timeout:  timeout,
+		maxSpans: maxSpans,
+		pending:  make(map[uint64]*pendingTrace),
+	}
+}
+
+// Add buffers a (possibly partial) trace received with the given tags and
+// returns the traces which are ready to be processed: the trace itself if
+// its root arrived, plus any trace evicted to make room for it.
+func (ta *TraceAssembler) Add(t model.Trace, tags Tags, now time.Time) []taggedTrace {
+	if len(t) == 0 {
+		return nil
+	}
+	traceID := t[0].TraceID
+
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+
+	pt, ok := ta.pending[traceID]
+	if !ok {
+		if traceIsComplete(t) {
+			// common case: the whole trace came at once
+			atomic.AddInt64(&ta.stats.TracesCompleted, 1)
+			return []taggedTrace{{t, tags}}
+		}
+		pt = &pendingTrace{
+			spans:     make(model.Trace, 0, len(t)),
+			spanIDs:   make(map[uint64]struct{}, len(t)),
+			firstSeen: now,
+			tags:      tags,
+		}
+		ta.pending[traceID] = pt
+	}
+
+	before := len(pt.spans)
+	hasRoot, dups := pt.add(t)
+	ta.nspans += len(pt.spans) - before
+	atomic.AddInt64(&ta.stats.SpansDuplicated, int64(dups))
+
+	var ready []taggedTrace
+	if hasRoot {
+		ready = append(ready, ta.release(traceID))
+		atomic.AddInt64(&ta.stats.TracesCompleted, 1)
+	}
+
+	for ta.maxSpans > 0 && ta.nspans > ta.maxSpans {
+		oldestID, oldestTime := uint64(0), now
+		for id, p := range ta.pending {
+			if !p.firstSeen.After(oldestTime) {
+				oldestID, oldestTime = id, p.firstSeen
+			}
+		}
+		log.Debugf("assembler full, releasing incomplete trace %d", oldestID)
+		ready = append(ready, ta.release(oldestID))
+		atomic.AddInt64(&ta.stats.TracesEvicted, 1)
+	}
+
+	ta.updatePending()
+	return ready
+}
+
+// Expire returns the pending traces which have been waiting for their
+// root for longer than the timeout. They are returned as they are.
+func (ta *TraceAssembler) Expire(now time.Time) []taggedTrace {
+	var expired []taggedTrace
+
+	ta.mu.Lock()
+	for id, pt := range ta.pending {
+		if now.Sub(pt.firstSeen) < ta.timeout {
+			continue
+		}
+		expired = append(expired, ta.release(id))
+	}
+	ta.updatePending()
+	ta.mu.Unlock()
+
+	atomic.AddInt64(&ta.stats.TracesExpired, int64(len(expired)))
+	return expired
+}
+
+// FlushAll returns all the pending traces, complete or not. It is meant
+// to be used on exit.
+func (ta *TraceAssembler) FlushAll() []taggedTrace {
+	var traces []taggedTrace
+
+	ta.mu.Lock()
+	for id := range ta.pending {
+		traces = append(traces, ta.release(id))
+	}
+	ta.updatePending()
+	ta.mu.Unlock()
+
+	return traces
+}
+
+// Stats returns a copy of the current assembler stats.
+func (ta *TraceAssembler) Stats() assemblerStats {
+	return assemblerStats{
+		PendingTraces:   atomic.LoadInt64(&ta.stats.PendingTraces),
+		PendingSpans:    atomic.LoadInt64(&ta.stats.PendingSpans),
+		TracesCompleted: atomic.LoadInt64(&ta.stats.TracesCompleted),
+		TracesExpired:   atomic.LoadInt64(&ta.stats.TracesExpired),
+		TracesEvicted:   atomic.LoadInt64(&ta.stats.TracesEvicted),
+		SpansDuplicated: atomic.LoadInt64(&ta.stats.SpansDuplicated),
+	}
+}
+
+// release removes a trace from the pending ones and returns its spans.
+// It must be called with the lock held.
+func (ta *TraceAssembler) release(traceID uint64) taggedTrace {
+	pt := ta.pending[traceID]
+	delete(ta.pending, traceID)
+	ta.nspans -= len(pt.spans)
+	return taggedTrace{pt.spans, pt.tags}
+}
+
+// updatePending refreshes the pending gauges. It must be called with the lock held.
+func (ta *TraceAssembler) updatePending() {
+	atomic.StoreInt64(&ta.stats.PendingTraces, int64(len(ta.pending)))
+	atomic.StoreInt64(&ta.stats.PendingSpans, int64(ta.nspans))
+}
+
+// traceIsComplete tells if a trace has its root span.
+func traceIsComplete(t model.Trace) bool {
+	for i := range t {
+		if t[i].ParentID == 0 {
+			return true
+		}
+	}
+	return false
+}
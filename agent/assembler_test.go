@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func testAssemblerSpan(traceID, spanID, parentID uint64) model.Span {
+	return model.Span{TraceID: traceID, SpanID: spanID, ParentID: parentID, Service: "s", Name: "n", Resource: "r"}
+}
+
+// testAssemblerFragment returns two sibling spans, whose parent is missing.
+func testAssemblerFragment(traceID uint64) model.Trace {
+	return model.Trace{testAssemblerSpan(traceID, 2, 1), testAssemblerSpan(traceID, 3, 1)}
+}
+
+func TestAssemblerCompleteTrace(t *testing.T) {
+	assert := assert.New(t)
+	ta := NewTraceAssembler(time.Minute, 0)
+
+	trace := model.Trace{testAssemblerSpan(1, 1, 0), testAssemblerSpan(1, 2, 1)}
+	ready := ta.Add(trace, Tags{}, time.Now())
+
+	assert.Equal([]taggedTrace{{trace, Tags{}}}, ready)
+	assert.Equal(int64(0), ta.Stats().PendingTraces)
+	assert.Equal(int64(1), ta.Stats().TracesCompleted)
+}
+
+func TestAssemblerFragments(t *testing.T) {
+	assert := assert.New(t)
+	ta := NewTraceAssembler(time.Minute, 0)
+	now := time.Now()
+
+	assert.Len(ta.Add(model.Trace{testAssemblerSpan(1, 3, 2), testAssemblerSpan(1, 4, 1)}, Tags{}, now), 0)
+	assert.Len(ta.Add(model.Trace{testAssemblerSpan(1, 2, 1), testAssemblerSpan(1, 3, 2)}, Tags{}, now), 0)
+
+	stats := ta.Stats()
+	assert.Equal(int64(1), stats.PendingTraces)
+	assert.Equal(int64(3), stats.PendingSpans)
+	assert.Equal(int64(1), stats.SpansDuplicated)
+
+	ready := ta.Add(model.Trace{testAssemblerSpan(1, 1, 0)}, Tags{}, now)
+	if assert.Len(ready, 1) {
+		assert.Len(ready[0].trace, 4)
+		assert.Equal(uint64(1), ready[0].trace.GetRoot().SpanID)
+	}
+
+	stats = ta.Stats()
+	assert.Equal(int64(0), stats.PendingTraces)
+	assert.Equal(int64(0), stats.PendingSpans)
+	assert.Equal(int64(1), stats.TracesCompleted)
+}
+
+func TestAssemblerExpire(t *testing.T) {
+	assert := assert.New(t)
+	ta := NewTraceAssembler(10*time.Second, 0)
+	now := time.Now()
+
+	ta.Add(testAssemblerFragment(1), Tags{Lang: "go"}, now)
+	ta.Add(testAssemblerFragment(2), Tags{}, now.Add(5*time.Second))
+
+	assert.Len(ta.Expire(now.Add(9*time.Second)), 0)
+
+	expired := ta.Expire(now.Add(10 * time.Second))
+	if assert.Len(expired, 1) {
+		assert.Equal(uint64(1), expired[0].trace[0].TraceID)
+		assert.Equal(Tags{Lang: "go"}, expired[0].tags)
+	}
+	assert.Equal(int64(1), ta.Stats().TracesExpired)
+	assert.Equal(int64(1), ta.Stats().PendingTraces)
+
+	assert.Len(ta.FlushAll(), 1)
+	assert.Equal(int64(0), ta.Stats().PendingTraces)
+}
+
+func TestAssemblerMaxSpans(t *testing.T) {
+	assert := assert.New(t)
+	ta := NewTraceAssembler(time.Minute, 4)
+	now := time.Now()
+
+	assert.Len(ta.Add(testAssemblerFragment(1), Tags{}, now), 0)
+	assert.Len(ta.Add(testAssemblerFragment(2), Tags{}, now.Add(time.Second)), 0)
+
+	// the oldest trace is released to make room for the new one
+	evicted := ta.Add(testAssemblerFragment(3), Tags{}, now.Add(2*time.Second))
+	if assert.Len(evicted, 1) {
+		assert.Equal(uint64(1), evicted[0].trace[0].TraceID)
+	}
+
+	stats := ta.Stats()
+	assert.Equal(int64(1), stats.TracesEvicted)
+	assert.Equal(int64(4), stats.PendingSpans)
+}
+
+func TestAssemblerChildThenRoot(t *testing.T) {
+	assert := assert.New(t)
+	ta := NewTraceAssembler(time.Minute, 0)
+	now := time.Now()
+
+	// a partial flush with a single child is not mistaken for a whole trace
+	child := testAssemblerSpan(1, 2, 1)
+	assert.Len(ta.Add(model.Trace{child}, Tags{}, now), 0)
+
+	root := testAssemblerSpan(1, 1, 0)
+	assert.Equal([]taggedTrace{{model.Trace{child, root}, Tags{}}}, ta.Add(model.Trace{root}, Tags{}, now))
+	assert.Equal(int64(0), ta.Stats().PendingTraces)
+	assert.Equal(int64(1), ta.Stats().TracesCompleted)
+}
+
+func TestAssemblerDistributedTrace(t *testing.T) {
+	assert := assert.New(t)
+	ta := NewTraceAssembler(10*time.Second, 0)
+	now := time.Now()
+
+	// the local part of a distributed trace, whose root has a remote parent,
+	// waits for the timeout
+	chunk := model.Trace{testAssemblerSpan(1, 10, 5), testAssemblerSpan(1, 11, 10), testAssemblerSpan(1, 12, 10)}
+	assert.Len(ta.Add(chunk, Tags{}, now), 0)
+	assert.Len(ta.Add(model.Trace{testAssemblerSpan(1, 13, 10)}, Tags{}, now), 0)
+
+	expired := ta.Expire(now.Add(10 * time.Second))
+	if assert.Len(expired, 1) {
+		assert.Len(expired[0].trace, 4)
+	}
+
+	stats := ta.Stats()
+	assert.Equal(int64(0), stats.PendingTraces)
+	assert.Equal(int64(0), stats.TracesCompleted)
+	assert.Equal(int64(1), stats.TracesExpired)
+}
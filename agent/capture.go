@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// capturedRequest is a request to the receiver, as stored in capture files
+// which hold one JSON object per line.
+type capturedRequest struct {
+	Time   time.Time   `json:"time"`
+	Path   string      `json:"path"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"` // base64 encoded in the file
+}
+
+// request rebuilds the HTTP request which was captured.
+func (c *capturedRequest) request() *http.Request {
+	return &http.Request{
+		Method:        "POST",
+		URL:           &url.URL{Path: c.Path},
+		Header:        c.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+	}
+}
+
+// captureReader reads the requests of a capture file, one at a time.
+type captureReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newCaptureReader(r io.Reader) *captureReader {
+	return &captureReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next captured request, or io.EOF at the end of the file.
+func (cr *captureReader) Next() (*capturedRequest, error) {
+	for {
+		line, err := cr.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			cr.line++
+			continue // skip blank lines
+		}
+		cr.line++
+
+		var c capturedRequest
+		if err := json.Unmarshal(line, &c); err != nil {
+			return nil, fmt.Errorf("line %d: %v", cr.line, err)
+		}
+		return &c, nil
+	}
+}
+
+// capturedHeader returns the headers of a request which are captured: its
+// content type and the ones set by the tracers.
+func capturedHeader(h http.Header) http.Header {
+	ch := make(http.Header)
+	for k, v := range h {
+		if k == "Content-Type" || strings.HasPrefix(k, "Datadog-") || strings.HasPrefix(k, "X-Datadog-") {
+			ch[k] = v
+		}
+	}
+	return ch
+}
+
+// captureStatus is the state of a Capture, as served on /debug/capture.
+type captureStatus struct {
+	Enabled  bool    `json:"enabled"`
+	Percent  float64 `json:"percent"`
+	File     string  `json:"file"`
+	MaxSize  int64   `json:"max_size"`
+	Captured int64   `json:"captured"` // requests recorded since started
+	Errors   int64   `json:"errors"`   // requests which could not be recorded
+}
+
+// Capture records requests to the receiver to a file, which is rotated when
+// it reaches a maximum size, the previous one being kept with a ".1" suffix.
+// It can be started and stopped at runtime on /debug/capture.
+type Capture struct {
+	path    string
+	maxSize int64
+
+	mu       sync.Mutex
+	enabled  bool
+	rate     float64
+	f        *os.File // opened on the first request recorded
+	size     int64
+	captured int64
+	errors   int64
+}
+
+// NewCapture returns a new Capture recording a fraction rate of the
+// requests to path, once enabled.
+func NewCapture(path string, maxSize int64, rate float64) *Capture {
+	return &Capture{path: path, maxSize: maxSize, rate: rate}
+}
+
+// Enable starts or stops recording requests. When stopped, the capture file
+// is closed so that it can be moved away.
+func (c *Capture) Enable(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if enabled && !c.enabled {
+		c.captured, c.errors = 0, 0
+		log.Infof("capturing %.1f %% of the requests to %s", c.rate*100, c.path)
+	}
+	if !enabled && c.enabled {
+		c.close()
+		log.Infof("stopped capturing requests, %d captured to %s", c.captured, c.path)
+	}
+	c.enabled = enabled
+}
+
+// SetRate sets the fraction of the requests recorded.
+func (c *Capture) SetRate(rate float64) {
+	c.mu.Lock()
+	c.rate = rate
+	c.mu.Unlock()
+}
+
+// Status returns the current state of the capture.
+func (c *Capture) Status() captureStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return captureStatus{
+		Enabled:  c.enabled,
+		Percent:  c.rate * 100,
+		File:     c.path,
+		MaxSize:  c.maxSize,
+		Captured: c.captured,
+		Errors:   c.errors,
+	}
+}
+
+// Sample tells whether a request has to be recorded. It is safe to call on
+// a nil Capture, which never records anything.
+func (c *Capture) Sample() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enabled && (c.rate >= 1 || rand.Float64() < c.rate)
+}
+
+// Record reads at most maxLength+1 bytes of the body of req and records the
+// request. It returns a body to replace req.Body with, which reads the same
+// data as the original one.
+func (c *Capture) Record(req *http.Request, maxLength int64) io.ReadCloser {
+	now := time.Now()
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxLength+1))
+	// whatever happened, the handler reads what we read, then what's left
+	replaced := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+
+	if err != nil {
+		c.mu.Lock()
+		c.errors++
+		c.mu.Unlock()
+		log.Debugf("not capturing request to %s: %v", req.URL.Path, err)
+		return replaced
+	}
+
+	c.write(&capturedRequest{
+		Time:   now,
+		Path:   req.URL.Path,
+		Header: capturedHeader(req.Header),
+		Body:   body,
+	})
+	return replaced
+}
+
+// write appends a request to the capture file, rotating it if it would
+// grow over maxSize.
+func (c *Capture) write(cr *capturedRequest) {
+	line, err := json.Marshal(cr)
+	if err != nil {
+		c.mu.Lock()
+		c.errors++
+		c.mu.Unlock()
+		log.Errorf("cannot capture request to %s: %v", cr.Path, err)
+		return
+	}
+	line = append(line, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return // disabled in the meantime
+	}
+
+	if c.f == nil {
+		err = c.open()
+	}
+	if err == nil && c.size > 0 && c.size+int64(len(line)) > c.maxSize {
+		c.close()
+		if err = os.Rename(c.path, c.path+".1"); err == nil {
+			err = c.open()
+		}
+	}
+	if err != nil {
+		c.errors++
+		log.Errorf("cannot open capture file: %v", err)
+		return
+	}
+
+	n, err := c.f.Write(line)
+	c.size += int64(n)
+	if err != nil {
+		c.errors++
+		log.Errorf("cannot capture request to %s: %v", cr.Path, err)
+		return
+	}
+	c.captured++
+}
+
+// open opens the capture file, appending to it. c.mu must be held.
+func (c *Capture) open() error {
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	c.f = f
+	c.size = fi.Size()
+	return nil
+}
+
+// close closes the capture file, if opened. c.mu must be held.
+func (c *Capture) close() {
+	if c.f == nil {
+		return
+	}
+	if err := c.f.Close(); err != nil {
+		log.Errorf("cannot close capture file: %v", err)
+	}
+	c.f = nil
+	c.size = 0
+}
+
+// handleCapture serves the state of the capture as JSON on /debug/capture.
+// POST requests change it with the query parameters enabled (a boolean) and
+// percent, and are only accepted from the local host.
+func (c *Capture) handleCapture(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "POST":
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, "the capture can only be changed from the local host", http.StatusForbidden)
+			return
+		}
+
+		q := req.URL.Query()
+		if v := q.Get("percent"); v != "" {
+			percent, err := strconv.ParseFloat(v, 64)
+			if err != nil || percent < 0 || percent > 100 {
+				http.Error(w, fmt.Sprintf("invalid percent %q, should be between 0 and 100", v), http.StatusBadRequest)
+				return
+			}
+			c.SetRate(percent / 100)
+		}
+		if v := q.Get("enabled"); v != "" {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid enabled %q", v), http.StatusBadRequest)
+				return
+			}
+			c.Enable(enabled)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Status())
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+)
+
+// readCapture returns all the requests captured to path.
+func readCapture(t *testing.T, path string) []*capturedRequest {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	var requests []*capturedRequest
+	cr := newCaptureReader(f)
+	for {
+		c, err := cr.Next()
+		if err == io.EOF {
+			return requests
+		}
+		assert.Nil(t, err)
+		requests = append(requests, c)
+	}
+}
+
+func TestCaptureReceiver(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.CaptureFile = filepath.Join(dir, "capture.ndjson")
+	conf.CaptureEnabled = true
+	r := NewHTTPReceiver(conf)
+
+	data, err := json.Marshal(fixtures.GetTestTrace(1, 1))
+	assert.Nil(err)
+	req := httptest.NewRequest("POST", "/v0.3/traces", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Datadog-Meta-Lang", "python")
+	req.Header.Set("X-Datadog-Trace-Count", "1")
+	req.Header.Set("Cookie", "secret")
+
+	w := httptest.NewRecorder()
+	r.httpHandleWithVersion(v03, r.handleTraces)(w, req)
+	assert.Equal(200, w.Code)
+
+	// the handler got the body which was captured
+	assert.Len(r.traces, 1)
+	assert.EqualValues(len(data), r.stats.getTagStats(Tags{Lang: "python"}).TracesBytes)
+
+	r.capture.Enable(false)
+	requests := readCapture(t, conf.CaptureFile)
+	assert.Len(requests, 1)
+	assert.Equal("/v0.3/traces", requests[0].Path)
+	assert.Equal(http.Header{
+		"Content-Type":          []string{"application/json"},
+		"Datadog-Meta-Lang":     []string{"python"},
+		"X-Datadog-Trace-Count": []string{"1"},
+	}, requests[0].Header)
+	assert.Equal(data, requests[0].Body)
+	assert.False(requests[0].Time.IsZero())
+}
+
+func TestCaptureSample(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilCapture *Capture
+	assert.False(nilCapture.Sample())
+
+	c := NewCapture("unused", 1024, 1)
+	assert.False(c.Sample())
+	c.Enable(true)
+	assert.True(c.Sample())
+	c.SetRate(0)
+	assert.False(c.Sample())
+
+	c.SetRate(0.5)
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if c.Sample() {
+			sampled++
+		}
+	}
+	assert.InDelta(5000, sampled, 500)
+}
+
+func TestCaptureRotate(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "capture.ndjson")
+	c := NewCapture(path, 500, 1)
+	c.Enable(true)
+
+	body := strings.Repeat("a", 200)
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("POST", "/v0.3/traces", strings.NewReader(body))
+		b, err := ioutil.ReadAll(c.Record(req, 1024))
+		assert.Nil(err)
+		assert.Equal(body, string(b))
+	}
+	c.Enable(false)
+	assert.EqualValues(5, c.Status().Captured)
+
+	// a record is about 350 bytes, only one fits in each file, and only
+	// the last 2 files are kept
+	for _, p := range []string{path, path + ".1"} {
+		fi, err := os.Stat(p)
+		assert.Nil(err)
+		assert.True(fi.Size() <= 500, "%s is too big: %d", p, fi.Size())
+		assert.Len(readCapture(t, p), 1)
+	}
+}
+
+func TestCaptureBodyTooLong(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "capture")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	c := NewCapture(filepath.Join(dir, "capture.ndjson"), 1024*1024, 1)
+	c.Enable(true)
+
+	// the handler still reads the whole body, so that it can reject it
+	body := strings.Repeat("a", 100)
+	req := httptest.NewRequest("POST", "/v0.3/traces", strings.NewReader(body))
+	b, err := ioutil.ReadAll(c.Record(req, 10))
+	assert.Nil(err)
+	assert.Equal(body, string(b))
+
+	c.Enable(false)
+	requests := readCapture(t, c.path)
+	assert.Len(requests, 1)
+	assert.Len(requests[0].Body, 11)
+}
+
+func TestCaptureHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewCapture("/tmp/capture.ndjson", 1024, 1)
+
+	request := func(method, url, remoteAddr string) (int, captureStatus) {
+		req := httptest.NewRequest(method, url, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		c.handleCapture(w, req)
+
+		var status captureStatus
+		if w.Code == 200 {
+			assert.Nil(json.NewDecoder(w.Body).Decode(&status))
+		}
+		return w.Code, status
+	}
+
+	code, status := request("GET", "/debug/capture", "192.0.2.1:1234")
+	assert.Equal(200, code)
+	assert.Equal(captureStatus{Percent: 100, File: "/tmp/capture.ndjson", MaxSize: 1024}, status)
+
+	code, _ = request("POST", "/debug/capture?enabled=true", "192.0.2.1:1234")
+	assert.Equal(http.StatusForbidden, code)
+	assert.False(c.Status().Enabled)
+
+	code, status = request("POST", "/debug/capture?enabled=true&percent=10", "127.0.0.1:1234")
+	assert.Equal(200, code)
+	assert.True(status.Enabled)
+	assert.Equal(10.0, status.Percent)
+
+	code, _ = request("POST", "/debug/capture?percent=200", "[::1]:1234")
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = request("POST", "/debug/capture?enabled=maybe", "[::1]:1234")
+	assert.Equal(http.StatusBadRequest, code)
+
+	code, status = request("POST", "/debug/capture?enabled=false", "[::1]:1234")
+	assert.Equal(200, code)
+	assert.False(status.Enabled)
+	assert.Equal(10.0, status.Percent)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+// checkConfig writes the config resolved from conf, legacyConf, the
+// environment and the limits of the cgroup of the agent, with the source of
+// each value, followed by the errors found in it and in fileErrs. It returns
+// false if there are any.
+//
+// Typical output of 'trace-agent -check-config':
+//
+// -----8<-------------------------------------------------------
+// ======================
+// Trace Agent (v 0.99.0)
+// ======================
+//
+//   Enabled: true (default)
+//   HostName: "web-1" (dd-agent ini)
+//   DefaultEnv: "prod" (trace ini)
+//   ...
+//   ReceiverPort: 8126 (env)
+//   ...
+//
+//   Error: invalid resource filter "[": error parsing regexp: missing closing ]: `[`
+//
+// -----8<-------------------------------------------------------
+func checkConfig(w io.Writer, conf, legacyConf *config.File, limits watchdog.CgroupLimits, fileErrs []error) bool {
+	values, errs := config.CheckConfig(conf, legacyConf, limits.CPU, float64(limits.Memory))
+	errs = append(fileErrs, errs...)
+
+	program, banner := getProgramBanner(Version)
+	fmt.Fprintf(w, "%s\n%s\n%s\n\n", banner, program, banner)
+
+	for _, v := range values {
+		b, err := json.Marshal(v.Value)
+		if err != nil {
+			b = []byte(fmt.Sprintf("%v", v.Value))
+		}
+		fmt.Fprintf(w, "  %s: %s (%s)\n", v.Name, b, v.Source)
+	}
+	fmt.Fprintln(w)
+
+	if len(errs) == 0 {
+		fmt.Fprintf(w, "  Configuration OK\n\n")
+		return true
+	}
+	for _, err := range errs {
+		fmt.Fprintf(w, "  Error: %v\n", err)
+	}
+	fmt.Fprintln(w)
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("DD_API_KEY", "apikey_from_env")
+	defer os.Unsetenv("DD_API_KEY")
+	os.Setenv("DD_RECEIVER_PORT", "8300")
+	defer os.Unsetenv("DD_RECEIVER_PORT")
+
+	var buf bytes.Buffer
+	assert.True(checkConfig(&buf, nil, nil, watchdog.CgroupLimits{}, nil))
+	out := buf.String()
+	t.Logf("check-config:\n%s", out)
+	assert.Contains(out, "  ReceiverPort: 8300 (env)\n")
+	assert.Contains(out, "  StatsdPort: 8125 (default)\n")
+	assert.Contains(out, "  Configuration OK\n")
+	assert.NotContains(out, "apikey_from_env")
+
+	buf.Reset()
+	assert.True(checkConfig(&buf, nil, nil, watchdog.CgroupLimits{CPU: 0.5}, nil))
+	assert.Contains(buf.String(), "  MaxCPU: 0.4 (cgroup)\n")
+
+	buf.Reset()
+	assert.False(checkConfig(&buf, nil, nil, watchdog.CgroupLimits{}, []error{errors.New("trace-agent.ini: unclosed section")}))
+	out = buf.String()
+	assert.True(strings.HasSuffix(out, "  Error: trace-agent.ini: unclosed section\n\n"))
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	log "github.com/cihub/seelog"
 
+	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/DataDog/datadog-trace-agent/statsd"
 )
@@ -15,74 +19,348 @@ import (
 // Gets an imperial shitton of traces, and outputs pre-computed data structures
 // allowing to find the gold (stats) amongst the traces.
 type Concentrator struct {
-	aggregators []string
-	bsize       int64
+	aggregators  []string
+	metrics      []string // span metrics for which we compute sums and distributions
+	bsize        int64
+	topLevelOnly bool // only compute stats for top-level spans and spans forcing them
+
+	// cardinality limits of each bucket, 0 meaning no limit
+	maxGrains        int
+	maxServiceGrains int
+
+	// relative accuracy of the log sketches used for distributions, 0 for GK summaries
+	sketchAlpha float64
+
+	bufferLen  int64  // number of buckets kept open
+	latePolicy string // how spans ending before the oldest open bucket are handled
+	oldestTs   int64  // start of the oldest bucket which was not flushed yet
 
 	buckets map[int64]*model.StatsRawBucket // buckets used to aggregate stats per timestamp
 	mu      sync.Mutex
+
+	// graph holds the calls between services of the last graphLen flushed
+	// buckets, indexed by bucket start and model.ServiceEdgeKey
+	graph    map[int64]map[string]model.ServiceEdge
+	graphLen int64
+
+	stats concentratorStats
 }
 
+// concentratorStats contains stats about the spans handled by the concentrator.
+// Its fields require to be accessed in an atomic way.
+type concentratorStats struct {
+	// SpansSkipped is the number of spans we computed no stats for, as they are not top-level.
+	SpansSkipped int64
+	// SpansForced is the number of spans which are not top-level but got
+	// stats anyway because they are tagged with model.TraceMetricsKey.
+	SpansForced int64
+	// SpansOverflow is the number of spans folded into an overflow grain
+	// because of the cardinality limits.
+	SpansOverflow int64
+	// LateSpansDropped is the number of spans dropped because their bucket was already flushed.
+	LateSpansDropped int64
+	// LateSpansReattributed is the number of spans accounted in the oldest
+	// open bucket because their bucket was already flushed.
+	LateSpansReattributed int64
+	// LateSpansCorrected is the number of spans accounted in correction
+	// buckets because their bucket was already flushed.
+	LateSpansCorrected int64
+}
+
+// defaultBufferLen is the default number of buckets kept open. This is a
+// trade-off: we accept slightly late traces (clock skew and stuff) but we
+// delay flushing by at most this number of buckets.
+const defaultBufferLen = 2
+
+// defaultGraphLen is the number of flushed buckets the service graph is
+// made of, an hour with the default bucket size.
+const defaultGraphLen = 360
+
+// topCardinalityLen is the number of services exposed as top cardinality contributors.
+const topCardinalityLen = 10
+
 // NewConcentrator initializes a new concentrator ready to be started
-func NewConcentrator(aggregators []string, bsize int64) *Concentrator {
+func NewConcentrator(aggregators []string, metrics []string, bsize int64, topLevelOnly bool) *Concentrator {
 	c := Concentrator{
-		aggregators: aggregators,
-		bsize:       bsize,
-		buckets:     make(map[int64]*model.StatsRawBucket),
+		aggregators:  aggregators,
+		metrics:      metrics,
+		bsize:        bsize,
+		topLevelOnly: topLevelOnly,
+		bufferLen:    defaultBufferLen,
+		buckets:      make(map[int64]*model.StatsRawBucket),
+		graph:        make(map[int64]map[string]model.ServiceEdge),
+		graphLen:     defaultGraphLen,
 	}
 	sort.Strings(c.aggregators)
 	return &c
 }
 
+// LimitGrains sets the maximum number of distinct grains of each bucket,
+// and of each service within a bucket, see model.StatsRawBucket.LimitGrains.
+func (c *Concentrator) LimitGrains(maxGrains, maxServiceGrains int) {
+	c.mu.Lock()
+	c.maxGrains = maxGrains
+	c.maxServiceGrains = maxServiceGrains
+	c.mu.Unlock()
+}
+
+// HandleLateSpans sets the number of buckets kept open before being flushed,
+// and how the spans ending before the oldest open bucket, i.e. whose bucket
+// was already flushed, are handled: one of config.LateSpanDrop,
+// config.LateSpanReattribute or config.LateSpanCorrect.
+func (c *Concentrator) HandleLateSpans(bucketsOpen int, policy string) {
+	if bucketsOpen < 1 {
+		bucketsOpen = 1
+	}
+	c.mu.Lock()
+	c.bufferLen = int64(bucketsOpen)
+	c.latePolicy = policy
+	c.mu.Unlock()
+}
+
+// UseSketches makes the concentrator build its distributions with log
+// sketches of relative accuracy alpha, instead of GK summaries.
+func (c *Concentrator) UseSketches(alpha float64) {
+	c.mu.Lock()
+	c.sketchAlpha = alpha
+	c.mu.Unlock()
+}
+
 // Add appends to the proper stats bucket this trace's statistics
 func (c *Concentrator) Add(t processedTrace) {
 	c.mu.Lock()
 
+	// services of the spans, to find the calls between services
+	var services map[uint64]string
+	if len(t.Trace) > 1 {
+		services = make(map[uint64]string, len(t.Trace))
+		for _, s := range t.Trace {
+			services[s.SpanID] = s.Service
+		}
+	}
+
 	for _, s := range t.Trace {
+		if c.topLevelOnly && !s.ComputedTopLevel() {
+			if !s.ForceMetrics() {
+				atomic.AddInt64(&c.stats.SpansSkipped, 1)
+				continue
+			}
+			atomic.AddInt64(&c.stats.SpansForced, 1)
+		}
+
 		btime := s.End() - s.End()%c.bsize
+		var reattributed, correction bool
+		if btime < c.oldestTs {
+			// the bucket of this span was already flushed
+			switch c.latePolicy {
+			case config.LateSpanDrop:
+				atomic.AddInt64(&c.stats.LateSpansDropped, 1)
+				continue
+			case config.LateSpanReattribute:
+				atomic.AddInt64(&c.stats.LateSpansReattributed, 1)
+				btime = c.oldestTs
+				reattributed = true
+			default:
+				atomic.AddInt64(&c.stats.LateSpansCorrected, 1)
+				correction = true
+			}
+		}
+
 		b, ok := c.buckets[btime]
 		if !ok {
 			b = model.NewStatsRawBucket(btime, c.bsize)
+			b.TrackMetrics(c.metrics)
+			b.LimitGrains(c.maxGrains, c.maxServiceGrains)
+			b.UseSketches(c.sketchAlpha)
+			if correction {
+				b.SetCorrection()
+			}
 			c.buckets[btime] = b
 		}
 
+		var sublayers *[]model.SublayerValue
 		if t.Root != nil && s.SpanID == t.Root.SpanID && t.Sublayers != nil {
 			// handle sublayers
-			b.HandleSpan(s, t.Env, c.aggregators, &t.Sublayers)
+			sublayers = &t.Sublayers
+		} else if values, ok := t.TopLevelSublayers[s.SpanID]; ok {
+			sublayers = &values
+		}
+		if reattributed {
+			b.HandleLateSpan(s, t.Env, c.aggregators, sublayers)
 		} else {
-			b.HandleSpan(s, t.Env, c.aggregators, nil)
+			b.HandleSpan(s, t.Env, c.aggregators, sublayers)
+		}
+
+		if caller, ok := services[s.ParentID]; ok && s.ParentID != 0 && caller != s.Service {
+			b.HandleServiceEdge(caller, s, t.Env)
 		}
 	}
 
 	c.mu.Unlock()
 }
 
+// logStats submits the stats accumulated since the last call to statsd and
+// exposes them to expvar, then resets them.
+func (c *Concentrator) logStats() {
+	var accStats concentratorStats
+	accStats.SpansSkipped = atomic.SwapInt64(&c.stats.SpansSkipped, 0)
+	accStats.SpansForced = atomic.SwapInt64(&c.stats.SpansForced, 0)
+	accStats.SpansOverflow = atomic.SwapInt64(&c.stats.SpansOverflow, 0)
+	accStats.LateSpansDropped = atomic.SwapInt64(&c.stats.LateSpansDropped, 0)
+	accStats.LateSpansReattributed = atomic.SwapInt64(&c.stats.LateSpansReattributed, 0)
+	accStats.LateSpansCorrected = atomic.SwapInt64(&c.stats.LateSpansCorrected, 0)
+
+	statsd.Client.Count("datadog.trace_agent.concentrator.spans_skipped", accStats.SpansSkipped, nil, 1)
+	statsd.Client.Count("datadog.trace_agent.concentrator.spans_forced", accStats.SpansForced, nil, 1)
+	statsd.Client.Count("datadog.trace_agent.concentrator.spans_overflow", accStats.SpansOverflow, nil, 1)
+	statsd.Client.Count("datadog.trace_agent.concentrator.late_spans", accStats.LateSpansDropped, []string{"policy:drop"}, 1)
+	statsd.Client.Count("datadog.trace_agent.concentrator.late_spans", accStats.LateSpansReattributed, []string{"policy:reattribute"}, 1)
+	statsd.Client.Count("datadog.trace_agent.concentrator.late_spans", accStats.LateSpansCorrected, []string{"policy:correct"}, 1)
+
+	updateConcentratorStats(accStats)
+}
+
 // Flush deletes and returns complete statistic buckets
 func (c *Concentrator) Flush() []model.StatsBucket {
+	return c.flush(model.Now(), false)
+}
+
+// FlushAll deletes and returns all the statistic buckets, including the
+// ones which are still opened. It is meant to be used on exit.
+func (c *Concentrator) FlushAll() []model.StatsBucket {
+	return c.flush(model.Now(), true)
+}
+
+// flush deletes and returns the buckets which are complete at now, a
+// timestamp in nanoseconds, or all of them if force is true.
+func (c *Concentrator) flush(now int64, force bool) []model.StatsBucket {
 	var sb []model.StatsBucket
-	now := model.Now()
 
 	c.mu.Lock()
 	for ts, srb := range c.buckets {
-		bucket := srb.Export()
-
-		// always keep one bucket opened
-		// this is a trade-off: we accept slightly late traces (clock skew and stuff)
-		// but we delay flushing by at most 2 buckets
-		if ts > now-2*c.bsize {
+		// keep the last c.bufferLen buckets opened
+		if !force && ts > now-c.bufferLen*c.bsize {
 			continue
 		}
+		bucket := srb.Export()
 
 		log.Debugf("flushing bucket %d", ts)
 		for _, d := range bucket.Distributions {
-			statsd.Client.Histogram("datadog.trace_agent.distribution.len", float64(d.Summary.N), nil, 1)
+			statsd.Client.Histogram("datadog.trace_agent.distribution.len", d.Size(), nil, 1)
 		}
 		for _, d := range bucket.ErrDistributions {
-			statsd.Client.Histogram("datadog.trace_agent.err_distribution.len", float64(d.Summary.N), nil, 1)
+			statsd.Client.Histogram("datadog.trace_agent.err_distribution.len", d.Size(), nil, 1)
 		}
+		c.trackCardinality(srb)
+		c.addToGraph(ts, bucket.ServiceEdges)
 		sb = append(sb, bucket)
 		delete(c.buckets, ts)
 	}
+	c.pruneGraph()
+	if !force {
+		c.oldestTs = now - now%c.bsize - (c.bufferLen-1)*c.bsize
+	}
 	c.mu.Unlock()
 
 	return sb
 }
+
+// addToGraph accounts for the service edges of the bucket starting at ts.
+// It must be called with the lock held.
+func (c *Concentrator) addToGraph(ts int64, edges map[string]model.ServiceEdge) {
+	if len(edges) == 0 {
+		return
+	}
+	graph, ok := c.graph[ts]
+	if !ok {
+		graph = make(map[string]model.ServiceEdge, len(edges))
+		c.graph[ts] = graph
+	}
+	for k, e := range edges {
+		ge, ok := graph[k]
+		if !ok {
+			ge = model.ServiceEdge{Env: e.Env, Caller: e.Caller, Callee: e.Callee}
+		}
+		ge.Merge(e)
+		graph[k] = ge
+	}
+}
+
+// pruneGraph forgets the service edges of the buckets older than the last
+// graphLen ones. It must be called with the lock held.
+func (c *Concentrator) pruneGraph() {
+	var newest int64
+	for ts := range c.graph {
+		if ts > newest {
+			newest = ts
+		}
+	}
+	for ts := range c.graph {
+		if ts <= newest-c.graphLen*c.bsize {
+			delete(c.graph, ts)
+		}
+	}
+}
+
+// ServiceGraph returns the calls between services accounted in the last
+// flushed buckets, sorted by env, caller and callee.
+func (c *Concentrator) ServiceGraph() []model.ServiceEdge {
+	c.mu.Lock()
+	graph := make(map[string]model.ServiceEdge)
+	for _, edges := range c.graph {
+		for k, e := range edges {
+			ge, ok := graph[k]
+			if !ok {
+				ge = model.ServiceEdge{Env: e.Env, Caller: e.Caller, Callee: e.Callee}
+			}
+			ge.Merge(e)
+			graph[k] = ge
+		}
+	}
+	c.mu.Unlock()
+
+	edges := make([]model.ServiceEdge, 0, len(graph))
+	for _, e := range graph {
+		edges = append(edges, e)
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Env != edges[j].Env {
+			return edges[i].Env < edges[j].Env
+		}
+		if edges[i].Caller != edges[j].Caller {
+			return edges[i].Caller < edges[j].Caller
+		}
+		return edges[i].Callee < edges[j].Callee
+	})
+	return edges
+}
+
+// handleServiceGraph serves the service graph as JSON, on /debug/service_graph.
+func (c *Concentrator) handleServiceGraph(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Edges []model.ServiceEdge `json:"edges"`
+	}{c.ServiceGraph()})
+}
+
+// trackCardinality reports the number of distinct grains of a flushed
+// bucket, and the spans which were folded because of the limits.
+func (c *Concentrator) trackCardinality(srb *model.StatsRawBucket) {
+	card := srb.Cardinality()
+
+	grains := 0
+	for _, sc := range card {
+		grains += sc.Grains
+		if sc.Overflow > 0 {
+			atomic.AddInt64(&c.stats.SpansOverflow, sc.Overflow)
+			statsd.Client.Count("datadog.trace_agent.concentrator.grains_overflow", sc.Overflow, []string{"service:" + sc.Service}, 1)
+		}
+	}
+	statsd.Client.Histogram("datadog.trace_agent.concentrator.grains", float64(grains), nil, 1)
+
+	if len(card) > topCardinalityLen {
+		card = card[:topCardinalityLen]
+	}
+	updateCardinality(card)
+}
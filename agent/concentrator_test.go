@@ -1,10 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"math/rand"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/stretchr/testify/assert"
 )
@@ -12,7 +15,7 @@ import (
 var testBucketInterval = time.Duration(2 * time.Second).Nanoseconds()
 
 func NewTestConcentrator() *Concentrator {
-	return NewConcentrator([]string{}, time.Second.Nanoseconds())
+	return NewConcentrator([]string{}, nil, time.Second.Nanoseconds(), false)
 }
 
 // getTsInBucket gives a timestamp in ns which is `offset` buckets late
@@ -39,7 +42,7 @@ func testSpan(c *Concentrator, spanID uint64, duration, offset int64, service, r
 
 func TestConcentratorStatsCounts(t *testing.T) {
 	assert := assert.New(t)
-	c := NewConcentrator([]string{}, testBucketInterval)
+	c := NewConcentrator([]string{}, nil, testBucketInterval, false)
 
 	now := model.Now()
 	alignedNow := now - now%c.bsize
@@ -140,3 +143,247 @@ func TestConcentratorStatsCounts(t *testing.T) {
 		assert.Equal(val, int64(count.Value), "Wrong value for count %s", key)
 	}
 }
+
+func TestConcentratorFlushAll(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, nil, testBucketInterval, false)
+
+	testTrace := processedTrace{
+		Env: "none",
+		Trace: model.Trace{
+			// old bucket, flushed by a regular Flush
+			testSpan(c, 1, 24, 3, "A1", "resource1", 0),
+			// still opened buckets, only flushed when forcing it
+			testSpan(c, 2, 12, 1, "A1", "resource1", 0),
+			testSpan(c, 3, 40, 0, "A2", "resource2", 0),
+		},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+
+	c.Add(testTrace)
+
+	assert.Len(c.Flush(), 1, "only the complete bucket should be flushed")
+	assert.Len(c.FlushAll(), 2, "all the opened buckets should be flushed")
+	assert.Len(c.FlushAll(), 0, "nothing should be left in the concentrator")
+}
+
+func TestConcentratorLateSpans(t *testing.T) {
+	for _, tc := range []struct {
+		policy  string
+		buckets int
+		late    int64
+	}{
+		{config.LateSpanDrop, 0, 0},
+		{config.LateSpanReattribute, 1, 1},
+		{config.LateSpanCorrect, 1, 0},
+	} {
+		assert := assert.New(t)
+		c := NewConcentrator([]string{}, nil, testBucketInterval, false)
+		c.HandleLateSpans(2, tc.policy)
+
+		// sets the oldest open bucket
+		assert.Len(c.Flush(), 0)
+
+		testTrace := processedTrace{
+			Env:   "none",
+			Trace: model.Trace{testSpan(c, 1, 24, 3, "A1", "resource1", 0)},
+		}
+		testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+		testTrace.Trace.ComputeTopLevel()
+
+		c.Add(testTrace)
+
+		stats := c.FlushAll()
+		assert.Len(stats, tc.buckets, tc.policy)
+		for _, b := range stats {
+			assert.Equal(tc.late, b.LateSpans, tc.policy)
+			assert.Equal(tc.policy == config.LateSpanCorrect, b.Correction, tc.policy)
+			assert.Contains(b.Counts, "query|hits|env:none,resource:resource1,service:A1", tc.policy)
+		}
+	}
+}
+
+func TestConcentratorBucketsKeptOpen(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, nil, testBucketInterval, false)
+	c.HandleLateSpans(4, config.LateSpanDrop)
+
+	testTrace := processedTrace{
+		Env: "none",
+		Trace: model.Trace{
+			testSpan(c, 1, 24, 4, "A1", "resource1", 0),
+			testSpan(c, 2, 12, 3, "A1", "resource1", 0),
+		},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+
+	c.Add(testTrace)
+
+	assert.Len(c.Flush(), 1, "only the bucket older than the 4 opened ones should be flushed")
+	assert.Len(c.FlushAll(), 1)
+}
+
+func TestConcentratorTopLevelSublayers(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, nil, testBucketInterval, false)
+
+	root := testSpan(c, 1, 100, 3, "A1", "resource1", 0)
+	remote := testSpan(c, 2, 10, 3, "A2", "resource2", 0)
+	remote.ParentID = 1
+
+	testTrace := processedTrace{
+		Env:   "none",
+		Trace: model.Trace{root, remote},
+		Root:  &root,
+		TopLevelSublayers: map[uint64][]model.SublayerValue{
+			2: {{Metric: "_sublayers.span_count", Value: 1}},
+		},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+
+	c.Add(testTrace)
+
+	stats := c.Flush()
+	if assert.Len(stats, 1) {
+		counts := stats[0].Counts
+		assert.Contains(counts, "query|_sublayers.span_count|env:none,resource:resource2,service:A2,:")
+		assert.NotContains(counts, "query|_sublayers.span_count|env:none,resource:resource1,service:A1,:")
+	}
+}
+
+func TestConcentratorServiceGraph(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, nil, testBucketInterval, true)
+
+	root := testSpan(c, 1, 100, 3, "web", "resource1", 0)
+	local := testSpan(c, 2, 50, 3, "web", "resource2", 0)
+	local.ParentID = 1
+	db := testSpan(c, 3, 20, 3, "db", "resource3", 1)
+	db.ParentID = 2
+	cache := testSpan(c, 4, 10, 3, "cache", "resource4", 0)
+	cache.ParentID = 1
+
+	testTrace := processedTrace{
+		Env:   "none",
+		Trace: model.Trace{root, local, db, cache},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+
+	c.Add(testTrace)
+	c.Add(testTrace)
+
+	stats := c.Flush()
+	if assert.Len(stats, 1) {
+		assert.Equal(map[string]model.ServiceEdge{
+			"none|web|db":    {Env: "none", Caller: "web", Callee: "db", Hits: 2, Errors: 2, Duration: 40},
+			"none|web|cache": {Env: "none", Caller: "web", Callee: "cache", Hits: 2, Duration: 20},
+		}, stats[0].ServiceEdges)
+	}
+
+	rec := httptest.NewRecorder()
+	c.handleServiceGraph(rec, httptest.NewRequest("GET", "/debug/service_graph", nil))
+	assert.Equal("application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(`{"edges": [
+		{"env": "none", "caller": "web", "callee": "cache", "hits": 2, "errors": 0, "duration": 20},
+		{"env": "none", "caller": "web", "callee": "db", "hits": 2, "errors": 2, "duration": 40}
+	]}`, rec.Body.String())
+}
+
+func TestConcentratorServiceGraphWindow(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, nil, testBucketInterval, true)
+	c.graphLen = 2
+
+	// a call from web to callee in the bucket offset buckets ago
+	call := func(offset int64, callee string) processedTrace {
+		root := testSpan(c, 1, 100, offset, "web", "resource1", 0)
+		child := testSpan(c, 2, 50, offset, callee, "resource2", 0)
+		child.ParentID = 1
+		pt := processedTrace{Env: "none", Trace: model.Trace{root, child}}
+		pt.Trace.ComputeWeight(*pt.Trace.GetRoot())
+		pt.Trace.ComputeTopLevel()
+		return pt
+	}
+	c.Add(call(5, "db"))
+	c.Add(call(4, "cache"))
+	c.Add(call(3, "queue"))
+	c.Add(call(3, "cache"))
+
+	assert.Len(c.Flush(), 3)
+	assert.Len(c.graph, 2)
+
+	// the calls of the oldest bucket are forgotten
+	var callees []string
+	for _, e := range c.ServiceGraph() {
+		callees = append(callees, fmt.Sprintf("%s:%v", e.Callee, e.Hits))
+	}
+	assert.Equal([]string{"cache:2", "queue:1"}, callees)
+}
+
+func TestConcentratorTopLevelOnly(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, nil, testBucketInterval, true)
+
+	root := testSpan(c, 1, 100, 3, "A1", "resource1", 0)
+	child := testSpan(c, 2, 50, 3, "A1", "resource2", 0)
+	child.ParentID = 1
+	forced := testSpan(c, 3, 20, 3, "A1", "resource3", 0)
+	forced.ParentID = 1
+	forced.Meta = map[string]string{model.TraceMetricsKey: "true"}
+	remote := testSpan(c, 4, 10, 3, "A2", "resource4", 0)
+	remote.ParentID = 1
+
+	testTrace := processedTrace{
+		Env:   "none",
+		Trace: model.Trace{root, child, forced, remote},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+
+	c.Add(testTrace)
+
+	assert.Equal(int64(1), c.stats.SpansSkipped)
+	assert.Equal(int64(1), c.stats.SpansForced)
+
+	stats := c.Flush()
+	if assert.Len(stats, 1) {
+		counts := stats[0].Counts
+		assert.Contains(counts, "query|hits|env:none,resource:resource1,service:A1")
+		assert.NotContains(counts, "query|hits|env:none,resource:resource2,service:A1")
+		assert.Contains(counts, "query|hits|env:none,resource:resource3,service:A1")
+		assert.Contains(counts, "query|hits|env:none,resource:resource4,service:A2")
+	}
+}
+
+func TestConcentratorLimitGrains(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, nil, testBucketInterval, false)
+	c.LimitGrains(0, 1)
+
+	testTrace := processedTrace{
+		Env: "none",
+		Trace: model.Trace{
+			testSpan(c, 1, 24, 3, "A1", "resource1", 0),
+			testSpan(c, 2, 12, 3, "A1", "resource2", 0),
+			testSpan(c, 3, 40, 3, "A1", "resource3", 0),
+		},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+
+	c.Add(testTrace)
+
+	stats := c.Flush()
+	if assert.Len(stats, 1) {
+		assert.Contains(stats[0].Counts, "query|hits|env:none,resource:resource1,service:A1")
+		assert.Equal(float64(2), stats[0].Counts["query|hits|env:none,resource:_other,service:A1"].Value)
+	}
+	assert.Equal(int64(2), c.stats.SpansOverflow)
+
+	card := publishCardinality().([]model.ServiceCardinality)
+	assert.Equal([]model.ServiceCardinality{{Service: "A1", Grains: 2, Overflow: 2}}, card)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+// degradedPreSampleFactor is applied to the pre-sample rate computed from
+// the CPU usage while the agent sheds load.
+const degradedPreSampleFactor = 0.5
+
+// degradationLevel tells how much the agent is over its resource limits.
+type degradationLevel int
+
+const (
+	// levelNormal is when the agent is below its soft limits.
+	levelNormal degradationLevel = iota
+	// levelDegraded is when the agent is above its soft limits, and sheds load.
+	levelDegraded
+	// levelCritical is when the agent is above its hard limits, and exits if it lasts.
+	levelCritical
+)
+
+func (l degradationLevel) String() string {
+	switch l {
+	case levelDegraded:
+		return "degraded"
+	case levelCritical:
+		return "critical"
+	}
+	return "normal"
+}
+
+// checkLimits returns the degradation level matching the resource usage
+// reported by the watchdog, and the reason for it.
+func checkLimits(wi watchdog.Info, conf *config.AgentConfig) (degradationLevel, string) {
+	mem := float64(wi.Mem.Alloc)
+	conns := int(wi.Net.Connections)
+
+	if conf.MaxMemory > 0 && mem > conf.MaxMemory {
+		return levelCritical, fmt.Sprintf("exceeded max memory (current=%d, max=%d)", wi.Mem.Alloc, int64(conf.MaxMemory))
+	}
+	if conf.MaxConnections > 0 && conns > conf.MaxConnections {
+		return levelCritical, fmt.Sprintf("exceeded max connections (current=%d, max=%d)", wi.Net.Connections, conf.MaxConnections)
+	}
+	if conf.WatchdogSoftLimit <= 0 {
+		return levelNormal, ""
+	}
+	if softMem := conf.MaxMemory * conf.WatchdogSoftLimit; softMem > 0 && mem > softMem {
+		return levelDegraded, fmt.Sprintf("exceeded soft memory limit (current=%d, soft limit=%d)", wi.Mem.Alloc, int64(softMem))
+	}
+	if softConns := int(float64(conf.MaxConnections) * conf.WatchdogSoftLimit); softConns > 0 && conns > softConns {
+		return levelDegraded, fmt.Sprintf("exceeded soft connections limit (current=%d, soft limit=%d)", wi.Net.Connections, softConns)
+	}
+	return levelNormal, ""
+}
+
+// degradationInfo describes the degradation state of the agent, to be
+// published by expvar.
+type degradationInfo struct {
+	// Level is the current degradation level: normal, degraded or critical.
+	Level string
+	// Reason is what caused the current level, empty if normal.
+	Reason string
+	// Since is when the current level was entered, zero if it never changed.
+	Since time.Time
+	// HardLimitChecks is the number of consecutive checks above the hard limits.
+	HardLimitChecks int
+	// Transitions is the number of level changes since the agent started.
+	Transitions int64
+}
+
+// degradation keeps track of the degradation level of the agent, across
+// watchdog checks. It is not thread safe.
+type degradation struct {
+	level           degradationLevel
+	reason          string
+	since           time.Time
+	hardLimitChecks int
+	transitions     int64
+}
+
+// update sets the level resulting from the latest watchdog check, and
+// returns true if it changed.
+func (d *degradation) update(level degradationLevel, reason string, now time.Time) bool {
+	if level == levelCritical {
+		d.hardLimitChecks++
+	} else {
+		d.hardLimitChecks = 0
+	}
+	d.reason = reason
+	if level == d.level {
+		return false
+	}
+	d.level = level
+	d.since = now
+	d.transitions++
+	return true
+}
+
+func (d *degradation) info() degradationInfo {
+	return degradationInfo{
+		Level:           d.level.String(),
+		Reason:          d.reason,
+		Since:           d.since,
+		HardLimitChecks: d.hardLimitChecks,
+		Transitions:     d.transitions,
+	}
+}
+
+// degrade checks the resource usage against the soft and hard limits, sheds
+// load while above the soft ones, and exits after conf.WatchdogHardLimitChecks
+// consecutive checks above the hard ones.
+func (a *Agent) degrade(wi watchdog.Info) {
+	level, reason := checkLimits(wi, a.conf)
+	prev := a.degradation.level
+
+	if a.degradation.update(level, reason, time.Now()) {
+		if level == levelNormal {
+			log.Infof("back to normal resource usage, was %s", prev)
+		} else {
+			log.Warnf("%s, going from %s to %s", reason, prev, level)
+		}
+		statsd.Client.Count("datadog.trace_agent.watchdog.transition", 1,
+			[]string{"from:" + prev.String(), "to:" + level.String()}, 1)
+
+		shed := level != levelNormal
+		a.Receiver.RejectConnections(shed)
+		a.Writer.ShrinkBuffer(shed)
+	}
+	statsd.Client.Gauge("datadog.trace_agent.watchdog.degradation_level", float64(level), nil, 1)
+	updateDegradationInfo(a.degradation.info())
+
+	if level == levelCritical && a.degradation.hardLimitChecks >= a.conf.WatchdogHardLimitChecks {
+		a.die("%s", reason)
+	}
+}
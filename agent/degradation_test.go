@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+func degradationTestInfo(alloc uint64, conns int32) watchdog.Info {
+	return watchdog.Info{
+		Mem: watchdog.MemInfo{Alloc: alloc},
+		Net: watchdog.NetInfo{Connections: conns},
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.MaxMemory = 1000
+	conf.MaxConnections = 100
+	conf.WatchdogSoftLimit = 0.8
+
+	for _, tc := range []struct {
+		info  watchdog.Info
+		level degradationLevel
+	}{
+		{degradationTestInfo(500, 10), levelNormal},
+		{degradationTestInfo(900, 10), levelDegraded},
+		{degradationTestInfo(500, 90), levelDegraded},
+		{degradationTestInfo(1001, 10), levelCritical},
+		{degradationTestInfo(900, 101), levelCritical},
+	} {
+		level, reason := checkLimits(tc.info, conf)
+		assert.Equal(tc.level, level, "%+v", tc.info)
+		assert.Equal(level == levelNormal, reason == "", reason)
+	}
+
+	conf.WatchdogSoftLimit = 0
+	level, _ := checkLimits(degradationTestInfo(900, 90), conf)
+	assert.Equal(levelNormal, level)
+}
+
+func TestAgentDegrade(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "apikey_2"
+	conf.MaxMemory = 1000
+	conf.MaxConnections = 0
+	conf.WatchdogHardLimitChecks = 2
+	conf.APIPayloadBufferMaxSize = 1000
+
+	agent := NewAgent(conf)
+	var died string
+	agent.die = func(format string, args ...interface{}) {
+		died = format
+	}
+
+	agent.degrade(degradationTestInfo(900, 0))
+	assert.Equal(levelDegraded, agent.degradation.level)
+	assert.Equal(250, agent.Writer.payloadBufferMaxSize())
+	info := publishDegradationInfo().(degradationInfo)
+	assert.Equal("degraded", info.Level)
+	assert.Equal(int64(1), info.Transitions)
+	assert.False(info.Since.After(time.Now()))
+
+	agent.degrade(degradationTestInfo(1100, 0))
+	assert.Equal(levelCritical, agent.degradation.level)
+	assert.Empty(died, "a single check above the hard limit should not be fatal")
+
+	agent.degrade(degradationTestInfo(1100, 0))
+	assert.NotEmpty(died)
+
+	died = ""
+	agent.degrade(degradationTestInfo(100, 0))
+	assert.Equal(levelNormal, agent.degradation.level)
+	assert.Equal(1000, agent.Writer.payloadBufferMaxSize())
+	info = publishDegradationInfo().(degradationInfo)
+	assert.Equal("normal", info.Level)
+	assert.Empty(info.Reason)
+	assert.Equal(int64(3), info.Transitions)
+	assert.Empty(died)
+	updateDegradationInfo(degradationInfo{})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync/atomic"
@@ -49,6 +50,10 @@ type APIEndpoint struct {
 	url    string
 	stats  endpointStats
 	client *http.Client
+
+	// relay is set when the endpoint is another trace-agent, forwarding
+	// the payloads to the API in its place
+	relay bool
 }
 
 // NewAPIEndpoint returns a new APIEndpoint from a given config
@@ -66,7 +71,23 @@ func NewAPIEndpoint(url, apiKey string) *APIEndpoint {
 	}
 	go func() {
 		defer watchdog.LogOnPanic()
-		ae.logStats()
+		logEndpointStats(&ae.stats)
+	}()
+	return &ae
+}
+
+// NewRelayEndpoint returns a new APIEndpoint sending the data to the
+// trace-agent at the given URL (such as http://trace-relay:8126), which
+// forwards it to the API. No API key is needed, the relay uses its own.
+func NewRelayEndpoint(url string) *APIEndpoint {
+	ae := APIEndpoint{
+		url:    url,
+		client: http.DefaultClient,
+		relay:  true,
+	}
+	go func() {
+		defer watchdog.LogOnPanic()
+		logEndpointStats(&ae.stats)
 	}()
 	return &ae
 }
@@ -105,6 +126,9 @@ func (ae *APIEndpoint) Write(p *model.AgentPayload) (int, error) {
 
 	// Create the request to be sent to the API
 	url := ae.url + model.AgentPayloadAPIPath()
+	if ae.relay {
+		url = ae.url + model.AgentPayloadRelayPath()
+	}
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
 
 	// If the request cannot be created, there is no point in trying again later,
@@ -116,10 +140,7 @@ func (ae *APIEndpoint) Write(p *model.AgentPayload) (int, error) {
 	}
 
 	// Set API key in the header and issue the request
-	queryParams := req.URL.Query()
-	queryParams.Add("api_key", ae.apiKey)
-	req.URL.RawQuery = queryParams.Encode()
-
+	ae.setAPIKey(req)
 	model.SetAgentPayloadHeaders(req.Header, p.Extras())
 	resp, err := ae.client.Do(req)
 
@@ -147,7 +168,7 @@ func (ae *APIEndpoint) Write(p *model.AgentPayload) (int, error) {
 	}
 
 	flushTime := time.Since(startFlush)
-	log.Infof("flushed payload to the API, time:%s, size:%d", flushTime, len(data))
+	log.Infof("flushed payload to %s, time:%s, size:%d", ae.name(), flushTime, len(data))
 	statsd.Client.Gauge("datadog.trace_agent.writer.flush_duration", flushTime.Seconds(), nil, 1)
 
 	// Everything went fine
@@ -158,8 +179,12 @@ func (ae *APIEndpoint) Write(p *model.AgentPayload) (int, error) {
 // This function very loosely logs and returns if any error happens.
 // See comment above.
 func (ae *APIEndpoint) WriteServices(s model.ServicesMetadata) {
-	// Serialize the data to be sent to the API endpoint
+	// Serialize the data to be sent to the API endpoint, or as the receiver
+	// expects it from the clients for a relay
 	data, err := model.EncodeServicesPayload(s)
+	if ae.relay {
+		data, err = json.Marshal(s)
+	}
 	if err != nil {
 		log.Errorf("encoding issue: %v", err)
 		return
@@ -171,6 +196,9 @@ func (ae *APIEndpoint) WriteServices(s model.ServicesMetadata) {
 
 	// Create the request
 	url := ae.url + model.ServicesPayloadAPIPath()
+	if ae.relay {
+		url = ae.url + relayServicesPath
+	}
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
 	if err != nil {
 		log.Errorf("could not create request for endpoint %s: %v", url, err)
@@ -179,10 +207,12 @@ func (ae *APIEndpoint) WriteServices(s model.ServicesMetadata) {
 	}
 
 	// Set the header with the API key and issue the request
-	queryParams := req.URL.Query()
-	queryParams.Add("api_key", ae.apiKey)
-	req.URL.RawQuery = queryParams.Encode()
-	model.SetServicesPayloadHeaders(req.Header)
+	ae.setAPIKey(req)
+	if ae.relay {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		model.SetServicesPayloadHeaders(req.Header)
+	}
 	resp, err := ae.client.Do(req)
 	if err != nil {
 		log.Errorf("error when requesting to endpoint %s: %v", url, err)
@@ -198,23 +228,45 @@ func (ae *APIEndpoint) WriteServices(s model.ServicesMetadata) {
 	}
 
 	// Everything went fine.
-	log.Infof("flushed %d services to the API", len(s))
+	log.Infof("flushed %d services to %s", len(s), ae.name())
 }
 
-// logStats periodically submits stats about the endpoint to statsd
-func (ae *APIEndpoint) logStats() {
+// relayServicesPath is the receiver endpoint of a relay to which the
+// services are sent, the same as for the clients.
+const relayServicesPath = "/v0.3/services"
+
+// setAPIKey adds the API key to the query of req, unless sent to a relay.
+func (ae *APIEndpoint) setAPIKey(req *http.Request) {
+	if ae.apiKey == "" {
+		return
+	}
+	queryParams := req.URL.Query()
+	queryParams.Add("api_key", ae.apiKey)
+	req.URL.RawQuery = queryParams.Encode()
+}
+
+// name describes the endpoint in the logs.
+func (ae *APIEndpoint) name() string {
+	if ae.relay {
+		return "the relay " + ae.url
+	}
+	return "the API"
+}
+
+// logEndpointStats periodically submits the stats of an endpoint to statsd
+func logEndpointStats(stats *endpointStats) {
 	var accStats endpointStats
 
 	for range time.Tick(time.Minute) {
 		// Load counters and reset them for the next flush
-		accStats.TracesPayload = atomic.SwapInt64(&ae.stats.TracesPayload, 0)
-		accStats.TracesPayloadError = atomic.SwapInt64(&ae.stats.TracesPayloadError, 0)
-		accStats.TracesBytes = atomic.SwapInt64(&ae.stats.TracesBytes, 0)
-		accStats.TracesCount = atomic.SwapInt64(&ae.stats.TracesCount, 0)
-		accStats.TracesStats = atomic.SwapInt64(&ae.stats.TracesStats, 0)
-		accStats.ServicesPayload = atomic.SwapInt64(&ae.stats.ServicesPayload, 0)
-		accStats.ServicesPayloadError = atomic.SwapInt64(&ae.stats.ServicesPayloadError, 0)
-		accStats.ServicesBytes = atomic.SwapInt64(&ae.stats.ServicesBytes, 0)
+		accStats.TracesPayload = atomic.SwapInt64(&stats.TracesPayload, 0)
+		accStats.TracesPayloadError = atomic.SwapInt64(&stats.TracesPayloadError, 0)
+		accStats.TracesBytes = atomic.SwapInt64(&stats.TracesBytes, 0)
+		accStats.TracesCount = atomic.SwapInt64(&stats.TracesCount, 0)
+		accStats.TracesStats = atomic.SwapInt64(&stats.TracesStats, 0)
+		accStats.ServicesPayload = atomic.SwapInt64(&stats.ServicesPayload, 0)
+		accStats.ServicesPayloadError = atomic.SwapInt64(&stats.ServicesPayloadError, 0)
+		accStats.ServicesBytes = atomic.SwapInt64(&stats.ServicesBytes, 0)
 
 		statsd.Client.Count("datadog.trace_agent.endpoint.traces_payload", int64(accStats.TracesPayload), nil, 1)
 		statsd.Client.Count("datadog.trace_agent.endpoint.traces_payload_error", int64(accStats.TracesPayloadError), nil, 1)
@@ -258,6 +310,18 @@ type endpointStats struct {
 	ServicesBytes int64
 }
 
+// add adds the stats of another endpointStats struct to s.
+func (s *endpointStats) add(es endpointStats) {
+	s.TracesPayload += es.TracesPayload
+	s.TracesPayloadError += es.TracesPayloadError
+	s.TracesBytes += es.TracesBytes
+	s.TracesCount += es.TracesCount
+	s.TracesStats += es.TracesStats
+	s.ServicesPayload += es.ServicesPayload
+	s.ServicesPayloadError += es.ServicesPayloadError
+	s.ServicesBytes += es.ServicesBytes
+}
+
 // NullEndpoint implements AgentEndpoint, it just logs data
 // and drops everything into /dev/null
 type NullEndpoint struct{}
@@ -14,22 +14,30 @@ import (
 	"time"
 
 	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/DataDog/datadog-trace-agent/sampler"
 	"github.com/DataDog/datadog-trace-agent/watchdog"
 )
 
 var (
-	infoMu              sync.RWMutex
-	infoReceiverStats   []tagStats    // only for the last minute
-	infoEndpointStats   endpointStats // only for the last minute
-	infoWatchdogInfo    watchdog.Info
-	infoSamplerInfo     samplerInfo
-	infoPreSamplerStats sampler.PreSamplerStats
-	infoStart           = time.Now()
-	infoOnce            sync.Once
-	infoTmpl            *template.Template
-	infoNotRunningTmpl  *template.Template
-	infoErrorTmpl       *template.Template
+	infoMu                sync.RWMutex
+	infoReceiverStats     []tagStats           // only for the last minute
+	infoEndpointStats     endpointStats        // only for the last minute
+	infoReceiverTotals    = newReceiverStats() // since the agent started
+	infoEndpointTotals    endpointStats        // since the agent started
+	infoWriterInfo        writerInfo
+	infoDegradationInfo   degradationInfo
+	infoWatchdogInfo      watchdog.Info
+	infoSamplerInfo       samplerInfo
+	infoPreSamplerStats   sampler.PreSamplerStats
+	infoAssemblerStats    assemblerStats
+	infoConcentratorStats concentratorStats          // only for the last minute
+	infoCardinality       []model.ServiceCardinality // only for the last flushed bucket
+	infoStart             = time.Now()
+	infoOnce              sync.Once
+	infoTmpl              *template.Template
+	infoNotRunningTmpl    *template.Template
+	infoErrorTmpl         *template.Template
 )
 
 const (
@@ -59,14 +67,17 @@ const (
     
   ------------------------------{{end}}{{if lt .Status.PreSampler.Rate 1.0}}  
   
-  WARNING: Pre-sampling traces: {{percent .Status.PreSampler.Rate}} %
+  WARNING: Pre-sampling traces: {{percent .Status.PreSampler.Rate}} %{{if .Status.PreSampler.LimitingSignal}} (limited by {{.Status.PreSampler.LimitingSignal}}){{end}}
 {{end}}{{if .Status.PreSampler.Error}}  WARNING: Pre-sampler: {{.Status.PreSampler.Error}}
+{{end}}{{if gt .Status.Watchdog.Cgroup.ThrottledRatio 0.0}}  WARNING: CPU throttled by the cgroup: {{percent .Status.Watchdog.Cgroup.ThrottledRatio}} % of the time
 {{end}}
 
   Bytes sent (1 min): {{add .Status.Endpoint.TracesBytes .Status.Endpoint.ServicesBytes}}
   Traces sent (1 min): {{.Status.Endpoint.TracesCount}}
   Stats sent (1 min): {{.Status.Endpoint.TracesStats}}
-{{if gt .Status.Endpoint.TracesPayloadError 0}}  WARNING: Traces API errors (1 min): {{.Status.Endpoint.TracesPayloadError}}/{{.Status.Endpoint.TracesPayload}}
+{{if gt .Status.Concentrator.SpansSkipped 0}}  Spans without stats, not top-level (1 min): {{.Status.Concentrator.SpansSkipped}}
+{{end}}{{if gt .Status.Concentrator.SpansForced 0}}  Spans with forced stats (1 min): {{.Status.Concentrator.SpansForced}}
+{{end}}{{if gt .Status.Endpoint.TracesPayloadError 0}}  WARNING: Traces API errors (1 min): {{.Status.Endpoint.TracesPayloadError}}/{{.Status.Endpoint.TracesPayload}}
 {{end}}{{if gt .Status.Endpoint.ServicesPayloadError 0}}  WARNING: Services API errors (1 min): {{.Status.Endpoint.ServicesPayloadError}}/{{.Status.Endpoint.ServicesPayload}}
 {{end}}
 `
@@ -102,6 +113,7 @@ func updateReceiverStats(rs *receiverStats) {
 	rs.RUnlock()
 
 	infoReceiverStats = s
+	infoReceiverTotals.acc(rs)
 	infoMu.Unlock()
 }
 
@@ -115,6 +127,7 @@ func publishReceiverStats() interface{} {
 func updateEndpointStats(es endpointStats) {
 	infoMu.Lock()
 	infoEndpointStats = es
+	infoEndpointTotals.add(es)
 	infoMu.Unlock()
 }
 
@@ -138,6 +151,32 @@ func publishSamplerInfo() interface{} {
 	return ss
 }
 
+func updateWriterInfo(wi writerInfo) {
+	infoMu.Lock()
+	infoWriterInfo = wi
+	infoMu.Unlock()
+}
+
+func publishWriterInfo() interface{} {
+	infoMu.RLock()
+	wi := infoWriterInfo
+	infoMu.RUnlock()
+	return wi
+}
+
+func updateDegradationInfo(di degradationInfo) {
+	infoMu.Lock()
+	infoDegradationInfo = di
+	infoMu.Unlock()
+}
+
+func publishDegradationInfo() interface{} {
+	infoMu.RLock()
+	di := infoDegradationInfo
+	infoMu.RUnlock()
+	return di
+}
+
 func updateWatchdogInfo(wi watchdog.Info) {
 	infoMu.Lock()
 	infoWatchdogInfo = wi
@@ -164,6 +203,45 @@ func publishPreSamplerStats() interface{} {
 	return ss
 }
 
+func updateAssemblerStats(as assemblerStats) {
+	infoMu.Lock()
+	infoAssemblerStats = as
+	infoMu.Unlock()
+}
+
+func publishAssemblerStats() interface{} {
+	infoMu.RLock()
+	as := infoAssemblerStats
+	infoMu.RUnlock()
+	return as
+}
+
+func updateConcentratorStats(cs concentratorStats) {
+	infoMu.Lock()
+	infoConcentratorStats = cs
+	infoMu.Unlock()
+}
+
+func publishConcentratorStats() interface{} {
+	infoMu.RLock()
+	cs := infoConcentratorStats
+	infoMu.RUnlock()
+	return cs
+}
+
+func updateCardinality(card []model.ServiceCardinality) {
+	infoMu.Lock()
+	infoCardinality = card
+	infoMu.Unlock()
+}
+
+func publishCardinality() interface{} {
+	infoMu.RLock()
+	card := infoCardinality
+	infoMu.RUnlock()
+	return card
+}
+
 type infoVersion struct {
 	Version   string
 	GitCommit string
@@ -205,12 +283,22 @@ func initInfo(conf *config.AgentConfig) error {
 		expvar.Publish("version", expvar.Func(publishVersion))
 		expvar.Publish("receiver", expvar.Func(publishReceiverStats))
 		expvar.Publish("endpoint", expvar.Func(publishEndpointStats))
+		expvar.Publish("writer", expvar.Func(publishWriterInfo))
 		expvar.Publish("sampler", expvar.Func(publishSamplerInfo))
 		expvar.Publish("watchdog", expvar.Func(publishWatchdogInfo))
+		expvar.Publish("degradation", expvar.Func(publishDegradationInfo))
 		expvar.Publish("presampler", expvar.Func(publishPreSamplerStats))
+		expvar.Publish("assembler", expvar.Func(publishAssemblerStats))
+		expvar.Publish("concentrator", expvar.Func(publishConcentratorStats))
+		expvar.Publish("cardinality", expvar.Func(publishCardinality))
 
 		c := *conf
 		c.APIKey = "" // should not be exported by JSON, but just to make sure
+		if c.Proxy != nil {
+			proxy := *c.Proxy
+			proxy.Password = ""
+			c.Proxy = &proxy
+		}
 		var buf []byte
 		buf, err = json.Marshal(&c)
 		if err != nil {
@@ -253,12 +341,16 @@ type StatusInfo struct {
 	MemStats struct {
 		Alloc uint64
 	} `json:"memstats"`
-	Version    infoVersion             `json:"version"`
-	Receiver   []tagStats              `json:"receiver"`
-	Endpoint   endpointStats           `json:"endpoint"`
-	Watchdog   watchdog.Info           `json:"watchdog"`
-	PreSampler sampler.PreSamplerStats `json:"presampler"`
-	Config     config.AgentConfig      `json:"config"`
+	Version      infoVersion             `json:"version"`
+	Receiver     []tagStats              `json:"receiver"`
+	Endpoint     endpointStats           `json:"endpoint"`
+	Concentrator concentratorStats       `json:"concentrator"`
+	Writer       writerInfo              `json:"writer"`
+	Sampler      samplerInfo             `json:"sampler"`
+	Watchdog     watchdog.Info           `json:"watchdog"`
+	Degradation  degradationInfo         `json:"degradation"`
+	PreSampler   sampler.PreSamplerStats `json:"presampler"`
+	Config       config.AgentConfig      `json:"config"`
 }
 
 func getProgramBanner(version string) (string, string) {
@@ -301,6 +393,8 @@ func getProgramBanner(version string) (string, string) {
 //   Bytes sent (1 min): 3245
 //   Traces sent (1 min): 6
 //   Stats sent (1 min): 60
+//   Spans without stats, not top-level (1 min): 120
+//   Spans with forced stats (1 min): 4
 //   WARNING: Traces API errors (1 min): 1/3
 //   WARNING: Services API errors (1 min): 1/1
 //
@@ -334,14 +428,8 @@ func getProgramBanner(version string) (string, string) {
 // -----8<-------------------------------------------------------
 //
 func Info(w io.Writer, conf *config.AgentConfig) error {
-	host := conf.ReceiverHost
-	if host == "0.0.0.0" {
-		host = "127.0.0.1" // [FIXME:christian] not fool-proof
-	}
-	url := "http://localhost:" + strconv.Itoa(conf.ReceiverPort) + "/debug/vars"
-	client := http.Client{Timeout: 3 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
+	info, url, running, err := getStatusInfo(conf)
+	if !running {
 		// OK, here, we can't even make an http call on the agent port,
 		// so we can assume it's not even running, or at least, not with
 		// these parameters. We display the port as a hint on where to
@@ -359,10 +447,7 @@ func Info(w io.Writer, conf *config.AgentConfig) error {
 		return err
 	}
 
-	defer resp.Body.Close() // OK to defer, this is not on hot path
-
-	var info StatusInfo
-	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+	if err != nil {
 		program, banner := getProgramBanner(Version)
 		_ = infoErrorTmpl.Execute(w, struct {
 			Banner  string
@@ -387,10 +472,34 @@ func Info(w io.Writer, conf *config.AgentConfig) error {
 	}{
 		Banner:  banner,
 		Program: program,
-		Status:  &info,
+		Status:  info,
 	})
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// getStatusInfo queries the expvar variables of the agent running with conf.
+// It returns them along with the URL queried, and whether the agent is
+// running, errors meaning it is not if running is false.
+func getStatusInfo(conf *config.AgentConfig) (*StatusInfo, string, bool, error) {
+	host := conf.ReceiverHost
+	if host == "0.0.0.0" {
+		host = "127.0.0.1" // [FIXME:christian] not fool-proof
+	}
+	url := "http://localhost:" + strconv.Itoa(conf.ReceiverPort) + "/debug/vars"
+	client := http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, url, false, err
+	}
+
+	defer resp.Body.Close() // OK to defer, this is not on hot path
+
+	var info StatusInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, url, true, err
+	}
+	return &info, url, true, nil
+}
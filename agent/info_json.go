@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+)
+
+// infoStatus is the status of the running agent as reported by InfoJSON.
+// It is the exit code of `-info -json`, so values must never change.
+type infoStatus int
+
+const (
+	// infoOK is when the agent runs without any trouble.
+	infoOK infoStatus = 0
+	// infoError is when the agent could not be queried properly.
+	infoError infoStatus = 1
+	// infoNotRunning is when no agent answers on the receiver port.
+	infoNotRunning infoStatus = 2
+	// infoDegraded is when the agent drops data, fails to send it, or
+	// is above its resource limits.
+	infoDegraded infoStatus = 3
+)
+
+func (s infoStatus) String() string {
+	switch s {
+	case infoOK:
+		return "ok"
+	case infoNotRunning:
+		return "not_running"
+	case infoDegraded:
+		return "degraded"
+	}
+	return "error"
+}
+
+// infoJSON is what `-info -json` writes. Tools parse it, so fields must
+// not be renamed.
+type infoJSON struct {
+	// Status is one of ok, error, not_running or degraded.
+	Status string `json:"status"`
+	// Warnings tells why the agent is degraded.
+	Warnings []string `json:"warnings,omitempty"`
+	// Error is why the agent could not be queried.
+	Error string `json:"error,omitempty"`
+	// URL is where the agent was queried.
+	URL string `json:"url"`
+	// Info is what the agent reported, if it could be queried.
+	Info *StatusInfo `json:"info,omitempty"`
+}
+
+// warnings returns the reasons why the agent is degraded, the same as the
+// warnings displayed by Info.
+func (info *StatusInfo) warnings() []string {
+	var warnings []string
+
+	var tracesDropped, spansDropped int64
+	for _, ts := range info.Receiver {
+		tracesDropped += ts.TracesDropped
+		spansDropped += ts.SpansDropped
+	}
+	if tracesDropped > 0 {
+		warnings = append(warnings, fmt.Sprintf("traces dropped (1 min): %d", tracesDropped))
+	}
+	if spansDropped > 0 {
+		warnings = append(warnings, fmt.Sprintf("spans dropped (1 min): %d", spansDropped))
+	}
+
+	es := info.Endpoint
+	if es.TracesPayloadError > 0 {
+		warnings = append(warnings, fmt.Sprintf("traces API errors (1 min): %d/%d", es.TracesPayloadError, es.TracesPayload))
+	}
+	if es.ServicesPayloadError > 0 {
+		warnings = append(warnings, fmt.Sprintf("services API errors (1 min): %d/%d", es.ServicesPayloadError, es.ServicesPayload))
+	}
+
+	if info.PreSampler.Rate < 1 {
+		warnings = append(warnings, fmt.Sprintf("pre-sampling traces: %0.1f %%", info.PreSampler.Rate*100))
+	}
+	if info.PreSampler.Error != "" {
+		warnings = append(warnings, "pre-sampler: "+info.PreSampler.Error)
+	}
+
+	if d := info.Degradation; d.Level != "" && d.Level != levelNormal.String() {
+		warnings = append(warnings, fmt.Sprintf("resource usage %s: %s", d.Level, d.Reason))
+	}
+
+	return warnings
+}
+
+// InfoJSON writes the status of the agent running with conf as JSON, and
+// returns it, to be used as exit code.
+func InfoJSON(w io.Writer, conf *config.AgentConfig) infoStatus {
+	info, url, running, err := getStatusInfo(conf)
+
+	out := infoJSON{URL: url, Info: info}
+	status := infoOK
+	switch {
+	case !running:
+		status = infoNotRunning
+		out.Error = err.Error()
+	case err != nil:
+		status = infoError
+		out.Error = err.Error()
+	default:
+		out.Warnings = info.warnings()
+		if len(out.Warnings) > 0 {
+			status = infoDegraded
+		}
+	}
+	return writeInfoJSON(w, out, status)
+}
+
+// InfoJSONError writes as JSON the error which prevented querying the
+// agent, such as an invalid config, and returns infoError.
+func InfoJSONError(w io.Writer, err error) infoStatus {
+	return writeInfoJSON(w, infoJSON{Error: err.Error()}, infoError)
+}
+
+func writeInfoJSON(w io.Writer, out infoJSON, status infoStatus) infoStatus {
+	out.Status = status.String()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return infoError
+	}
+	return status
+}
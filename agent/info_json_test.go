@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+)
+
+// testInfoJSON runs InfoJSON against server, and returns the status and
+// the decoded output.
+func testInfoJSON(t *testing.T, server *httptest.Server) (infoStatus, map[string]interface{}) {
+	conf := testInit(t)
+
+	u, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	conf.ReceiverPort, err = strconv.Atoi(u.Port())
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	status := InfoJSON(&buf, conf)
+	t.Logf("Info:\n%s\n", buf.String())
+
+	var out map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &out))
+	return status, out
+}
+
+func TestInfoJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	server := testServer(t)
+	defer server.Close()
+
+	status, out := testInfoJSON(t, server)
+	assert.Equal(infoOK, status)
+	assert.Equal("ok", out["status"])
+	assert.Nil(out["warnings"])
+	assert.Nil(out["error"])
+
+	info := out["info"].(map[string]interface{})
+	for _, k := range []string{"pid", "uptime", "version", "receiver", "endpoint", "writer", "sampler", "watchdog", "degradation", "presampler", "config"} {
+		assert.Contains(info, k)
+	}
+	assert.Equal(float64(38149), info["pid"])
+	assert.Equal("localhost.localdomain", info["config"].(map[string]interface{})["HostName"])
+	assert.NotContains(info["config"], "APIKey")
+}
+
+func TestInfoJSONDegraded(t *testing.T) {
+	assert := assert.New(t)
+
+	server := testServerWarning(t)
+	defer server.Close()
+
+	status, out := testInfoJSON(t, server)
+	assert.Equal(infoDegraded, status)
+	assert.Equal("degraded", out["status"])
+	assert.Equal([]interface{}{
+		"traces dropped (1 min): 23",
+		"spans dropped (1 min): 184",
+		"traces API errors (1 min): 3/4",
+		"services API errors (1 min): 1/2",
+		"pre-sampling traces: 42.1 %",
+		"pre-sampler: raising pre-sampling rate from 3.1 % to 5.0 %",
+	}, out["warnings"])
+}
+
+func TestInfoJSONResourceUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	info := StatusInfo{}
+	info.PreSampler.Rate = 1
+	assert.Nil(info.warnings())
+
+	info.Degradation = degradationInfo{Level: "normal"}
+	assert.Nil(info.warnings())
+
+	info.Degradation = degradationInfo{Level: "degraded", Reason: "exceeded soft memory limit (current=900, soft limit=800)"}
+	assert.Equal([]string{"resource usage degraded: exceeded soft memory limit (current=900, soft limit=800)"}, info.warnings())
+}
+
+func TestInfoJSONNotRunning(t *testing.T) {
+	assert := assert.New(t)
+
+	server := testServer(t)
+	server.Close()
+
+	status, out := testInfoJSON(t, server)
+	assert.Equal(infoNotRunning, status)
+	assert.Equal("not_running", out["status"])
+	assert.NotEmpty(out["error"])
+	assert.Nil(out["info"])
+}
+
+func TestInfoJSONError(t *testing.T) {
+	assert := assert.New(t)
+
+	server := testServerError(t)
+	defer server.Close()
+
+	status, out := testInfoJSON(t, server)
+	assert.Equal(infoError, status)
+	assert.Equal("error", out["status"])
+	assert.NotEmpty(out["error"])
+	assert.Nil(out["info"])
+}
+
+func TestInfoJSONConfigError(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := config.NewAgentConfig(nil, nil)
+	assert.NotNil(err)
+
+	var buf bytes.Buffer
+	assert.Equal(infoError, InfoJSONError(&buf, err))
+
+	var out map[string]interface{}
+	assert.Nil(json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal("error", out["status"])
+	assert.Equal(err.Error(), out["error"])
+	assert.Nil(out["info"])
+}
@@ -13,6 +13,7 @@ import (
 type StoppableListener struct {
 	exit      chan struct{}
 	connLease int32 // How many connections are available for this listener before rate-limiting kicks in
+	rejecting int32 // 1 if new connections are closed right away
 	*net.TCPListener
 }
 
@@ -37,6 +38,16 @@ func (sl *StoppableListener) Refresh(conns int) {
 	}
 }
 
+// RejectConnections makes the listener close new connections right away,
+// or accept them again.
+func (sl *StoppableListener) RejectConnections(reject bool) {
+	var v int32
+	if reject {
+		v = 1
+	}
+	atomic.StoreInt32(&sl.rejecting, v)
+}
+
 // RateLimitedError  indicates a user request being blocked by our rate limit
 // It satisfies the net.Error interface
 type RateLimitedError struct{}
@@ -81,6 +92,9 @@ func (sl *StoppableListener) Accept() (net.Conn, error) {
 			if ok && netErr.Timeout() && netErr.Temporary() {
 				continue
 			}
+		} else if atomic.LoadInt32(&sl.rejecting) != 0 {
+			newConn.Close()
+			continue
 		}
 
 		// decrement available conns
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"time"
 
@@ -16,10 +18,10 @@ const agentLoggerConfigFmt = `
 <seelog minlevel="%[1]s">
   <outputs formatid="agent">
     <filter levels="warn,error">
-      <custom name="throttled" data-interval="%[2]d" data-max-per-interval="%[3]d" data-file-path="%[4]s" />
+      <custom name="throttled" data-interval="%[2]d" data-max-per-interval="%[3]d" data-file-path="%[4]s" data-stderr="%[5]t" />
     </filter>
     <filter levels="trace,debug,info,critical">
-      <console />
+      %[6]s
       <rollingfile type="size" filename="%[4]s" maxsize="10000000" maxrolls="5" />
     </filter>
   </outputs>
@@ -32,8 +34,8 @@ const agentLoggerConfigFmt = `
 const rawLoggerConfigFmt = `
 <seelog>
   <outputs formatid="agent">
-      <console />
-      <rollingfile type="size" filename="%s" maxsize="10000000" maxrolls="5" />
+      %[1]s
+      <rollingfile type="size" filename="%[2]s" maxsize="10000000" maxrolls="5" />
   </outputs>
   <formats>
     <format id="agent" format="%%Date %%Time %%LEVEL (%%File:%%Line) - %%Msg%%n" />
@@ -44,8 +46,8 @@ const rawLoggerConfigFmt = `
 const rawLoggerNoFmtConfigFmt = `
 <seelog>
   <outputs formatid="raw">
-      <console />
-      <rollingfile type="size" filename="%s" maxsize="10000000" maxrolls="5" />
+      %[1]s
+      <rollingfile type="size" filename="%[2]s" maxsize="10000000" maxrolls="5" />
   </outputs>
   <formats>
     <format id="raw" format="%%Msg" />
@@ -53,6 +55,38 @@ const rawLoggerNoFmtConfigFmt = `
 </seelog>
 `
 
+// consoleOutput returns the seelog output of the logs shown on the console,
+// which is stdout unless stderr is true.
+func consoleOutput(stderr bool) string {
+	if stderr {
+		return `<custom name="stderr" />`
+	}
+	return "<console />"
+}
+
+// StderrReceiver is a custom seelog receiver writing log messages to
+// stderr, which replaces the console when stdout is used for payloads.
+type StderrReceiver struct{}
+
+// ReceiveMessage implements log.CustomReceiver
+func (r *StderrReceiver) ReceiveMessage(msg string, _ log.LogLevel, _ log.LogContextInterface) error {
+	_, err := io.WriteString(os.Stderr, msg)
+	return err
+}
+
+// AfterParse implements log.CustomReceiver
+func (r *StderrReceiver) AfterParse(_ log.CustomReceiverInitArgs) error {
+	return nil
+}
+
+// Flush implements log.CustomReceiver
+func (r *StderrReceiver) Flush() {}
+
+// Close implements log.CustomReceiver
+func (r *StderrReceiver) Close() error {
+	return nil
+}
+
 // forwardLogMsg forwards the given message to the given logger making
 // sure the log level is kept.
 func forwardLogMsg(logger log.LoggerInterface, msg string, lvl log.LogLevel) {
@@ -118,15 +152,19 @@ func (r *ThrottledReceiver) AfterParse(args log.CustomReceiverInitArgs) error {
 	// Parse the logFilePath attribute
 	logFilePath := args.XmlCustomAttrs["file-path"]
 
+	// Parse the stderr attribute (no verification needed, its a boolean
+	// for sure)
+	stderr, _ := strconv.ParseBool(args.XmlCustomAttrs["stderr"])
+
 	// Setup rawLogger
-	rawLoggerConfig := fmt.Sprintf(rawLoggerConfigFmt, logFilePath)
+	rawLoggerConfig := fmt.Sprintf(rawLoggerConfigFmt, consoleOutput(stderr), logFilePath)
 	rawLogger, err := log.LoggerFromConfigAsString(rawLoggerConfig)
 	if err != nil {
 		return err
 	}
 
 	// Setup rawLoggerNoFmt
-	rawLoggerNoFmtConfig := fmt.Sprintf(rawLoggerNoFmtConfigFmt, logFilePath)
+	rawLoggerNoFmtConfig := fmt.Sprintf(rawLoggerNoFmtConfigFmt, consoleOutput(stderr), logFilePath)
 	rawLoggerNoFmt, err := log.LoggerFromConfigAsString(rawLoggerNoFmtConfig)
 	if err != nil {
 		return err
@@ -194,8 +232,12 @@ func (r *ThrottledReceiver) Close() error {
 //   "logsDropMaxPerInterval" number of messages are showed. The
 //   counter is reset every "logsDropInterval". If "logsDropInterval"
 //   is 0, dropping is disabled (and might flood your logs!).
-func SetupLogger(minLogLvl log.LogLevel, logFilePath string, logsDropInterval time.Duration, logsDropMaxPerInterval int) error {
+// * Logs showed on the console go to stderr rather than stdout if
+//   "consoleStderr" is true, so that they don't mix with the payloads
+//   written to stdout.
+func SetupLogger(minLogLvl log.LogLevel, logFilePath string, logsDropInterval time.Duration, logsDropMaxPerInterval int, consoleStderr bool) error {
 	log.RegisterReceiver("throttled", &ThrottledReceiver{})
+	log.RegisterReceiver("stderr", &StderrReceiver{})
 
 	// Build our config string
 	config := fmt.Sprintf(
@@ -204,6 +246,8 @@ func SetupLogger(minLogLvl log.LogLevel, logFilePath string, logsDropInterval ti
 		logsDropInterval,
 		logsDropMaxPerInterval,
 		logFilePath,
+		consoleStderr,
+		consoleOutput(consoleStderr),
 	)
 
 	logger, err := log.LoggerFromConfigAsString(config)
@@ -216,7 +260,7 @@ func SetupLogger(minLogLvl log.LogLevel, logFilePath string, logsDropInterval ti
 // SetupDefaultLogger sets up a default logger for the agent, showing
 // all log messages and with no throttling.
 func SetupDefaultLogger() error {
-	config := fmt.Sprintf(rawLoggerConfigFmt, defaultLogFilePath)
+	config := fmt.Sprintf(rawLoggerConfigFmt, consoleOutput(false), defaultLogFilePath)
 
 	logger, err := log.LoggerFromConfigAsString(config)
 	if err != nil {
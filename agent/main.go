@@ -17,6 +17,7 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/DataDog/datadog-trace-agent/statsd"
 	"github.com/DataDog/datadog-trace-agent/watchdog"
 )
@@ -39,10 +40,16 @@ func handleSignal(exit chan struct{}) {
 
 // die logs an error message and makes the program exit immediately.
 func die(format string, args ...interface{}) {
-	if opts.info || opts.version {
+	if opts.info && opts.infoJSON {
+		// tools parse the output, whatever went wrong
+		InfoJSONError(os.Stdout, fmt.Errorf(format, args...))
+	} else if opts.info || opts.version {
 		// here, we've silenced the logger, and just want plain console output
 		fmt.Printf(format, args...)
 		fmt.Print("")
+	} else if opts.replay != "" && opts.replayOutput == "" {
+		// stdout is for the replayed payloads
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
 	} else {
 		log.Errorf(format, args...)
 		log.Flush()
@@ -57,6 +64,10 @@ var opts struct {
 	logLevel     string
 	version      bool
 	info         bool
+	infoJSON     bool
+	checkConfig  bool
+	replay       string
+	replayOutput string
 	cpuprofile   string
 	memprofile   string
 }
@@ -106,6 +117,10 @@ func init() {
 	flag.StringVar(&opts.configFile, "config", "/etc/datadog/trace-agent.ini", "Trace agent ini config file.")
 	flag.BoolVar(&opts.version, "version", false, "Show version information and exit")
 	flag.BoolVar(&opts.info, "info", false, "Show info about running trace agent process and exit")
+	flag.BoolVar(&opts.infoJSON, "json", false, "With -info, show info as JSON, and exit with 1 on error, 2 if not running, 3 if degraded")
+	flag.BoolVar(&opts.checkConfig, "check-config", false, "Show the resolved configuration with the source of each value, and exit with 1 if it is invalid")
+	flag.StringVar(&opts.replay, "replay", "", "Process the requests captured in `file`, write the resulting payloads as JSON and exit")
+	flag.StringVar(&opts.replayOutput, "replay-output", "", "With -replay, write each payload to a file in `dir` instead of stdout")
 
 	// profiling arguments
 	flag.StringVar(&opts.cpuprofile, "cpuprofile", "", "Write cpu profile to file")
@@ -117,7 +132,7 @@ func init() {
 // main is the entrypoint of our code
 func main() {
 	// configure a default logger before anything so we can observe initialization
-	if opts.info || opts.version {
+	if opts.info || opts.version || opts.checkConfig || (opts.replay != "" && opts.replayOutput == "") {
 		log.UseLogger(log.Disabled)
 	} else {
 		SetupDefaultLogger()
@@ -149,10 +164,12 @@ func main() {
 	// if a configuration file cannot be loaded, log an error but do not
 	// panic since the agent can be configured with environment variables
 	// only.
+	var fileErrs []error
 	legacyConf, err := config.NewIfExists(opts.configFile)
 	if err != nil {
 		log.Errorf("%s: %v", opts.configFile, err)
 		log.Warnf("ignoring %s", opts.configFile)
+		fileErrs = append(fileErrs, fmt.Errorf("%s: %v", opts.configFile, err))
 	}
 	if legacyConf != nil {
 		log.Infof("using legacy configuration from %s", opts.configFile)
@@ -162,21 +179,39 @@ func main() {
 	if err != nil {
 		log.Errorf("%s: %v", opts.ddConfigFile, err)
 		log.Warnf("ignoring %s", opts.ddConfigFile)
+		fileErrs = append(fileErrs, fmt.Errorf("%s: %v", opts.ddConfigFile, err))
 	}
 	if conf != nil {
 		log.Infof("using configuration from %s", opts.ddConfigFile)
 	}
 
-	agentConf, err = config.NewAgentConfig(conf, legacyConf)
+	// in a container, the limits of its cgroup are the ones which matter
+	limits, err := watchdog.ReadCgroupLimits(watchdog.DefaultCgroupRoot)
 	if err != nil {
-		die("%v", err)
+		log.Debugf("not using cgroup limits: %v", err)
+	}
+
+	if opts.checkConfig {
+		if !checkConfig(os.Stdout, conf, legacyConf, limits, fileErrs) {
+			os.Exit(1)
+		}
+		return
 	}
 
+	agentConf, err = config.NewAgentConfig(conf, legacyConf)
+	if err != nil && opts.replay == "" {
+		die("%v", err) // no API key needed to replay
+	}
+	agentConf.ApplyCgroupLimits(limits.CPU, float64(limits.Memory))
+
 	err = initInfo(agentConf) // for expvar & -info option
 	if err != nil {
 		panic(err)
 	}
 
+	if opts.info && opts.infoJSON {
+		os.Exit(int(InfoJSON(os.Stdout, agentConf)))
+	}
 	if opts.info {
 		if err := Info(os.Stdout, agentConf); err != nil {
 			// need not display the error, Info should do it already
@@ -185,6 +220,13 @@ func main() {
 		return
 	}
 
+	if opts.replay != "" {
+		if err := Replay(agentConf, opts.replay, os.Stdout, opts.replayOutput); err != nil {
+			die("cannot replay %s: %v", opts.replay, err)
+		}
+		return
+	}
+
 	// Exit if tracing is not enabled
 	if !agentConf.Enabled {
 		log.Info(agentDisabledMessage)
@@ -207,7 +249,8 @@ func main() {
 	if !agentConf.LogThrottlingEnabled {
 		duration = 0
 	}
-	err = SetupLogger(logLevel, agentConf.LogFilePath, duration, 10)
+	// stdout may be the output of the payloads, keep it for them
+	err = SetupLogger(logLevel, agentConf.LogFilePath, duration, 10, agentConf.APIOutput == "stdout")
 	if err != nil {
 		die("cannot create logger: %v", err)
 	}
@@ -221,6 +264,10 @@ func main() {
 	// Seed rand
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	// payloads are encoded as configured for all the endpoints
+	model.GlobalAgentPayloadVersion = agentConf.APIPayloadVersion
+	model.GlobalAgentPayloadCompression = agentConf.APIPayloadCompression
+
 	agent := NewAgent(agentConf)
 
 	// Handle stops properly
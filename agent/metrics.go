@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// metricsPrefix prefixes the names of all the metrics served on /metrics.
+	metricsPrefix = "trace_agent_"
+
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// metricLabel is a label of a metric sample.
+type metricLabel struct {
+	name, value string
+}
+
+// metricsBuffer formats metrics in the Prometheus text exposition format,
+// or in the OpenMetrics one, which only differ in how counters are declared.
+type metricsBuffer struct {
+	bytes.Buffer
+	openMetrics bool
+}
+
+// gauge writes the header of a gauge metric family.
+func (mb *metricsBuffer) gauge(name, help string) {
+	fmt.Fprintf(mb, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(mb, "# TYPE %s%s gauge\n", metricsPrefix, name)
+}
+
+// counter writes the header of a counter metric family, whose samples are
+// named with the _total suffix. OpenMetrics declares the family without it.
+func (mb *metricsBuffer) counter(name, help string) {
+	if mb.openMetrics {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(mb, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(mb, "# TYPE %s%s counter\n", metricsPrefix, name)
+}
+
+// sample writes a sample of the metric family name.
+func (mb *metricsBuffer) sample(name string, v float64, labels ...metricLabel) {
+	mb.WriteString(metricsPrefix)
+	mb.WriteString(name)
+	if len(labels) > 0 {
+		mb.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				mb.WriteByte(',')
+			}
+			mb.WriteString(l.name)
+			mb.WriteString(`="`)
+			mb.WriteString(escapeLabelValue(l.value))
+			mb.WriteByte('"')
+		}
+		mb.WriteByte('}')
+	}
+	mb.WriteByte(' ')
+	mb.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	mb.WriteByte('\n')
+}
+
+// single writes a gauge metric family with a single sample.
+func (mb *metricsBuffer) single(name, help string, v float64) {
+	mb.gauge(name, help)
+	mb.sample(name, v)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+// labels returns the non-empty tags as metric labels.
+func (t *Tags) labels() []metricLabel {
+	labels := make([]metricLabel, 0, 4)
+	if t.Lang != "" {
+		labels = append(labels, metricLabel{"lang", t.Lang})
+	}
+	if t.LangVersion != "" {
+		labels = append(labels, metricLabel{"lang_version", t.LangVersion})
+	}
+	if t.Interpreter != "" {
+		labels = append(labels, metricLabel{"interpreter", t.Interpreter})
+	}
+	if t.TracerVersion != "" {
+		labels = append(labels, metricLabel{"tracer_version", t.TracerVersion})
+	}
+	return labels
+}
+
+// receiverMetrics are the receiver stats exposed per tags, as counters.
+var receiverMetrics = []struct {
+	name, help string
+	value      func(s *Stats) int64
+}{
+	{"receiver_traces_received_total", "Traces received, including the dropped ones.", func(s *Stats) int64 { return s.TracesReceived }},
+	{"receiver_traces_dropped_total", "Traces dropped.", func(s *Stats) int64 { return s.TracesDropped }},
+	{"receiver_traces_filtered_total", "Traces filtered.", func(s *Stats) int64 { return s.TracesFiltered }},
+	{"receiver_traces_bytes_total", "Bytes received on the traces endpoint.", func(s *Stats) int64 { return s.TracesBytes }},
+	{"receiver_spans_received_total", "Spans received, including the dropped ones.", func(s *Stats) int64 { return s.SpansReceived }},
+	{"receiver_spans_dropped_total", "Spans dropped.", func(s *Stats) int64 { return s.SpansDropped }},
+	{"receiver_spans_filtered_total", "Spans filtered.", func(s *Stats) int64 { return s.SpansFiltered }},
+	{"receiver_services_received_total", "Services received.", func(s *Stats) int64 { return s.ServicesReceived }},
+	{"receiver_services_bytes_total", "Bytes received on the services endpoint.", func(s *Stats) int64 { return s.ServicesBytes }},
+	{"receiver_traces_multiple_roots_total", "Traces received with more than one root span.", func(s *Stats) int64 { return s.TracesMultipleRoots }},
+	{"receiver_spans_orphan_total", "Spans received whose parent is not part of their trace.", func(s *Stats) int64 { return s.SpansOrphan }},
+	{"receiver_spans_cycle_total", "Spans received which are their own ancestor.", func(s *Stats) int64 { return s.SpansCycle }},
+	{"receiver_spans_duplicate_id_total", "Spans received with the ID of another span of their trace.", func(s *Stats) int64 { return s.SpansDuplicateID }},
+	{"receiver_spans_mixed_trace_id_total", "Spans received with a trace ID different from their trace.", func(s *Stats) int64 { return s.SpansMixedTraceID }},
+}
+
+// writeMetrics writes the stats published through expvar as metrics, the
+// counters being fed from the totals since the agent started.
+func writeMetrics(mb *metricsBuffer) {
+	infoMu.RLock()
+	rs := make([]tagStats, 0, len(infoReceiverTotals.Stats))
+	for _, ts := range infoReceiverTotals.Stats {
+		rs = append(rs, *ts)
+	}
+	es := infoEndpointTotals
+	wri := infoWriterInfo
+	si := infoSamplerInfo
+	ps := infoPreSamplerStats
+	wi := infoWatchdogInfo
+	infoMu.RUnlock()
+
+	for _, m := range receiverMetrics {
+		mb.counter(m.name, m.help)
+		for i := range rs {
+			mb.sample(m.name, float64(m.value(&rs[i].Stats)), rs[i].Tags.labels()...)
+		}
+	}
+
+	mb.counter("endpoint_payloads_total", "Payloads sent, including errors.")
+	mb.sample("endpoint_payloads_total", float64(es.TracesPayload), metricLabel{"type", "traces"})
+	mb.sample("endpoint_payloads_total", float64(es.ServicesPayload), metricLabel{"type", "services"})
+	mb.counter("endpoint_payload_errors_total", "Payloads sent with an error.")
+	mb.sample("endpoint_payload_errors_total", float64(es.TracesPayloadError), metricLabel{"type", "traces"})
+	mb.sample("endpoint_payload_errors_total", float64(es.ServicesPayloadError), metricLabel{"type", "services"})
+	mb.counter("endpoint_bytes_total", "Bytes of payloads sent, including errors.")
+	mb.sample("endpoint_bytes_total", float64(es.TracesBytes), metricLabel{"type", "traces"})
+	mb.sample("endpoint_bytes_total", float64(es.ServicesBytes), metricLabel{"type", "services"})
+	mb.counter("endpoint_traces_total", "Traces sent, including errors.")
+	mb.sample("endpoint_traces_total", float64(es.TracesCount))
+	mb.counter("endpoint_stats_total", "Stats buckets sent, including errors.")
+	mb.sample("endpoint_stats_total", float64(es.TracesStats))
+
+	mb.single("writer_payload_buffer_len", "Payloads waiting to be sent.", float64(wri.PayloadBufferLen))
+	mb.single("writer_payload_buffer_size", "Size of the payloads waiting to be sent, in bytes.", float64(wri.PayloadBufferSize))
+
+	mb.single("sampler_in_tps", "Traces per second seen by the sampler.", si.State.InTPS)
+	mb.single("sampler_out_tps", "Traces per second kept by the sampler.", si.State.OutTPS)
+	mb.single("sampler_max_tps", "Maximum number of traces per second kept by the sampler, 0 for no limit.", si.State.MaxTPS)
+
+	mb.single("presampler_rate", "Rate of the payloads accepted by the pre-sampler.", ps.Rate)
+	mb.single("presampler_recent_traces_seen", "Traces recently seen by the pre-sampler.", ps.RecentTracesSeen)
+	mb.single("presampler_recent_traces_dropped", "Traces recently dropped by the pre-sampler.", ps.RecentTracesDropped)
+
+	mb.single("watchdog_cpu_user", "Average user CPU usage, 1 meaning a full core.", wi.CPU.UserAvg)
+	mb.single("watchdog_mem_alloc", "Bytes allocated and not yet freed.", float64(wi.Mem.Alloc))
+	mb.single("watchdog_mem_alloc_per_sec", "Bytes allocated per second.", wi.Mem.AllocPerSec)
+	mb.single("watchdog_net_connections", "Connections opened by the agent.", float64(wi.Net.Connections))
+	mb.single("watchdog_cgroup_cpu_limit", "CPU quota of the cgroup of the agent, in cores, 0 if unlimited.", wi.Cgroup.CPU)
+	mb.single("watchdog_cgroup_memory_limit", "Memory limit of the cgroup of the agent, in bytes, 0 if unlimited.", float64(wi.Cgroup.Memory))
+	mb.single("watchdog_cgroup_throttled_ratio", "Fraction of the CPU periods during which the cgroup of the agent was throttled.", wi.Cgroup.ThrottledRatio)
+}
+
+// handleMetrics serves the agent stats as Prometheus metrics, or OpenMetrics
+// if asked for, on /metrics.
+func handleMetrics(w http.ResponseWriter, req *http.Request) {
+	mb := metricsBuffer{
+		openMetrics: strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text"),
+	}
+	writeMetrics(&mb)
+
+	if mb.openMetrics {
+		mb.WriteString("# EOF\n")
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", prometheusContentType)
+	}
+	w.Write(mb.Bytes())
+}
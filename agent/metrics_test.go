@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/sampler"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+// resetMetricsTotals resets the totals the metrics counters are fed from.
+func resetMetricsTotals() {
+	infoMu.Lock()
+	infoReceiverTotals = newReceiverStats()
+	infoEndpointTotals = endpointStats{}
+	infoMu.Unlock()
+}
+
+func TestHandleMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	resetMetricsTotals()
+	defer resetMetricsTotals()
+
+	// counters add up the stats of each minute
+	rs := newReceiverStats()
+	ts := rs.getTagStats(Tags{Lang: "python", TracerVersion: `0.9"dev`})
+	ts.TracesReceived = 42
+	ts.SpansDropped = 3
+	updateReceiverStats(rs)
+	rs.reset()
+	ts.TracesReceived = 8
+	updateReceiverStats(rs)
+	updateEndpointStats(endpointStats{TracesPayload: 6, ServicesPayloadError: 1})
+	updateEndpointStats(endpointStats{TracesPayload: 4})
+	updateWriterInfo(writerInfo{PayloadBufferLen: 2, PayloadBufferSize: 2048})
+	updatePreSampler(sampler.PreSamplerStats{Rate: 0.5})
+	updateWatchdogInfo(watchdog.Info{CPU: watchdog.CPUInfo{UserAvg: 0.25}})
+	defer func() {
+		updateReceiverStats(newReceiverStats())
+		updateEndpointStats(endpointStats{})
+		updateWriterInfo(writerInfo{})
+		updatePreSampler(sampler.PreSamplerStats{})
+		updateWatchdogInfo(watchdog.Info{})
+	}()
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(prometheusContentType, rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# HELP trace_agent_receiver_traces_received_total Traces received, including the dropped ones.",
+		"# TYPE trace_agent_receiver_traces_received_total counter",
+		`trace_agent_receiver_traces_received_total{lang="python",tracer_version="0.9\"dev"} 50`,
+		`trace_agent_receiver_spans_dropped_total{lang="python",tracer_version="0.9\"dev"} 3`,
+		`trace_agent_endpoint_payloads_total{type="traces"} 10`,
+		`trace_agent_endpoint_payload_errors_total{type="services"} 1`,
+		"# TYPE trace_agent_writer_payload_buffer_size gauge",
+		"trace_agent_writer_payload_buffer_size 2048",
+		"trace_agent_presampler_rate 0.5",
+		"trace_agent_watchdog_cpu_user 0.25",
+	} {
+		assert.Contains(body, line+"\n")
+	}
+	assert.False(strings.HasSuffix(body, "# EOF\n"))
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	handleMetrics(rec, req)
+	assert.Equal(openMetricsContentType, rec.Header().Get("Content-Type"))
+	body = rec.Body.String()
+	assert.Contains(body, "# TYPE trace_agent_receiver_traces_received counter\n")
+	assert.Contains(body, `trace_agent_receiver_traces_received_total{lang="python",tracer_version="0.9\"dev"} 50`+"\n")
+	assert.True(strings.HasSuffix(body, "# EOF\n"))
+}
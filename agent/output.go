@@ -0,0 +1,235 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+// outputRecord is a line written by an OutputEndpoint, holding either a
+// payload along with its extra metadata, or a services update.
+type outputRecord struct {
+	Time     time.Time              `json:"time"`
+	Payload  *model.AgentPayload    `json:"payload,omitempty"`
+	Extras   map[string]string      `json:"extras,omitempty"`
+	Services model.ServicesMetadata `json:"services,omitempty"`
+}
+
+// OutputEndpoint implements AgentEndpoint to write the payloads and the
+// services updates as newline-delimited JSON to a local output, instead of
+// sending them to the API.
+type OutputEndpoint struct {
+	name  string
+	stats endpointStats
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewOutputEndpoint returns a new OutputEndpoint writing to the output set
+// in the config, either stdout or rotated files in a directory.
+func NewOutputEndpoint(conf *config.AgentConfig) *OutputEndpoint {
+	var oe *OutputEndpoint
+	if conf.APIOutput == "stdout" {
+		oe = &OutputEndpoint{name: "stdout", w: os.Stdout}
+	} else {
+		u, _ := url.Parse(conf.APIOutput) // validated with the config
+		oe = &OutputEndpoint{
+			name: u.Path,
+			w:    newOutputFiles(u.Path, conf.APIOutputGzip, conf.APIOutputMaxSize, conf.APIOutputMaxFiles),
+		}
+	}
+	go func() {
+		defer watchdog.LogOnPanic()
+		logEndpointStats(&oe.stats)
+	}()
+	return oe
+}
+
+// write writes a record as a line to the output.
+func (oe *OutputEndpoint) write(r *outputRecord) (int, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return 0, fmt.Errorf("encoding issue: %v", err)
+	}
+	data = append(data, '\n')
+
+	oe.mu.Lock()
+	defer oe.mu.Unlock()
+	if _, err := oe.w.Write(data); err != nil {
+		return len(data), fmt.Errorf("cannot write to %s: %v", oe.name, err)
+	}
+	return len(data), nil
+}
+
+// Write writes a payload to the output.
+func (oe *OutputEndpoint) Write(p *model.AgentPayload) (int, error) {
+	startFlush := time.Now()
+
+	payloadSize, err := oe.write(&outputRecord{Time: startFlush, Payload: p, Extras: p.Extras()})
+	statsd.Client.Count("datadog.trace_agent.writer.payload_bytes", int64(payloadSize), nil, 1)
+	atomic.AddInt64(&oe.stats.TracesBytes, int64(payloadSize))
+	atomic.AddInt64(&oe.stats.TracesCount, int64(len(p.Traces)))
+	atomic.AddInt64(&oe.stats.TracesStats, int64(len(p.Stats)))
+	atomic.AddInt64(&oe.stats.TracesPayload, 1)
+	if err != nil {
+		log.Error(err)
+		atomic.AddInt64(&oe.stats.TracesPayloadError, 1)
+		return payloadSize, err
+	}
+
+	flushTime := time.Since(startFlush)
+	log.Infof("flushed payload to %s, time:%s, size:%d", oe.name, flushTime, payloadSize)
+	statsd.Client.Gauge("datadog.trace_agent.writer.flush_duration", flushTime.Seconds(), nil, 1)
+
+	return payloadSize, nil
+}
+
+// WriteServices writes a services update to the output.
+func (oe *OutputEndpoint) WriteServices(s model.ServicesMetadata) {
+	payloadSize, err := oe.write(&outputRecord{Time: time.Now(), Services: s})
+	atomic.AddInt64(&oe.stats.ServicesBytes, int64(payloadSize))
+	atomic.AddInt64(&oe.stats.ServicesPayload, 1)
+	if err != nil {
+		log.Error(err)
+		atomic.AddInt64(&oe.stats.ServicesPayloadError, 1)
+		return
+	}
+
+	log.Infof("flushed %d services to %s", len(s), oe.name)
+}
+
+// Close closes the output, if it has to be.
+func (oe *OutputEndpoint) Close() error {
+	oe.mu.Lock()
+	defer oe.mu.Unlock()
+	if c, ok := oe.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// outputFilePattern matches the files written by outputFiles.
+const outputFilePattern = "payloads-*.ndjson*"
+
+// outputFiles writes to files in a directory, starting a new one when the
+// current one would grow over maxSize, and removing the oldest ones to keep
+// at most maxFiles if positive. Files are named after the time they are
+// started at, so that they sort in the order they were written.
+type outputFiles struct {
+	dir      string
+	gzip     bool
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File // opened on the first write
+	gz   *gzip.Writer
+	size int64
+}
+
+func newOutputFiles(dir string, gzip bool, maxSize int64, maxFiles int) *outputFiles {
+	return &outputFiles{dir: dir, gzip: gzip, maxSize: maxSize, maxFiles: maxFiles}
+}
+
+// Write writes b to the current file, all at once. Each write is flushed
+// so that the files can be read up to the last write at any time.
+func (of *outputFiles) Write(b []byte) (int, error) {
+	if of.f != nil && of.size > 0 && of.size+int64(len(b)) > of.maxSize {
+		if err := of.Close(); err != nil {
+			log.Errorf("cannot close output file: %v", err)
+		}
+	}
+	if of.f == nil {
+		if err := of.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	var w io.Writer = of.f
+	if of.gz != nil {
+		w = of.gz
+	}
+	n, err := w.Write(b)
+	if err == nil && of.gz != nil {
+		err = of.gz.Flush()
+	}
+	if err != nil {
+		return n, err
+	}
+
+	// the size of the file, compressed or not
+	fi, err := of.f.Stat()
+	if err != nil {
+		return n, err
+	}
+	of.size = fi.Size()
+	return n, nil
+}
+
+// open starts a new file, and removes the oldest ones.
+func (of *outputFiles) open() error {
+	if err := os.MkdirAll(of.dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("payloads-%s.ndjson", time.Now().UTC().Format("20060102T150405.000000000Z"))
+	if of.gzip {
+		name += ".gz"
+	}
+	f, err := os.OpenFile(filepath.Join(of.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	of.f = f
+	of.size = 0
+	if of.gzip {
+		of.gz = gzip.NewWriter(f)
+	}
+
+	if of.maxFiles > 0 {
+		files, err := filepath.Glob(filepath.Join(of.dir, outputFilePattern))
+		if err != nil {
+			return nil // can only be a bad pattern
+		}
+		sort.Strings(files)
+		for len(files) > of.maxFiles {
+			if err := os.Remove(files[0]); err != nil {
+				log.Errorf("cannot remove old output file: %v", err)
+			}
+			files = files[1:]
+		}
+	}
+	return nil
+}
+
+// Close closes the current file, if any.
+func (of *outputFiles) Close() error {
+	if of.f == nil {
+		return nil
+	}
+	var err error
+	if of.gz != nil {
+		err = of.gz.Close()
+		of.gz = nil
+	}
+	if cerr := of.f.Close(); err == nil {
+		err = cerr
+	}
+	of.f = nil
+	return err
+}
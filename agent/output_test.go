@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// readOutputRecords decodes the records written to r.
+func readOutputRecords(t *testing.T, r io.Reader) []outputRecord {
+	var records []outputRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var rec outputRecord
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	assert.Nil(t, scanner.Err())
+	return records
+}
+
+func TestOutputEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	oe := &OutputEndpoint{name: "test", w: &buf}
+
+	p := model.AgentPayload{
+		HostName: "test.host",
+		Env:      "test",
+		Traces:   []model.Trace{fixtures.RandomTrace(3, 1)},
+		Stats:    []model.StatsBucket{fixtures.TestStatsBucket()},
+	}
+	p.SetExtra(languageHeaderKey, "go|python")
+	size, err := oe.Write(&p)
+	assert.Nil(err)
+	oe.WriteServices(model.ServicesMetadata{"web": {"app_type": "web"}})
+
+	assert.EqualValues(1, oe.stats.TracesPayload)
+	assert.EqualValues(1, oe.stats.TracesCount)
+	assert.EqualValues(1, oe.stats.TracesStats)
+	assert.EqualValues(size, oe.stats.TracesBytes)
+	assert.EqualValues(1, oe.stats.ServicesPayload)
+
+	records := readOutputRecords(t, &buf)
+	assert.Len(records, 2)
+	assert.Equal("test.host", records[0].Payload.HostName)
+	assert.Len(records[0].Payload.Traces, 1)
+	assert.Len(records[0].Payload.Stats, 1)
+	assert.Equal(map[string]string{languageHeaderKey: "go|python"}, records[0].Extras)
+	assert.Nil(records[0].Services)
+	assert.Nil(records[1].Payload)
+	assert.Equal(model.ServicesMetadata{"web": {"app_type": "web"}}, records[1].Services)
+}
+
+func TestOutputFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "output")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out") // created on the first write
+	of := newOutputFiles(out, false, 100, 2)
+
+	line := []byte(strings.Repeat("a", 59) + "\n")
+	for i := 0; i < 5; i++ {
+		n, err := of.Write(line)
+		assert.Nil(err)
+		assert.Equal(len(line), n)
+	}
+	assert.Nil(of.Close())
+
+	// one line per file, and only the last 2 files are kept
+	files, err := filepath.Glob(filepath.Join(out, outputFilePattern))
+	assert.Nil(err)
+	assert.Len(files, 2)
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		assert.Nil(err)
+		assert.Equal(line, b)
+	}
+}
+
+func TestOutputFilesGzip(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "output")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	of := newOutputFiles(dir, true, 1024*1024, 0)
+	line := []byte(strings.Repeat("a", 1000) + "\n")
+	for i := 0; i < 3; i++ {
+		_, err := of.Write(line)
+		assert.Nil(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, outputFilePattern))
+	assert.Nil(err)
+	assert.Len(files, 1)
+	assert.True(strings.HasSuffix(files[0], ".ndjson.gz"))
+
+	// each write is flushed, so the file can be read before it is closed
+	readFile := func() []byte {
+		f, err := os.Open(files[0])
+		assert.Nil(err)
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		assert.Nil(err)
+		b, _ := ioutil.ReadAll(gz)
+		return b
+	}
+	assert.Equal(bytes.Repeat(line, 3), readFile())
+	assert.True(of.size < int64(len(line)), "size should be the compressed size: %d", of.size)
+
+	assert.Nil(of.Close())
+	assert.Equal(bytes.Repeat(line, 3), readFile())
+}
+
+func TestWriterOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "output")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIOutput = "file://" + dir
+	w := NewWriter(conf)
+	oe, ok := w.endpoint.(*OutputEndpoint)
+	assert.True(ok)
+	assert.Equal(dir, oe.name)
+
+	w.inServices = make(chan model.ServicesMetadata)
+	w.Run()
+	w.inPayloads <- &model.AgentPayload{HostName: "test.host", Traces: []model.Trace{fixtures.RandomTrace(3, 1)}}
+	w.Stop()
+
+	files, err := filepath.Glob(filepath.Join(dir, outputFilePattern))
+	assert.Nil(err)
+	assert.Len(files, 1)
+	f, err := os.Open(files[0])
+	assert.Nil(err)
+	defer f.Close()
+	records := readOutputRecords(t, f)
+	assert.Len(records, 1)
+	assert.Equal("test.host", records[0].Payload.HostName)
+
+	conf.APIOutput = "stdout"
+	_, ok = NewWriter(conf).endpoint.(*OutputEndpoint)
+	assert.True(ok)
+}
+
+func TestOutputStdoutOnlyRecords(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-output")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	assert.Nil(err)
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	assert.Nil(err)
+	defer stderr.Close()
+	log.Flush() // what was logged so far goes to the actual stdout
+	defer func(stdout, stderr *os.File) {
+		os.Stdout, os.Stderr = stdout, stderr
+	}(os.Stdout, os.Stderr)
+	os.Stdout, os.Stderr = stdout, stderr
+
+	defer log.UseLogger(log.Current)
+	assert.Nil(SetupLogger(log.DebugLvl, filepath.Join(dir, "trace-agent.log"), 0, 10, true))
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIOutput = "stdout"
+	w := NewWriter(conf)
+	w.Run()
+	log.Info("some info")
+	w.inPayloads <- &model.AgentPayload{HostName: "test.host", Traces: []model.Trace{fixtures.RandomTrace(3, 1)}}
+	log.Warn("some warning")
+	log.Error("some error")
+	w.Stop()
+	log.Flush()
+
+	// stdout only holds the records, the logs went to stderr
+	out, err := os.Open(stdout.Name())
+	assert.Nil(err)
+	defer out.Close()
+	records := readOutputRecords(t, out)
+	assert.Len(records, 1)
+	assert.Equal("test.host", records[0].Payload.HostName)
+
+	logs, err := ioutil.ReadFile(stderr.Name())
+	assert.Nil(err)
+	for _, msg := range []string{"some info", "some warning", "some error"} {
+		assert.Contains(string(logs), msg)
+	}
+}
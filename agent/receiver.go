@@ -45,17 +45,28 @@ const (
 	v03 APIVersion = "v0.3"
 )
 
+// taggedTrace is a trace along with the tags of the payload it was
+// received in, so that it can be accounted for in the right stats once
+// processed.
+type taggedTrace struct {
+	trace model.Trace
+	tags  Tags
+}
+
 // HTTPReceiver is a collector that uses HTTP protocol and just holds
 // a chan where the spans received are sent one by one
 type HTTPReceiver struct {
-	traces   chan model.Trace
+	traces   chan taggedTrace
 	services chan model.ServicesMetadata
 	conf     *config.AgentConfig
 
 	stats      *receiverStats
 	preSampler *sampler.PreSampler
+	capture    *Capture // nil unless a capture file is configured
+	relay      *Relay   // nil unless accepting the payloads of other agents
 
-	exit chan struct{}
+	exit     chan struct{}
+	listener *StoppableListener // set once listening
 
 	maxRequestBodyLength int64
 	debug                bool
@@ -63,13 +74,25 @@ type HTTPReceiver struct {
 
 // NewHTTPReceiver returns a pointer to a new HTTPReceiver
 func NewHTTPReceiver(conf *config.AgentConfig) *HTTPReceiver {
+	var capture *Capture
+	if conf.CaptureFile != "" {
+		capture = NewCapture(conf.CaptureFile, conf.CaptureMaxSize, conf.CaptureRate)
+		capture.Enable(conf.CaptureEnabled)
+	}
+	var relay *Relay
+	if conf.AcceptAgentPayloads {
+		relay = NewRelay()
+	}
+
 	// use buffered channels so that handlers are not waiting on downstream processing
 	return &HTTPReceiver{
-		traces:     make(chan model.Trace, 5000), // about 1000 traces/sec for 5 sec
+		traces:     make(chan taggedTrace, 5000), // about 1000 traces/sec for 5 sec
 		services:   make(chan model.ServicesMetadata, 50),
 		conf:       conf,
 		stats:      newReceiverStats(),
 		preSampler: sampler.NewPreSampler(conf.PreSampleRate),
+		capture:    capture,
+		relay:      relay,
 		exit:       make(chan struct{}),
 
 		maxRequestBodyLength: maxRequestBodyLength,
@@ -91,7 +114,17 @@ func (r *HTTPReceiver) Run() {
 	http.HandleFunc("/v0.3/traces", r.httpHandleWithVersion(v03, r.handleTraces))
 	http.HandleFunc("/v0.3/services", r.httpHandleWithVersion(v03, r.handleServices))
 
+	// payloads relayed by other agents
+	if r.relay != nil {
+		http.HandleFunc("/v0.1/agent_payload", r.handleAgentPayload(model.AgentPayloadV01))
+		http.HandleFunc("/v0.2/agent_payload", r.handleAgentPayload(model.AgentPayloadV02))
+	}
+
 	// expvar implicitely publishes "/debug/vars" on the same port
+	http.HandleFunc("/metrics", handleMetrics)
+	if r.capture != nil {
+		http.HandleFunc("/debug/capture", r.capture.handleCapture)
+	}
 
 	addr := fmt.Sprintf("%s:%d", r.conf.ReceiverHost, r.conf.ReceiverPort)
 	if err := r.Listen(addr, ""); err != nil {
@@ -121,6 +154,8 @@ func (r *HTTPReceiver) Listen(addr, logExtra string) error {
 		return fmt.Errorf("cannot create stoppable listener: %v", err)
 	}
 
+	r.listener = stoppableListener
+
 	timeout := 5 * time.Second
 	if r.conf.ReceiverTimeout > 0 {
 		timeout = time.Duration(r.conf.ReceiverTimeout) * time.Second
@@ -145,8 +180,19 @@ func (r *HTTPReceiver) Listen(addr, logExtra string) error {
 	return nil
 }
 
+// RejectConnections makes the receiver close new connections right away,
+// to shed load, or accept them again.
+func (r *HTTPReceiver) RejectConnections(reject bool) {
+	if r.listener != nil {
+		r.listener.RejectConnections(reject)
+	}
+}
+
 func (r *HTTPReceiver) httpHandle(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
+		if r.capture.Sample() {
+			req.Body = r.capture.Record(req, r.maxRequestBodyLength)
+		}
 		req.Body = model.NewLimitedReader(req.Body, r.maxRequestBodyLength)
 		defer req.Body.Close()
 
@@ -198,40 +244,82 @@ func (r *HTTPReceiver) handleTraces(v APIVersion, w http.ResponseWriter, req *ht
 		atomic.AddInt64(&ts.TracesBytes, int64(bytesRead))
 	}
 
-	// normalize data
+	// repair and normalize data
 	for i := range traces {
-		spans := len(traces[i])
-
 		atomic.AddInt64(&ts.TracesReceived, 1)
-		atomic.AddInt64(&ts.SpansReceived, int64(spans))
-
-		normTrace, err := model.NormalizeTrace(traces[i])
-		if err != nil {
-			atomic.AddInt64(&ts.TracesDropped, 1)
-			atomic.AddInt64(&ts.SpansDropped, int64(spans))
-
-			errorMsg := fmt.Sprintf("dropping trace reason: %s (debug for more info), %v", err, normTrace)
-
-			// avoid truncation in DEBUG mode
-			if len(errorMsg) > 150 && !r.debug {
-				errorMsg = errorMsg[:150] + "..."
-			}
-			log.Errorf(errorMsg)
-		} else {
-			atomic.AddInt64(&ts.SpansDropped, int64(spans-len(normTrace)))
-
-			// if our downstream consumer is slow, we drop the trace on the floor
-			// this is a safety net against us using too much memory
-			// when clients flood us
-			select {
-			case r.traces <- normTrace:
-			default:
-				atomic.AddInt64(&ts.TracesDropped, 1)
-				atomic.AddInt64(&ts.SpansDropped, int64(spans))
-
-				log.Errorf("dropping trace reason: rate-limited")
-			}
+		atomic.AddInt64(&ts.SpansReceived, int64(len(traces[i])))
+
+		// traces may be fragments which are assembled later on, they are
+		// only repaired once whole, see Agent.processAssembled
+		if r.conf.AssemblerEnabled {
+			r.queueTrace(ts, traces[i])
+			continue
 		}
+
+		for _, t := range r.repairTrace(ts, traces[i]) {
+			r.queueTrace(ts, t)
+		}
+	}
+}
+
+// repairTrace repairs a trace according to the configured strategy,
+// accounting for its anomalies and for the spans dropped in the given stats.
+func (r *HTTPReceiver) repairTrace(ts *tagStats, t model.Trace) model.Traces {
+	spans := len(t)
+
+	repaired, anomalies := model.RepairTrace(t, r.conf.TraceRepairStrategy)
+	if !anomalies.IsEmpty() {
+		ts.addAnomalies(anomalies)
+		log.Debugf("trace %d has structural anomalies: %+v", t[0].TraceID, anomalies)
+	}
+
+	repairedSpans := 0
+	for _, t := range repaired {
+		repairedSpans += len(t)
+	}
+	if repairedSpans == 0 && spans > 0 {
+		atomic.AddInt64(&ts.TracesDropped, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+		log.Errorf("dropping trace reason: no span left after repairing %d spans", spans)
+		return nil
+	}
+	atomic.AddInt64(&ts.SpansDropped, int64(spans-repairedSpans))
+
+	return repaired
+}
+
+// queueTrace normalizes a trace and sends it downstream, accounting
+// for it in the given stats if it has to be dropped.
+func (r *HTTPReceiver) queueTrace(ts *tagStats, t model.Trace) {
+	spans := len(t)
+
+	normTrace, err := model.NormalizeTrace(t)
+	if err != nil {
+		atomic.AddInt64(&ts.TracesDropped, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+
+		errorMsg := fmt.Sprintf("dropping trace reason: %s (debug for more info), %v", err, normTrace)
+
+		// avoid truncation in DEBUG mode
+		if len(errorMsg) > 150 && !r.debug {
+			errorMsg = errorMsg[:150] + "..."
+		}
+		log.Errorf(errorMsg)
+		return
+	}
+
+	atomic.AddInt64(&ts.SpansDropped, int64(spans-len(normTrace)))
+
+	// if our downstream consumer is slow, we drop the trace on the floor
+	// this is a safety net against us using too much memory
+	// when clients flood us
+	select {
+	case r.traces <- taggedTrace{normTrace, ts.Tags}:
+	default:
+		atomic.AddInt64(&ts.TracesDropped, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+
+		log.Errorf("dropping trace reason: rate-limited")
 	}
 }
 
@@ -121,8 +121,8 @@ func TestLegacyReceiver(t *testing.T) {
 			// now we should be able to read the trace data
 			select {
 			case rt := <-tc.r.traces:
-				assert.Len(rt, 1)
-				span := rt[0]
+				assert.Len(rt.trace, 1)
+				span := rt.trace[0]
 				assert.Equal(uint64(42), span.TraceID)
 				assert.Equal(uint64(52), span.SpanID)
 				assert.Equal("fennel_is_amazing", span.Service)
@@ -181,8 +181,8 @@ func TestReceiverJSONDecoder(t *testing.T) {
 			// now we should be able to read the trace data
 			select {
 			case rt := <-tc.r.traces:
-				assert.Len(rt, 1)
-				span := rt[0]
+				assert.Len(rt.trace, 1)
+				span := rt.trace[0]
 				assert.Equal(uint64(42), span.TraceID)
 				assert.Equal(uint64(52), span.SpanID)
 				assert.Equal("fennel_is_amazing", span.Service)
@@ -247,8 +247,8 @@ func TestReceiverMsgpackDecoder(t *testing.T) {
 				// now we should be able to read the trace data
 				select {
 				case rt := <-tc.r.traces:
-					assert.Len(rt, 1)
-					span := rt[0]
+					assert.Len(rt.trace, 1)
+					span := rt.trace[0]
 					assert.Equal(uint64(42), span.TraceID)
 					assert.Equal(uint64(52), span.SpanID)
 					assert.Equal("fennel_is_amazing", span.Service)
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+)
+
+const tagAgentPayloadHandler = "handler:agent_payload"
+
+// relayKey identifies the agent a relayed payload comes from.
+type relayKey struct {
+	hostname string
+	env      string
+}
+
+// relayBucketKey identifies the stats buckets of an agent which are merged
+// together.
+type relayBucketKey struct {
+	start    int64
+	duration int64
+}
+
+// relayedPayloads holds what an agent relayed since the last flush.
+type relayedPayloads struct {
+	traces    []model.Trace
+	buckets   map[relayBucketKey]*model.StatsBucket
+	languages map[string]struct{}
+}
+
+// Relay accumulates the payloads relayed by other trace-agents, so that
+// they are forwarded to the API at the pace of this agent's own payloads.
+// The stats buckets of each agent covering the same time span are merged,
+// and each agent keeps its own payload, so that its host name is kept.
+type Relay struct {
+	mu       sync.Mutex
+	payloads map[relayKey]*relayedPayloads
+}
+
+// NewRelay returns a new, empty Relay.
+func NewRelay() *Relay {
+	return &Relay{payloads: make(map[relayKey]*relayedPayloads)}
+}
+
+// Add adds a relayed payload, along with the languages of the tracers it
+// got data from, as reported in languageHeaderKey.
+func (rl *Relay) Add(p *model.AgentPayload, languages string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	key := relayKey{hostname: p.HostName, env: p.Env}
+	rp, ok := rl.payloads[key]
+	if !ok {
+		rp = &relayedPayloads{
+			buckets:   make(map[relayBucketKey]*model.StatsBucket),
+			languages: make(map[string]struct{}),
+		}
+		rl.payloads[key] = rp
+	}
+
+	rp.traces = append(rp.traces, p.Traces...)
+	skipped := 0
+	for i := range p.Stats {
+		sb := p.Stats[i]
+		bkey := relayBucketKey{start: sb.Start, duration: sb.Duration}
+		if merged, ok := rp.buckets[bkey]; ok {
+			skipped += merged.Merge(sb)
+		} else {
+			rp.buckets[bkey] = &sb
+		}
+	}
+	if skipped > 0 {
+		log.Warnf("ignoring %d distributions relayed by %s, backed by another structure than the ones relayed before", skipped, p.HostName)
+		statsd.Client.Count("datadog.trace_agent.relay.distributions_skipped", int64(skipped), nil, 1)
+	}
+	for _, lang := range strings.Split(languages, "|") {
+		if lang != "" {
+			rp.languages[lang] = struct{}{}
+		}
+	}
+}
+
+// Flush returns a payload for each agent which relayed data since the last
+// flush, sorted by host name and env. It is safe to call on a nil Relay.
+func (rl *Relay) Flush() []*model.AgentPayload {
+	if rl == nil {
+		return nil
+	}
+
+	rl.mu.Lock()
+	payloads := rl.payloads
+	rl.payloads = make(map[relayKey]*relayedPayloads)
+	rl.mu.Unlock()
+
+	flushed := make([]*model.AgentPayload, 0, len(payloads))
+	for key, rp := range payloads {
+		p := &model.AgentPayload{
+			HostName: key.hostname,
+			Env:      key.env,
+			Traces:   rp.traces,
+			Stats:    make([]model.StatsBucket, 0, len(rp.buckets)),
+		}
+		for _, sb := range rp.buckets {
+			p.Stats = append(p.Stats, *sb)
+		}
+		sort.Slice(p.Stats, func(i, j int) bool {
+			return p.Stats[i].Start < p.Stats[j].Start
+		})
+
+		langs := make([]string, 0, len(rp.languages))
+		for lang := range rp.languages {
+			langs = append(langs, lang)
+		}
+		sort.Strings(langs)
+		p.SetExtra(languageHeaderKey, strings.Join(langs, "|"))
+
+		flushed = append(flushed, p)
+	}
+	sort.Slice(flushed, func(i, j int) bool {
+		if flushed[i].HostName != flushed[j].HostName {
+			return flushed[i].HostName < flushed[j].HostName
+		}
+		return flushed[i].Env < flushed[j].Env
+	})
+	return flushed
+}
+
+// handleAgentPayload returns a handler for the payloads relayed by other
+// trace-agents, encoded with the given version.
+func (r *HTTPReceiver) handleAgentPayload(v model.AgentPayloadVersion) http.HandlerFunc {
+	tags := []string{tagAgentPayloadHandler, fmt.Sprintf("v:%s", v)}
+	return r.httpHandle(func(w http.ResponseWriter, req *http.Request) {
+		p, err := model.DecodeAgentPayload(v, req.Header, req.Body)
+		if err != nil {
+			log.Errorf("cannot decode %s agent payload: %v", v, err)
+			HTTPDecodingError(err, tags, w)
+			return
+		}
+
+		HTTPOK(w)
+
+		r.relay.Add(p, req.Header.Get(languageHeaderKey))
+
+		statsd.Client.Count("datadog.trace_agent.relay.payloads", 1, tags, 1)
+		statsd.Client.Count("datadog.trace_agent.relay.traces", int64(len(p.Traces)), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.relay.stats_buckets", int64(len(p.Stats)), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.relay.bytes", req.Body.(*model.LimitedReader).Count, tags, 1)
+	})
+}
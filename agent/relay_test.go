@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+func TestRelay(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilRelay *Relay
+	assert.Nil(nilRelay.Flush())
+
+	rl := NewRelay()
+	rl.Add(&model.AgentPayload{
+		HostName: "b.host",
+		Env:      "prod",
+		Traces:   []model.Trace{fixtures.RandomTrace(3, 1)},
+		Stats:    []model.StatsBucket{fixtures.TestStatsBucket()},
+	}, "python")
+	rl.Add(&model.AgentPayload{HostName: "a.host", Env: "prod", Stats: []model.StatsBucket{fixtures.TestStatsBucket()}}, "go")
+	rl.Add(&model.AgentPayload{
+		HostName: "b.host",
+		Env:      "prod",
+		Traces:   []model.Trace{fixtures.RandomTrace(3, 1)},
+		Stats:    []model.StatsBucket{fixtures.TestStatsBucket()},
+	}, "go|python")
+
+	payloads := rl.Flush()
+	assert.Len(payloads, 2)
+	assert.Equal("a.host", payloads[0].HostName)
+	assert.Equal("go", payloads[0].Extras()[languageHeaderKey])
+	assert.Len(payloads[0].Stats, 1)
+
+	// the buckets of b.host covering the same time span are merged
+	b := payloads[1]
+	assert.Equal("b.host", b.HostName)
+	assert.Equal("prod", b.Env)
+	assert.Equal("go|python", b.Extras()[languageHeaderKey])
+	assert.Len(b.Traces, 2)
+	assert.Len(b.Stats, 1)
+	sb := fixtures.TestStatsBucket()
+	assert.Equal(sb.Start, b.Stats[0].Start)
+	for k, c := range sb.Counts {
+		assert.Equal(2*c.Value, b.Stats[0].Counts[k].Value, k)
+	}
+
+	assert.Len(rl.Flush(), 0)
+}
+
+func TestRelayHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.AcceptAgentPayloads = true
+	r := NewHTTPReceiver(conf)
+	handler := r.handleAgentPayload(model.AgentPayloadV01)
+
+	p := model.AgentPayload{
+		HostName: "relayed.host",
+		Traces:   []model.Trace{fixtures.RandomTrace(3, 1)},
+		Stats:    []model.StatsBucket{fixtures.TestStatsBucket()},
+	}
+	data, err := model.EncodeAgentPayload(&p)
+	assert.Nil(err)
+
+	req := httptest.NewRequest("POST", "/v0.1/agent_payload", bytes.NewReader(data))
+	req.Header.Set(languageHeaderKey, "ruby")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("POST", "/v0.1/agent_payload", bytes.NewReader([]byte("not a payload")))
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	payloads := r.relay.Flush()
+	assert.Len(payloads, 1)
+	assert.Equal("relayed.host", payloads[0].HostName)
+	assert.Equal("ruby", payloads[0].Extras()[languageHeaderKey])
+	assert.Len(payloads[0].Traces, 1)
+	assert.Len(payloads[0].Stats, 1)
+}
+
+func TestRelayEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	// another agent accepting relayed payloads
+	conf := config.NewDefaultAgentConfig()
+	conf.AcceptAgentPayloads = true
+	r := NewHTTPReceiver(conf)
+
+	var queries []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v0.1/agent_payload", r.handleAgentPayload(model.AgentPayloadV01))
+	mux.HandleFunc("/v0.2/agent_payload", r.handleAgentPayload(model.AgentPayloadV02))
+	mux.HandleFunc("/v0.3/services", r.httpHandleWithVersion(v03, r.handleServices))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		queries = append(queries, req.URL.RawQuery)
+		mux.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	ae := NewRelayEndpoint(server.URL)
+
+	p := model.AgentPayload{
+		HostName: "test.host",
+		Env:      "test",
+		Stats:    []model.StatsBucket{fixtures.TestStatsBucket()},
+	}
+	p.SetExtra(languageHeaderKey, "go")
+	_, err := ae.Write(&p)
+	assert.Nil(err)
+	ae.WriteServices(model.ServicesMetadata{"web": {"app_type": "web"}})
+
+	assert.EqualValues(0, ae.stats.TracesPayloadError)
+	assert.EqualValues(0, ae.stats.ServicesPayloadError)
+	assert.Equal([]string{"", ""}, queries, "no API key is sent to a relay")
+
+	payloads := r.relay.Flush()
+	assert.Len(payloads, 1)
+	assert.Equal("test.host", payloads[0].HostName)
+	assert.Equal("go", payloads[0].Extras()[languageHeaderKey])
+	assert.Equal(model.ServicesMetadata{"web": {"app_type": "web"}}, <-r.services)
+
+	// relays accept the binary encoding
+	defer func(v model.AgentPayloadVersion, c model.AgentPayloadCompression) {
+		model.GlobalAgentPayloadVersion = v
+		model.GlobalAgentPayloadCompression = c
+	}(model.GlobalAgentPayloadVersion, model.GlobalAgentPayloadCompression)
+	model.GlobalAgentPayloadVersion = model.AgentPayloadV02
+	if model.CompressionSupported(model.ZstdCompression) {
+		model.GlobalAgentPayloadCompression = model.ZstdCompression
+	}
+
+	_, err = ae.Write(&p)
+	assert.Nil(err)
+	payloads = r.relay.Flush()
+	assert.Len(payloads, 1)
+	assert.Equal("test.host", payloads[0].HostName)
+	assert.Len(payloads[0].Stats, 1)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/sampler"
+)
+
+// replayedPaths are the receiver endpoints whose captured requests are
+// replayed, other requests are skipped.
+var replayedPaths = map[string]APIVersion{
+	"/spans":       v01,
+	"/v0.1/spans":  v01,
+	"/v0.2/traces": v02,
+	"/v0.3/traces": v03,
+}
+
+// replayOutput writes the payloads produced by a replay as JSON, one per
+// line to w, or one per file in dir if set.
+type replayOutput struct {
+	w   io.Writer
+	dir string
+
+	payloads int
+}
+
+func (o *replayOutput) write(p *model.AgentPayload) error {
+	if p.IsEmpty() {
+		return nil
+	}
+	o.payloads++
+
+	// traces and buckets come in no particular order, sort them so that
+	// replaying a capture always gives the same output
+	sort.Slice(p.Traces, func(i, j int) bool {
+		return p.Traces[i][0].TraceID < p.Traces[j][0].TraceID
+	})
+	sort.Slice(p.Stats, func(i, j int) bool {
+		return p.Stats[i].Start < p.Stats[j].Start
+	})
+
+	if o.dir == "" {
+		return json.NewEncoder(o.w).Encode(p)
+	}
+
+	f, err := os.Create(filepath.Join(o.dir, fmt.Sprintf("payload-%04d.json", o.payloads)))
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(p); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replayer pushes captured requests through the agent pipeline, without
+// running it. The pipeline runs on a simulated clock, set to the capture
+// time of each request, so that the sampling and the stats only depend
+// on the captured requests.
+type replayer struct {
+	agent  *Agent
+	engine *sampler.Sampler
+	out    *replayOutput
+
+	now      time.Time
+	requests int
+	skipped  int
+}
+
+func newReplayer(conf *config.AgentConfig, out *replayOutput) *replayer {
+	// payloads are written to out, never to the API, and replayed requests
+	// are not captured again
+	c := *conf
+	c.APIEnabled = false
+	c.CaptureFile = ""
+
+	a := NewAgent(&c)
+	r := &replayer{
+		agent:  a,
+		engine: a.Sampler.samplerEngine.(*sampler.Sampler),
+		out:    out,
+	}
+	a.now = func() time.Time { return r.now }
+	return r
+}
+
+// Replay replays the requests of the capture file at path, and writes the
+// resulting payloads to w, or to files in dir if set, instead of the API.
+func Replay(conf *config.AgentConfig, path string, w io.Writer, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := newReplayer(conf, &replayOutput{w: w, dir: dir})
+
+	cr := newCaptureReader(f)
+	for {
+		c, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if err := r.replay(c); err != nil {
+			return err
+		}
+	}
+	if err := r.finish(); err != nil {
+		return err
+	}
+
+	log.Infof("replayed %d requests from %s (%d skipped), wrote %d payloads", r.requests, path, r.skipped, r.out.payloads)
+	return nil
+}
+
+// replay handles a captured request as the receiver would, then processes
+// the traces it contains.
+func (r *replayer) replay(c *capturedRequest) error {
+	r.requests++
+	if c.Time.IsZero() {
+		return fmt.Errorf("request %d has no capture time", r.requests)
+	}
+	if err := r.advance(c.Time); err != nil {
+		return err
+	}
+
+	v, ok := replayedPaths[c.Path]
+	if !ok {
+		log.Debugf("skipping request %d to %s", r.requests, c.Path)
+		r.skipped++
+		return nil
+	}
+
+	rec := httptest.NewRecorder()
+	r.agent.Receiver.httpHandleWithVersion(v, r.agent.Receiver.handleTraces)(rec, c.request())
+	if rec.Code != http.StatusOK {
+		log.Errorf("request %d to %s rejected with status %d: %s", r.requests, c.Path, rec.Code, rec.Body.String())
+	}
+
+	for {
+		select {
+		case t := <-r.agent.Receiver.traces:
+			r.agent.receive(t)
+		default:
+			return nil
+		}
+	}
+}
+
+// advance moves the clock forward to t, flushing the payloads which are
+// due on the way, like the agent does every bucket interval. The clock
+// never goes back, requests captured out of order are replayed at the
+// current time.
+func (r *replayer) advance(t time.Time) error {
+	if r.now.IsZero() {
+		r.now = t
+		return nil
+	}
+
+	interval := r.agent.conf.BucketInterval.Nanoseconds()
+	now := r.now.UnixNano()
+	for next := now - now%interval + interval; next <= t.UnixNano(); next += interval {
+		r.step(time.Unix(0, next))
+		if err := r.flush(false); err != nil {
+			return err
+		}
+	}
+	if t.After(r.now) {
+		r.step(t)
+	}
+	return nil
+}
+
+// step sets the clock to t, running the periodic tasks due until then.
+// The traces being processed are waited for first, so that the sampler
+// scores them before it is adjusted, whatever the goroutines scheduling.
+func (r *replayer) step(t time.Time) {
+	r.agent.processWG.Wait()
+	r.engine.Step(r.now, t)
+
+	period := int64(assemblerExpireInterval)
+	expire := t.UnixNano()/period > r.now.UnixNano()/period
+	r.now = t
+	if r.agent.Assembler != nil && expire {
+		r.agent.processAssembled(r.agent.Assembler.Expire(r.now))
+	}
+}
+
+// flush waits for the traces being processed, then writes a payload.
+func (r *replayer) flush(force bool) error {
+	r.agent.processWG.Wait()
+	return r.out.write(r.agent.flush(force))
+}
+
+// finish processes the traces still being assembled, and writes a last
+// payload with everything left over.
+func (r *replayer) finish() error {
+	if r.agent.Assembler != nil {
+		r.agent.processAssembled(r.agent.Assembler.FlushAll())
+	}
+	return r.flush(true)
+}
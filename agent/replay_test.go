@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// writeCapture writes a capture file with a v0.3 JSON request of one trace
+// of 2 spans for each of the given times, and returns its path.
+func writeCapture(t *testing.T, dir string, times ...time.Time) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i, ts := range times {
+		start := ts.Add(-time.Second).UnixNano()
+		traceID := uint64(i + 1)
+		body, err := json.Marshal(model.Traces{{
+			{TraceID: traceID, SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /", Start: start, Duration: 1e8},
+			{TraceID: traceID, SpanID: 2, ParentID: 1, Service: "db", Name: "sql.query", Resource: "SELECT", Start: start, Duration: 5e7},
+		}})
+		assert.Nil(t, err)
+
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		header.Set("Datadog-Meta-Lang", "go")
+		assert.Nil(t, enc.Encode(capturedRequest{Time: ts, Path: "/v0.3/traces", Header: header, Body: body}))
+	}
+	// other requests are ignored
+	assert.Nil(t, enc.Encode(capturedRequest{Time: times[len(times)-1], Path: "/v0.3/services", Body: []byte("{}")}))
+
+	path := filepath.Join(dir, "capture.ndjson")
+	assert.Nil(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+func testReplayConfig() *config.AgentConfig {
+	conf := config.NewDefaultAgentConfig()
+	conf.HostName = "replay"
+	conf.BucketInterval = 10 * time.Second
+	return conf
+}
+
+func TestReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "replay")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1500000000, 0)
+	path := writeCapture(t, dir, start, start.Add(time.Second), start.Add(45*time.Second))
+
+	var buf bytes.Buffer
+	assert.Nil(Replay(testReplayConfig(), path, &buf, ""))
+	output := buf.Bytes()
+
+	var payloads []*model.AgentPayload
+	dec := json.NewDecoder(bytes.NewReader(output))
+	for dec.More() {
+		var p model.AgentPayload
+		assert.Nil(dec.Decode(&p))
+		payloads = append(payloads, &p)
+	}
+
+	// the payloads are flushed on the way to the last request according to
+	// the simulated clock, then everything left over when done
+	assert.Len(payloads, 3)
+	assert.Equal("replay", payloads[0].HostName)
+	assert.Len(payloads[0].Traces, 2)
+	assert.Len(payloads[0].Stats, 1)
+	assert.Equal(start.Add(-10*time.Second).UnixNano(), payloads[0].Stats[0].Start)
+	assert.Len(payloads[1].Traces, 0)
+	assert.Len(payloads[1].Stats, 1)
+	assert.Equal(start.UnixNano(), payloads[1].Stats[0].Start)
+	assert.Len(payloads[2].Traces, 1)
+	assert.Equal(uint64(3), payloads[2].Traces[0][0].TraceID)
+
+	// the output only depends on the capture
+	buf.Reset()
+	assert.Nil(Replay(testReplayConfig(), path, &buf, ""))
+	assert.Equal(string(output), buf.String())
+}
+
+func TestReplayToDir(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "replay")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	assert.Nil(os.Mkdir(out, 0755))
+
+	start := time.Unix(1500000000, 0)
+	path := writeCapture(t, dir, start, start.Add(time.Minute))
+	assert.Nil(Replay(testReplayConfig(), path, nil, out))
+
+	files, err := filepath.Glob(filepath.Join(out, "*.json"))
+	assert.Nil(err)
+	assert.Equal([]string{
+		filepath.Join(out, "payload-0001.json"),
+		filepath.Join(out, "payload-0002.json"),
+	}, files)
+}
+
+func TestReplayInvalidCapture(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "replay")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "capture.ndjson")
+	assert.Nil(ioutil.WriteFile(path, []byte("\n{\"path\":\"/v0.3/traces\"}\n"), 0644))
+	assert.EqualError(Replay(testReplayConfig(), path, ioutil.Discard, ""), "request 1 has no capture time")
+
+	assert.Nil(ioutil.WriteFile(path, []byte("\n\nnot json\n"), 0644))
+	err = Replay(testReplayConfig(), path, ioutil.Discard, "")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), path+": line 3: ")
+}
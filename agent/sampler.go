@@ -35,8 +35,14 @@ type samplerInfo struct {
 	Stats samplerStats
 	// State is the internal state of the sampler (for debugging mostly)
 	State sampler.InternalState
+	// TopSignatures explains the decisions taken for the signatures with
+	// the highest throughput
+	TopSignatures []sampler.SignatureInfo
 }
 
+// topSignaturesLen is the number of signatures exposed in samplerInfo.
+const topSignaturesLen = 20
+
 // SamplerEngine cares about telling if a trace is a proper sample or not
 type SamplerEngine interface {
 	Run()
@@ -61,14 +67,17 @@ func (s *Sampler) Run() {
 	}()
 }
 
-// Add samples a trace then keep it until the next flush
-func (s *Sampler) Add(t processedTrace) {
+// Add samples a trace then keep it until the next flush. It returns true
+// if the trace was sampled.
+func (s *Sampler) Add(t processedTrace) bool {
 	s.mu.Lock()
 	s.traceCount++
-	if s.samplerEngine.Sample(t.Trace, t.Root, t.Env) {
+	sampled := s.samplerEngine.Sample(t.Trace, t.Root, t.Env)
+	if sampled {
 		s.sampledTraces = append(s.sampledTraces, t.Trace)
 	}
 	s.mu.Unlock()
+	return sampled
 }
 
 // Stop stops the sampler
@@ -91,7 +100,8 @@ func (s *Sampler) Flush() []model.Trace {
 
 	s.mu.Unlock()
 
-	state := s.samplerEngine.(*sampler.Sampler).GetState()
+	engine := s.samplerEngine.(*sampler.Sampler)
+	state := engine.GetState()
 	var stats samplerStats
 	if duration > 0 {
 		stats.KeptTPS = float64(len(traces)) / duration.Seconds()
@@ -103,7 +113,7 @@ func (s *Sampler) Flush() []model.Trace {
 		state.InTPS, state.OutTPS, state.MaxTPS, state.Offset, state.Slope, state.Cardinality)
 
 	// publish through expvar
-	updateSamplerInfo(samplerInfo{Stats: stats, State: state})
+	updateSamplerInfo(samplerInfo{Stats: stats, State: state, TopSignatures: engine.TopSignatures(topSignaturesLen)})
 
 	return traces
 }
@@ -5,6 +5,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/DataDog/datadog-trace-agent/statsd"
 )
 
@@ -93,6 +94,11 @@ func (ts *tagStats) publish() {
 	spansFiltered := atomic.LoadInt64(&ts.SpansFiltered)
 	servicesReceived := atomic.LoadInt64(&ts.ServicesReceived)
 	servicesBytes := atomic.LoadInt64(&ts.ServicesBytes)
+	tracesMultipleRoots := atomic.LoadInt64(&ts.TracesMultipleRoots)
+	spansOrphan := atomic.LoadInt64(&ts.SpansOrphan)
+	spansCycle := atomic.LoadInt64(&ts.SpansCycle)
+	spansDuplicateID := atomic.LoadInt64(&ts.SpansDuplicateID)
+	spansMixedTraceID := atomic.LoadInt64(&ts.SpansMixedTraceID)
 
 	// Publish the stats
 	statsd.Client.Count("datadog.trace_agent.receiver.trace", tracesReceived, ts.Tags.toArray(), 1)
@@ -105,6 +111,22 @@ func (ts *tagStats) publish() {
 	statsd.Client.Count("datadog.trace_agent.receiver.spans_filtered", spansFiltered, ts.Tags.toArray(), 1)
 	statsd.Client.Count("datadog.trace_agent.receiver.services_received", servicesReceived, ts.Tags.toArray(), 1)
 	statsd.Client.Count("datadog.trace_agent.receiver.services_bytes", servicesBytes, ts.Tags.toArray(), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.traces_multiple_roots", tracesMultipleRoots, ts.Tags.toArray(), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.spans_orphan", spansOrphan, ts.Tags.toArray(), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.spans_cycle", spansCycle, ts.Tags.toArray(), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.spans_duplicate_id", spansDuplicateID, ts.Tags.toArray(), 1)
+	statsd.Client.Count("datadog.trace_agent.receiver.spans_mixed_trace_id", spansMixedTraceID, ts.Tags.toArray(), 1)
+}
+
+// addAnomalies accounts for the structural anomalies found in a trace.
+func (ts *tagStats) addAnomalies(a model.TraceAnomalies) {
+	if a.MultipleRoots > 0 {
+		atomic.AddInt64(&ts.TracesMultipleRoots, 1)
+	}
+	atomic.AddInt64(&ts.SpansOrphan, int64(a.Orphans))
+	atomic.AddInt64(&ts.SpansCycle, int64(a.Cycles))
+	atomic.AddInt64(&ts.SpansDuplicateID, int64(a.DuplicateSpanIDs))
+	atomic.AddInt64(&ts.SpansMixedTraceID, int64(a.MixedTraceIDs))
 }
 
 // Stats holds the metrics that will be reported every 10s by the agent.
@@ -128,6 +150,16 @@ type Stats struct {
 	ServicesReceived int64
 	// ServicesBytes is the amount of data received on the services endpoint (raw data, encoded, compressed).
 	ServicesBytes int64
+	// TracesMultipleRoots is the number of traces received with more than one root span.
+	TracesMultipleRoots int64
+	// SpansOrphan is the number of spans received whose parent is not part of their trace.
+	SpansOrphan int64
+	// SpansCycle is the number of spans received which are their own ancestor.
+	SpansCycle int64
+	// SpansDuplicateID is the number of spans received with the span ID of another span of their trace.
+	SpansDuplicateID int64
+	// SpansMixedTraceID is the number of spans received with a trace ID different from their trace.
+	SpansMixedTraceID int64
 }
 
 func (s *Stats) update(recent Stats) {
@@ -140,6 +172,11 @@ func (s *Stats) update(recent Stats) {
 	atomic.AddInt64(&s.SpansFiltered, recent.SpansFiltered)
 	atomic.AddInt64(&s.ServicesReceived, recent.ServicesReceived)
 	atomic.AddInt64(&s.ServicesBytes, recent.ServicesBytes)
+	atomic.AddInt64(&s.TracesMultipleRoots, recent.TracesMultipleRoots)
+	atomic.AddInt64(&s.SpansOrphan, recent.SpansOrphan)
+	atomic.AddInt64(&s.SpansCycle, recent.SpansCycle)
+	atomic.AddInt64(&s.SpansDuplicateID, recent.SpansDuplicateID)
+	atomic.AddInt64(&s.SpansMixedTraceID, recent.SpansMixedTraceID)
 }
 
 func (s *Stats) reset() {
@@ -152,6 +189,11 @@ func (s *Stats) reset() {
 	atomic.StoreInt64(&s.SpansFiltered, 0)
 	atomic.StoreInt64(&s.ServicesReceived, 0)
 	atomic.StoreInt64(&s.ServicesBytes, 0)
+	atomic.StoreInt64(&s.TracesMultipleRoots, 0)
+	atomic.StoreInt64(&s.SpansOrphan, 0)
+	atomic.StoreInt64(&s.SpansCycle, 0)
+	atomic.StoreInt64(&s.SpansDuplicateID, 0)
+	atomic.StoreInt64(&s.SpansMixedTraceID, 0)
 }
 
 // String returns a string representation of the Stats struct
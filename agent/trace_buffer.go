@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/sampler"
+)
+
+// bufferedTrace is a processed trace kept in a TraceBuffer, along with what
+// the agent did with it.
+type bufferedTrace struct {
+	Received   time.Time             `json:"received"`
+	Env        string                `json:"env"`
+	Sampled    bool                  `json:"sampled"`     // whether the sampler kept the trace
+	SampleRate float64               `json:"sample_rate"` // applied _sample_rate of the root
+	TopLevel   []uint64              `json:"top_level"`   // IDs of the top-level spans
+	Sublayers  []model.SublayerValue `json:"sublayers"`   // sublayers of the root
+	Trace      model.Trace           `json:"trace"`
+}
+
+// traceFilter tells which traces are returned by TraceBuffer.Search, zero
+// values matching all of them.
+type traceFilter struct {
+	service     string
+	resource    string
+	err         *bool
+	minDuration time.Duration
+}
+
+// match returns true if the trace has a span with the service and resource
+// of the filter, an error if required, and a root lasting at least minDuration.
+func (f traceFilter) match(bt *bufferedTrace) bool {
+	root := bt.Trace.GetRoot()
+	if root == nil || root.Duration < f.minDuration.Nanoseconds() {
+		return false
+	}
+
+	var found, hasError bool
+	for _, s := range bt.Trace {
+		if s.Error != 0 {
+			hasError = true
+		}
+		if (f.service == "" || s.Service == f.service) && (f.resource == "" || s.Resource == f.resource) {
+			found = true
+		}
+	}
+	return found && (f.err == nil || *f.err == hasError)
+}
+
+// TraceBuffer keeps the most recent processed traces in memory, so that they
+// can be inspected on /debug/traces.
+type TraceBuffer struct {
+	traces []bufferedTrace
+	next   int // index where the next trace is written
+	mu     sync.Mutex
+}
+
+// NewTraceBuffer returns a new TraceBuffer keeping at most size traces.
+func NewTraceBuffer(size int) *TraceBuffer {
+	return &TraceBuffer{traces: make([]bufferedTrace, 0, size)}
+}
+
+// Add keeps a processed trace, and whether it was sampled, replacing the
+// oldest trace if the buffer is full. It must be called once the trace is
+// not modified anymore.
+func (tb *TraceBuffer) Add(pt processedTrace, sampled bool) {
+	bt := bufferedTrace{
+		Received:  time.Now(),
+		Env:       pt.Env,
+		Sampled:   sampled,
+		Sublayers: pt.Sublayers,
+		Trace:     pt.Trace,
+	}
+	if pt.Root != nil {
+		bt.SampleRate = sampler.GetTraceAppliedSampleRate(pt.Root)
+	}
+	for _, s := range pt.Trace {
+		if s.TopLevel() {
+			bt.TopLevel = append(bt.TopLevel, s.SpanID)
+		}
+	}
+
+	tb.mu.Lock()
+	if len(tb.traces) < cap(tb.traces) {
+		tb.traces = append(tb.traces, bt)
+	} else {
+		tb.traces[tb.next] = bt
+	}
+	tb.next = (tb.next + 1) % cap(tb.traces)
+	tb.mu.Unlock()
+}
+
+// Search returns the buffered traces matching f, most recent first.
+func (tb *TraceBuffer) Search(f traceFilter) []bufferedTrace {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	var traces []bufferedTrace
+	for i := 1; i <= len(tb.traces); i++ {
+		bt := &tb.traces[(tb.next-i+len(tb.traces))%len(tb.traces)]
+		if f.match(bt) {
+			traces = append(traces, *bt)
+		}
+	}
+	return traces
+}
+
+// parseTraceFilter reads a traceFilter from the query parameters service,
+// resource, error (a boolean) and min_duration (a duration such as "250ms").
+func parseTraceFilter(req *http.Request) (traceFilter, error) {
+	q := req.URL.Query()
+	f := traceFilter{
+		service:  q.Get("service"),
+		resource: q.Get("resource"),
+	}
+	if v := q.Get("error"); v != "" {
+		err, perr := strconv.ParseBool(v)
+		if perr != nil {
+			return f, fmt.Errorf("invalid error %q", v)
+		}
+		f.err = &err
+	}
+	if v := q.Get("min_duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid min_duration %q", v)
+		}
+		f.minDuration = d
+	}
+	return f, nil
+}
+
+// handleTraces serves the buffered traces matching the query as JSON, on /debug/traces.
+func (tb *TraceBuffer) handleTraces(w http.ResponseWriter, req *http.Request) {
+	f, err := parseTraceFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Traces []bufferedTrace `json:"traces"`
+	}{tb.Search(f)})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+func bufferTestTrace(traceID uint64, service, resource string, duration int64, err int32) processedTrace {
+	t := model.Trace{
+		{TraceID: traceID, SpanID: 1, Service: service, Resource: resource, Duration: duration,
+			Metrics: map[string]float64{model.SpanSampleRateMetricKey: 0.5}},
+		{TraceID: traceID, SpanID: 2, ParentID: 1, Service: "db", Resource: "SELECT", Duration: duration / 2, Error: err},
+	}
+	t.ComputeTopLevel()
+	return processedTrace{
+		Trace:     t,
+		Root:      &t[0],
+		Env:       "none",
+		Sublayers: []model.SublayerValue{{Metric: "_sublayers.span_count", Value: 2}},
+	}
+}
+
+func traceIDs(traces []bufferedTrace) []uint64 {
+	var ids []uint64
+	for _, bt := range traces {
+		ids = append(ids, bt.Trace[0].TraceID)
+	}
+	return ids
+}
+
+func TestTraceBufferRing(t *testing.T) {
+	assert := assert.New(t)
+	tb := NewTraceBuffer(3)
+
+	assert.Empty(tb.Search(traceFilter{}))
+
+	for i := uint64(1); i <= 5; i++ {
+		tb.Add(bufferTestTrace(i, "web", "GET /", 100, 0), i%2 == 0)
+	}
+
+	traces := tb.Search(traceFilter{})
+	assert.Equal([]uint64{5, 4, 3}, traceIDs(traces))
+	assert.False(traces[0].Sampled)
+	assert.True(traces[1].Sampled)
+	assert.Equal(0.5, traces[0].SampleRate)
+	assert.Equal([]uint64{1, 2}, traces[0].TopLevel)
+	assert.Len(traces[0].Sublayers, 1)
+}
+
+func TestTraceBufferSearch(t *testing.T) {
+	assert := assert.New(t)
+	tb := NewTraceBuffer(10)
+
+	tb.Add(bufferTestTrace(1, "web", "GET /", int64(10*time.Millisecond), 0), true)
+	tb.Add(bufferTestTrace(2, "web", "POST /", int64(300*time.Millisecond), 1), false)
+	tb.Add(bufferTestTrace(3, "api", "GET /", int64(500*time.Millisecond), 0), false)
+
+	errTrue, errFalse := true, false
+	for _, tc := range []struct {
+		filter traceFilter
+		ids    []uint64
+	}{
+		{traceFilter{service: "web"}, []uint64{2, 1}},
+		{traceFilter{service: "db"}, []uint64{3, 2, 1}},
+		{traceFilter{service: "web", resource: "GET /"}, []uint64{1}},
+		{traceFilter{service: "db", resource: "GET /"}, nil},
+		{traceFilter{err: &errTrue}, []uint64{2}},
+		{traceFilter{err: &errFalse}, []uint64{3, 1}},
+		{traceFilter{minDuration: 200 * time.Millisecond}, []uint64{3, 2}},
+	} {
+		assert.Equal(tc.ids, traceIDs(tb.Search(tc.filter)), "%+v", tc.filter)
+	}
+}
+
+func TestTraceBufferHandler(t *testing.T) {
+	assert := assert.New(t)
+	tb := NewTraceBuffer(10)
+
+	tb.Add(bufferTestTrace(1, "web", "GET /", int64(10*time.Millisecond), 0), true)
+	tb.Add(bufferTestTrace(2, "web", "POST /", int64(300*time.Millisecond), 1), false)
+
+	rec := httptest.NewRecorder()
+	tb.handleTraces(rec, httptest.NewRequest("GET", "/debug/traces?service=web&error=true&min_duration=100ms", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("application/json", rec.Header().Get("Content-Type"))
+
+	var resp struct {
+		Traces []bufferedTrace `json:"traces"`
+	}
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal([]uint64{2}, traceIDs(resp.Traces))
+
+	for _, query := range []string{"error=maybe", "min_duration=fast"} {
+		rec = httptest.NewRecorder()
+		tb.handleTraces(rec, httptest.NewRequest("GET", "/debug/traces?"+query, nil))
+		assert.Equal(http.StatusBadRequest, rec.Code, query)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/cihub/seelog"
@@ -18,6 +20,9 @@ const payloadResendDelay = 5 * time.Second
 // the amount of time in seconds a payload can stay buffered before being dropped
 const payloadMaxAge = 10 * time.Minute
 
+// the amount of time to wait between two flush attempts when exiting
+const exitFlushRetryDelay = time.Second
+
 // writerPayload wraps a model.AgentPayload and keeps track of a list of
 // endpoints the payload must be sent to.
 type writerPayload struct {
@@ -42,6 +47,14 @@ func (p *writerPayload) write() error {
 	return err
 }
 
+// writerInfo describes the payloads buffered by the writer.
+type writerInfo struct {
+	// PayloadBufferLen is the number of payloads waiting to be sent.
+	PayloadBufferLen int
+	// PayloadBufferSize is the size of the payloads waiting to be sent, in bytes.
+	PayloadBufferSize int
+}
+
 // Writer is the last chain of trace-agent which takes the
 // pre-processed data from channels and tentatively output them
 // to a given endpoint.
@@ -55,17 +68,34 @@ type Writer struct {
 	payloadBuffer []*writerPayload       // buffer of payloads ready to send
 	serviceBuffer model.ServicesMetadata // services are merged into this map continuously
 
+	// shrunk is set to 1 when the payload buffer is shrunk to
+	// shrunkBufferRatio of its configured size, to shed load
+	shrunk int32
+
 	exit   chan struct{}
 	exitWG *sync.WaitGroup
 
 	conf *config.AgentConfig
 }
 
+// shrunkBufferRatio is the fraction of the configured payload buffer size
+// kept when the buffer is shrunk.
+const shrunkBufferRatio = 0.25
+
 // NewWriter returns a new Writer
 func NewWriter(conf *config.AgentConfig) *Writer {
 	var endpoint AgentEndpoint
 
-	if conf.APIEnabled {
+	if conf.APIEnabled && conf.APIOutput != "" {
+		log.Infof("writing to %s instead of the API", conf.APIOutput)
+		endpoint = NewOutputEndpoint(conf)
+	} else if conf.APIEnabled && conf.APIRelay != "" {
+		log.Infof("relaying to the trace-agent at %s instead of the API", conf.APIRelay)
+		endpoint = NewRelayEndpoint(conf.APIRelay)
+		if conf.Proxy != nil {
+			endpoint.(*APIEndpoint).SetProxy(conf.Proxy)
+		}
+	} else if conf.APIEnabled {
 		endpoint = NewAPIEndpoint(conf.APIEndpoint, conf.APIKey)
 		if conf.Proxy != nil {
 			// we have some kind of proxy configured.
@@ -100,6 +130,24 @@ func (w *Writer) isPayloadBufferingEnabled() bool {
 	return w.conf.APIPayloadBufferMaxSize > 0
 }
 
+// payloadBufferMaxSize returns the maximum size of the payload buffer.
+func (w *Writer) payloadBufferMaxSize() int {
+	if atomic.LoadInt32(&w.shrunk) != 0 {
+		return int(float64(w.conf.APIPayloadBufferMaxSize) * shrunkBufferRatio)
+	}
+	return w.conf.APIPayloadBufferMaxSize
+}
+
+// ShrinkBuffer shrinks the payload buffer, dropping the oldest payloads on
+// the next flush, or restores its configured size.
+func (w *Writer) ShrinkBuffer(shrink bool) {
+	var v int32
+	if shrink {
+		v = 1
+	}
+	atomic.StoreInt32(&w.shrunk, v)
+}
+
 // Run starts the writer.
 func (w *Writer) Run() {
 	w.exitWG.Add(1)
@@ -138,16 +186,51 @@ func (w *Writer) main() {
 			}
 		case <-w.exit:
 			log.Info("exiting, trying to flush all remaining data")
-			w.Flush()
+			w.flushOnExit()
 			return
 		}
 	}
 }
 
+// flushOnExit buffers the payloads still waiting in the input channel and
+// tries to flush everything, retrying until the buffer is empty or the
+// configured exit timeout is reached.
+func (w *Writer) flushOnExit() {
+	for done := false; !done; {
+		select {
+		case p := <-w.inPayloads:
+			if !p.IsEmpty() {
+				w.payloadBuffer = append(w.payloadBuffer, newWriterPayload(p, w.endpoint))
+			}
+		default:
+			done = true
+		}
+	}
+
+	deadline := time.Now().Add(w.conf.ExitFlushTimeout)
+	w.Flush()
+	for len(w.payloadBuffer) > 0 && time.Now().Add(exitFlushRetryDelay).Before(deadline) {
+		time.Sleep(exitFlushRetryDelay)
+		w.Flush()
+	}
+
+	if n := len(w.payloadBuffer); n > 0 {
+		log.Errorf("could not flush %d payloads before exiting", n)
+		statsd.Client.Count("datadog.trace_agent.writer.dropped_payload",
+			int64(n), []string{"reason:exit"}, 1)
+	}
+}
+
 // Stop stops the main Run loop
 func (w *Writer) Stop() {
 	close(w.exit)
 	w.exitWG.Wait()
+
+	if c, ok := w.endpoint.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Errorf("cannot close output: %v", err)
+		}
+	}
 }
 
 // FlushServices initiate a flush of the services to the services endpoint
@@ -223,7 +306,7 @@ func (w *Writer) Flush() {
 
 	// Drop payloads to respect the buffer size limit if necessary.
 	nbDrops := 0
-	for n := 0; n < len(payloads) && bufSize > w.conf.APIPayloadBufferMaxSize; n++ {
+	for n := 0; n < len(payloads) && bufSize > w.payloadBufferMaxSize(); n++ {
 		bufSize -= payloads[n].size
 		nbDrops++
 	}
@@ -238,6 +321,7 @@ func (w *Writer) Flush() {
 
 	statsd.Client.Gauge("datadog.trace_agent.writer.payload_buffer_size",
 		float64(bufSize), nil, 1)
+	updateWriterInfo(writerInfo{PayloadBufferLen: len(payloads), PayloadBufferSize: bufSize})
 
 	w.payloadBuffer = payloads
 }
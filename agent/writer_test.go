@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -201,6 +202,7 @@ func TestWriterBuffering(t *testing.T) {
 	conf.APIEndpoint = server.URL
 	conf.APIKey = "key"
 	conf.APIPayloadBufferMaxSize = payloadSizes[0] + payloadSizes[1]
+	conf.ExitFlushTimeout = 0
 
 	w := NewWriter(conf)
 	// Make the chan unbuffered to block on write
@@ -245,3 +247,41 @@ func TestWriterDisabledBuffering(t *testing.T) {
 	// dropped and the buffer should be empty.
 	assert.Equal(0, len(w.payloadBuffer))
 }
+
+func TestWriterExitFlushRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make(chan dataFromAPI, 1)
+	received := newTestServer(t, data)
+	defer received.Close()
+
+	// fail the first request, then forward to a working server
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.ExitFlushTimeout = 2 * payloadResendDelay
+
+	w := NewWriter(conf)
+	w.Run()
+
+	w.inPayloads <- newTestPayload("test")
+	w.Stop()
+
+	select {
+	case received := <-data:
+		assert.Equal("/api/v0.1/collector", received.urlPath)
+	default:
+		t.Fatal("payload should have been flushed on exit")
+	}
+	assert.Equal(0, len(w.payloadBuffer))
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"strconv"
@@ -32,10 +34,60 @@ type AgentConfig struct {
 	APIKey                  string `json:"-"` // never publish this
 	APIEnabled              bool
 	APIPayloadBufferMaxSize int
+	ExitFlushTimeout        time.Duration // how long we keep on trying to flush payloads on exit
+
+	// APIPayloadVersion is the encoding of the payloads sent to the API,
+	// and APIPayloadCompression their compression if it supports several
+	APIPayloadVersion     model.AgentPayloadVersion
+	APIPayloadCompression model.AgentPayloadCompression
+
+	// APIOutput replaces the API with a local output for the payloads and
+	// the services, either "stdout" or a directory as "file:///path/to/dir"
+	APIOutput         string
+	APIOutputGzip     bool  // gzip the output files
+	APIOutputMaxSize  int64 // size of an output file before starting a new one, in bytes
+	APIOutputMaxFiles int   // number of output files kept, 0 to keep all of them
+
+	// APIRelay replaces the API with another trace-agent, as
+	// "http://host:port", which merges the payloads of many hosts and
+	// forwards them to the API
+	APIRelay string
 
 	// Concentrator
-	BucketInterval   time.Duration // the size of our pre-aggregation per bucket
-	ExtraAggregators []string
+	BucketInterval    time.Duration // the size of our pre-aggregation per bucket
+	ExtraAggregators  []string
+	ExtraMetrics      []string // span metrics for which we compute sums and distributions
+	StatsTopLevelOnly bool     // only compute stats for top-level spans, and spans forcing them
+	MaxGrains         int      // maximum number of distinct grains per bucket, 0 for no limit
+	MaxServiceGrains  int      // maximum number of distinct grains per service and bucket, 0 for no limit
+
+	// StatsDistribution is the structure backing the stats distributions,
+	// either GKDistribution or SketchDistribution
+	StatsDistribution      string
+	SketchRelativeAccuracy float64 // relative accuracy of the quantiles of sketch distributions
+
+	// BucketsKeptOpen is the number of buckets kept open before being
+	// flushed, which is how late spans can be without being late spans
+	BucketsKeptOpen int
+	// LateSpanPolicy tells how spans older than the buckets kept open are
+	// handled, one of LateSpanDrop, LateSpanReattribute or LateSpanCorrect
+	LateSpanPolicy string
+
+	// Trace assembler, buffering partial traces until their root arrives
+	AssemblerEnabled  bool
+	AssemblerTimeout  time.Duration // how long we wait for the root of a trace
+	AssemblerMaxSpans int           // maximum number of spans buffered
+
+	// DebugTraceBufferSize is the number of recent traces kept in memory to
+	// be inspected on /debug/traces, 0 to disable it
+	DebugTraceBufferSize int
+
+	// CaptureFile is where the requests to the receiver are recorded when
+	// the capture is enabled on /debug/capture, empty to disable it
+	CaptureFile    string
+	CaptureMaxSize int64   // size of the capture file before rotating it, in bytes
+	CaptureRate    float64 // fraction of the requests recorded
+	CaptureEnabled bool    // record requests from the start
 
 	// Sampler configuration
 	ExtraSampleRate float64
@@ -48,6 +100,22 @@ type AgentConfig struct {
 	ConnectionLimit int // for rate-limiting, how many unique connections to allow in a lease period (30s)
 	ReceiverTimeout int
 
+	// AcceptAgentPayloads enables the endpoint receiving the payloads
+	// relayed by other trace-agents
+	AcceptAgentPayloads bool
+
+	// TraceRepairStrategy tells how traces with structural anomalies are repaired
+	TraceRepairStrategy model.RepairStrategy
+
+	// Sublayers
+	SublayerBreakdowns []model.SublayerBreakdown // extra dimensions sublayer durations are broken down by
+	SublayersTopLevel  bool                      // compute sublayers for every top-level span, not only the root
+	CriticalPath       bool                      // compute the critical path time by service of traces
+
+	// Clock skew correction, shifting spans from another service not to start before their parent
+	ClockSkewCorrection bool            // default for all services
+	ClockSkewServices   map[string]bool // per-service overrides of ClockSkewCorrection
+
 	// internal telemetry
 	StatsdHost string
 	StatsdPort int
@@ -62,11 +130,91 @@ type AgentConfig struct {
 	MaxCPU           float64       // MaxCPU is the max UserAvg CPU the program should consume
 	MaxConnections   int           // MaxConnections is the threshold (opened TCP connections) above which program panics and exits, to be restarted
 	WatchdogInterval time.Duration // WatchdogInterval is the delay between 2 watchdog checks
+	// WatchdogSoftLimit is the fraction of MaxMemory and MaxConnections above
+	// which the agent sheds load, 0 to disable it
+	WatchdogSoftLimit float64
+	// WatchdogHardLimitChecks is the number of consecutive watchdog checks
+	// above MaxMemory or MaxConnections after which the program exits
+	WatchdogHardLimitChecks int
+	// PreSampleMemoryTarget is the fraction of MaxMemory the pre-sampler
+	// aims to keep the heap below, 0 to only pre-sample on CPU usage
+	PreSampleMemoryTarget float64
+	// PreSampleQueueTarget is the fill ratio of the receiver queue the
+	// pre-sampler aims to keep it below, 0 to only pre-sample on CPU usage
+	PreSampleQueueTarget float64
 
 	// http/s proxying
 	Proxy *ProxySettings
 
 	Ignore map[string][]string
+
+	// errs are the invalid values met while loading the config
+	errs []error
+}
+
+// errorf logs an invalid config value, and keeps it to be reported by
+// CheckConfig.
+func (c *AgentConfig) errorf(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	log.Error(err)
+	c.errs = append(c.errs, err)
+}
+
+// getInt returns the integer value of section/name in conf, if it is set.
+// A value which can't be parsed is reported and ignored.
+func (c *AgentConfig) getInt(conf *File, section, name string) (int, bool) {
+	v, err := conf.GetInt(section, name)
+	if err != nil {
+		c.invalidValue(conf, section, name)
+		return 0, false
+	}
+	return v, true
+}
+
+// getFloat returns the float value of section/name in conf, if it is set.
+// A value which can't be parsed is reported and ignored.
+func (c *AgentConfig) getFloat(conf *File, section, name string) (float64, bool) {
+	v, err := conf.GetFloat(section, name)
+	if err != nil {
+		c.invalidValue(conf, section, name)
+		return 0, false
+	}
+	return v, true
+}
+
+// invalidValue reports the value of section/name in conf as invalid, unless
+// it is not set.
+func (c *AgentConfig) invalidValue(conf *File, section, name string) {
+	if v, _ := conf.Get(section, name); v != "" {
+		c.errorf("%s: invalid %s in [%s]: %q", conf.Path, name, section, v)
+	}
+}
+
+// Structures backing the stats distributions, see AgentConfig.StatsDistribution.
+const (
+	// GKDistribution is a GK summary, with an accuracy on the rank of quantiles
+	GKDistribution = "gk"
+	// SketchDistribution is a log sketch, with an accuracy relative to the value of quantiles
+	SketchDistribution = "sketch"
+)
+
+// Policies for the spans older than the buckets kept open, see AgentConfig.LateSpanPolicy.
+const (
+	// LateSpanDrop drops late spans, and the traces whose root is late
+	LateSpanDrop = "drop"
+	// LateSpanReattribute accounts late spans in the oldest open bucket
+	LateSpanReattribute = "reattribute"
+	// LateSpanCorrect accounts late spans in correction buckets for their own time frame
+	LateSpanCorrect = "correct"
+)
+
+// ClockSkewCorrectionEnabled tells if the clock skew of spans from the given
+// service should be corrected.
+func (c *AgentConfig) ClockSkewCorrectionEnabled(service string) bool {
+	if enabled, ok := c.ClockSkewServices[service]; ok {
+		return enabled
+	}
+	return c.ClockSkewCorrection
 }
 
 // mergeEnv applies overrides from environment variables to the trace agent configuration
@@ -94,7 +242,7 @@ func mergeEnv(c *AgentConfig) {
 	if v := os.Getenv("DD_RECEIVER_PORT"); v != "" {
 		port, err := strconv.Atoi(v)
 		if err != nil {
-			log.Info("Failed to parse DD_RECEIVER_PORT: it should be a port number")
+			c.errorf("failed to parse DD_RECEIVER_PORT: it should be a port number, got %q", v)
 		} else {
 			c.ReceiverPort = port
 		}
@@ -112,7 +260,7 @@ func mergeEnv(c *AgentConfig) {
 	if v := os.Getenv("DD_DOGSTATSD_PORT"); v != "" {
 		port, err := strconv.Atoi(v)
 		if err != nil {
-			log.Info("Failed to parse DD_DOGSTATSD_PORT: it should be a port number")
+			c.errorf("failed to parse DD_DOGSTATSD_PORT: it should be a port number, got %q", v)
 		} else {
 			c.StatsdPort = port
 		}
@@ -166,9 +314,29 @@ func NewDefaultAgentConfig() *AgentConfig {
 		APIKey:                  "",
 		APIEnabled:              true,
 		APIPayloadBufferMaxSize: 16 * 1024 * 1024,
+		ExitFlushTimeout:        10 * time.Second,
+		APIPayloadVersion:       model.AgentPayloadV01,
+		APIPayloadCompression:   model.GzipCompression,
+		APIOutputMaxSize:        100 * 1024 * 1024,
 
-		BucketInterval:   time.Duration(10) * time.Second,
-		ExtraAggregators: []string{"http.status_code"},
+		BucketInterval:    time.Duration(10) * time.Second,
+		ExtraAggregators:  []string{"http.status_code"},
+		StatsTopLevelOnly: true,
+		MaxGrains:         100000,
+		MaxServiceGrains:  10000,
+
+		StatsDistribution:      GKDistribution,
+		SketchRelativeAccuracy: 0.01,
+
+		BucketsKeptOpen: 2,
+		LateSpanPolicy:  LateSpanDrop,
+
+		AssemblerEnabled:  false,
+		AssemblerTimeout:  10 * time.Second,
+		AssemblerMaxSpans: 100000,
+
+		CaptureMaxSize: 10 * 1024 * 1024,
+		CaptureRate:    1.0,
 
 		ExtraSampleRate: 1.0,
 		PreSampleRate:   1.0,
@@ -178,6 +346,11 @@ func NewDefaultAgentConfig() *AgentConfig {
 		ReceiverPort:    8126,
 		ConnectionLimit: 2000,
 
+		TraceRepairStrategy: model.RepairNone,
+
+		ClockSkewCorrection: false,
+		ClockSkewServices:   make(map[string]bool),
+
 		StatsdHost: "localhost",
 		StatsdPort: 8125,
 
@@ -190,14 +363,55 @@ func NewDefaultAgentConfig() *AgentConfig {
 		MaxConnections:   200, // in practice, rarely goes over 20
 		WatchdogInterval: time.Minute,
 
+		WatchdogSoftLimit:       0.8,
+		WatchdogHardLimitChecks: 3,
+		PreSampleMemoryTarget:   0.5,
+		PreSampleQueueTarget:    0.5,
+
 		Ignore: make(map[string][]string),
 	}
 
 	return ac
 }
 
+// cgroupLimitFraction is the fraction of the limits of the cgroup of the
+// agent MaxCPU and MaxMemory are kept below, leaving some room before the
+// kernel throttles or kills it.
+const cgroupLimitFraction = 0.8
+
+// ApplyCgroupLimits lowers MaxCPU and MaxMemory to a fraction of the CPU
+// quota, in cores, and memory limit, in bytes, of the cgroup of the agent,
+// if they are above. A limit of 0 means unlimited.
+func (c *AgentConfig) ApplyCgroupLimits(cpu, memory float64) {
+	if v := cpu * cgroupLimitFraction; v > 0 && v < c.MaxCPU {
+		log.Infof("lowering max CPU to %0.1f %% of a core, %0.f %% of the cgroup quota", v*100, cgroupLimitFraction*100)
+		c.MaxCPU = v
+	}
+	if v := memory * cgroupLimitFraction; v > 0 && v < c.MaxMemory {
+		log.Infof("lowering max memory to %d bytes, %0.f %% of the cgroup limit", int64(v), cgroupLimitFraction*100)
+		c.MaxMemory = v
+	}
+}
+
 // NewAgentConfig creates the AgentConfig from the standard config
 func NewAgentConfig(conf *File, legacyConf *File) (*AgentConfig, error) {
+	c := loadConfigFiles(conf, legacyConf)
+
+	// environment variables have precedence among defaults and the config file
+	mergeEnv(c)
+
+	// check for api-endpoint parity after all possible overrides have been applied,
+	// no API key is needed when writing to a local output or to a relay instead
+	if c.APIKey == "" && c.APIOutput == "" && c.APIRelay == "" {
+		return c, errors.New("you must specify an API Key, either via a configuration file or the DD_API_KEY env var")
+	}
+
+	return c, nil
+}
+
+// loadConfigFiles creates the AgentConfig from the defaults and the config
+// files, the trace sections of legacyConf replacing the ones of conf if given.
+func loadConfigFiles(conf *File, legacyConf *File) *AgentConfig {
 	c := NewDefaultAgentConfig()
 	var m *ini.Section
 	var err error
@@ -234,7 +448,7 @@ func NewAgentConfig(conf *File, legacyConf *File) (*AgentConfig, error) {
 			c.ReceiverHost = "0.0.0.0"
 		}
 
-		if v := m.Key("dogstatsd_port").MustInt(-1); v != -1 {
+		if v, ok := c.getInt(conf, "Main", "dogstatsd_port"); ok {
 			c.StatsdPort = v
 		}
 		if v := m.Key("log_level").MustString(""); v != "" {
@@ -244,6 +458,8 @@ func NewAgentConfig(conf *File, legacyConf *File) (*AgentConfig, error) {
 		if p := getProxySettings(m); p.Host != "" {
 			c.Proxy = p
 		}
+		// getProxySettings ignores a port it can't parse, still report it
+		c.getInt(conf, "Main", "proxy_port")
 	}
 
 APM_CONF:
@@ -254,7 +470,7 @@ APM_CONF:
 	}
 
 	if conf == nil {
-		goto ENV_CONF
+		return c
 	}
 
 	if v := strings.ToLower(conf.GetDefault("Main", "apm_enabled", "")); v == "no" || v == "false" {
@@ -299,11 +515,65 @@ APM_CONF:
 		c.APIEndpoint = vals[0]
 	}
 
-	if v, e := conf.GetInt("trace.api", "payload_buffer_max_size"); e == nil {
+	if v, ok := c.getInt(conf, "trace.api", "payload_buffer_max_size"); ok {
 		c.APIPayloadBufferMaxSize = v
 	}
 
-	if v, e := conf.GetInt("trace.concentrator", "bucket_size_seconds"); e == nil {
+	if v, ok := c.getInt(conf, "trace.api", "exit_flush_timeout_seconds"); ok {
+		c.ExitFlushTimeout = time.Duration(v) * time.Second
+	}
+
+	if v := conf.GetDefault("trace.api", "payload_version", ""); v != "" {
+		switch pv := model.AgentPayloadVersion(v); pv {
+		case model.AgentPayloadV01, model.AgentPayloadV02:
+			c.APIPayloadVersion = pv
+		default:
+			c.errorf("unknown payload version %q, using %q", v, c.APIPayloadVersion)
+		}
+	}
+	if v := conf.GetDefault("trace.api", "payload_compression", ""); v != "" {
+		switch pc := model.AgentPayloadCompression(strings.ToLower(v)); pc {
+		case model.GzipCompression, model.ZstdCompression:
+			if !model.CompressionSupported(pc) {
+				c.errorf("payload compression %q is not built in, using %q", v, c.APIPayloadCompression)
+				break
+			}
+			c.APIPayloadCompression = pc
+		default:
+			c.errorf("unknown payload compression %q, using %q", v, c.APIPayloadCompression)
+		}
+	}
+
+	if v := conf.GetDefault("trace.api", "output", ""); v != "" {
+		if u, err := url.Parse(v); v == "stdout" || (err == nil && u.Scheme == "file" && u.Path != "") {
+			c.APIOutput = v
+		} else {
+			c.errorf("invalid output %q, should be stdout or file:///path/to/dir", v)
+		}
+	}
+	if v := strings.ToLower(conf.GetDefault("trace.api", "output_gzip", "")); v == "yes" || v == "true" {
+		c.APIOutputGzip = true
+	}
+	if v, ok := c.getInt(conf, "trace.api", "output_max_size_mb"); ok {
+		if v > 0 {
+			c.APIOutputMaxSize = int64(v) * 1024 * 1024
+		} else {
+			c.errorf("output_max_size_mb should be positive, using %d", c.APIOutputMaxSize/1024/1024)
+		}
+	}
+	if v, ok := c.getInt(conf, "trace.api", "output_max_files"); ok {
+		c.APIOutputMaxFiles = v
+	}
+
+	if v := conf.GetDefault("trace.api", "relay", ""); v != "" {
+		if u, err := url.Parse(v); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			c.APIRelay = strings.TrimRight(v, "/")
+		} else {
+			c.errorf("invalid relay %q, should be http://host:port", v)
+		}
+	}
+
+	if v, ok := c.getInt(conf, "trace.concentrator", "bucket_size_seconds"); ok {
 		c.BucketInterval = time.Duration(v) * time.Second
 	}
 
@@ -313,52 +583,219 @@ APM_CONF:
 		log.Debug("No aggregator configuration, using defaults")
 	}
 
-	if v, e := conf.GetFloat("trace.sampler", "extra_sample_rate"); e == nil {
+	if v, e := conf.GetStrArray("trace.concentrator", "extra_metrics", ','); e == nil {
+		c.ExtraMetrics = v
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.concentrator", "top_level_only", "")); v == "no" || v == "false" {
+		c.StatsTopLevelOnly = false
+	}
+
+	if v, ok := c.getInt(conf, "trace.concentrator", "max_grains_per_bucket"); ok {
+		c.MaxGrains = v
+	}
+
+	if v, ok := c.getInt(conf, "trace.concentrator", "max_grains_per_service"); ok {
+		c.MaxServiceGrains = v
+	}
+
+	if v, _ := conf.Get("trace.concentrator", "distribution"); v != "" {
+		switch v = strings.ToLower(v); v {
+		case GKDistribution, SketchDistribution:
+			c.StatsDistribution = v
+		default:
+			c.errorf("unknown distribution %q, using %q", v, c.StatsDistribution)
+		}
+	}
+	// v0.1 payloads go to an intake which only reads GK summaries
+	if c.StatsDistribution == SketchDistribution && c.APIPayloadVersion == model.AgentPayloadV01 {
+		c.StatsDistribution = GKDistribution
+		c.errorf("distribution %q requires payload_version %s, using %q", SketchDistribution, model.AgentPayloadV02, c.StatsDistribution)
+	}
+
+	if v, ok := c.getInt(conf, "trace.concentrator", "buckets_kept_open"); ok {
+		if v >= 1 {
+			c.BucketsKeptOpen = v
+		} else {
+			c.errorf("buckets_kept_open should be at least 1, using %d", c.BucketsKeptOpen)
+		}
+	}
+
+	if v, _ := conf.Get("trace.concentrator", "late_span_policy"); v != "" {
+		switch v = strings.ToLower(v); v {
+		case LateSpanDrop, LateSpanReattribute, LateSpanCorrect:
+			c.LateSpanPolicy = v
+		default:
+			c.errorf("unknown late span policy %q, using %q", v, c.LateSpanPolicy)
+		}
+	}
+
+	if v, ok := c.getFloat(conf, "trace.concentrator", "sketch_relative_accuracy"); ok {
+		if v > 0 && v < 1 {
+			c.SketchRelativeAccuracy = v
+		} else {
+			c.errorf("sketch_relative_accuracy should be between 0 and 1, using %v", c.SketchRelativeAccuracy)
+		}
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.assembler", "enabled", "")); v == "yes" || v == "true" {
+		c.AssemblerEnabled = true
+	}
+
+	if v, ok := c.getInt(conf, "trace.assembler", "timeout_seconds"); ok {
+		c.AssemblerTimeout = time.Duration(v) * time.Second
+	}
+
+	if v, ok := c.getInt(conf, "trace.assembler", "max_pending_spans"); ok {
+		c.AssemblerMaxSpans = v
+	}
+
+	// traces released on timeout must still be in the buckets kept open,
+	// or they would be dropped as too late
+	if window := time.Duration(c.BucketsKeptOpen) * c.BucketInterval; c.AssemblerEnabled && c.AssemblerTimeout >= window {
+		c.AssemblerTimeout = window / 2
+		c.errorf("assembler timeout_seconds should be below buckets_kept_open*bucket_size_seconds (%s), using %s", window, c.AssemblerTimeout)
+	}
+
+	if v, ok := c.getFloat(conf, "trace.sampler", "extra_sample_rate"); ok {
 		c.ExtraSampleRate = v
 	}
-	if v, e := conf.GetFloat("trace.sampler", "pre_sample_rate"); e == nil {
+	if v, ok := c.getFloat(conf, "trace.sampler", "pre_sample_rate"); ok {
 		c.PreSampleRate = v
 	}
-	if v, e := conf.GetFloat("trace.sampler", "max_traces_per_second"); e == nil {
+	if v, ok := c.getFloat(conf, "trace.sampler", "max_traces_per_second"); ok {
 		c.MaxTPS = v
 	}
 
-	if v, e := conf.GetInt("trace.receiver", "receiver_port"); e == nil {
+	if v, ok := c.getInt(conf, "trace.receiver", "receiver_port"); ok {
 		c.ReceiverPort = v
 	}
 
-	if v, e := conf.GetInt("trace.receiver", "connection_limit"); e == nil {
+	if v, ok := c.getInt(conf, "trace.receiver", "connection_limit"); ok {
 		c.ConnectionLimit = v
 	}
 
-	if v, e := conf.GetInt("trace.receiver", "timeout"); e == nil {
+	if v := strings.ToLower(conf.GetDefault("trace.receiver", "accept_agent_payloads", "")); v == "yes" || v == "true" {
+		c.AcceptAgentPayloads = true
+	}
+
+	if v, ok := c.getInt(conf, "trace.receiver", "timeout"); ok {
 		c.ReceiverTimeout = v
 	}
 
-	if v, e := conf.GetFloat("trace.watchdog", "max_memory"); e == nil {
+	if v, _ := conf.Get("trace.receiver", "trace_repair_strategy"); v != "" {
+		if rs, err := model.ParseRepairStrategy(strings.ToLower(v)); err == nil {
+			c.TraceRepairStrategy = rs
+		} else {
+			c.errorf("%v, using %q", err, c.TraceRepairStrategy)
+		}
+	}
+
+	if v, ok := c.getInt(conf, "trace.debug", "trace_buffer_size"); ok {
+		c.DebugTraceBufferSize = v
+	}
+
+	c.CaptureFile = conf.GetDefault("trace.debug", "capture_file", c.CaptureFile)
+	if v, ok := c.getInt(conf, "trace.debug", "capture_max_size_mb"); ok {
+		if v > 0 {
+			c.CaptureMaxSize = int64(v) * 1024 * 1024
+		} else {
+			c.errorf("capture_max_size_mb should be positive, using %d", c.CaptureMaxSize/1024/1024)
+		}
+	}
+	if v, ok := c.getFloat(conf, "trace.debug", "capture_percent"); ok {
+		if v >= 0 && v <= 100 {
+			c.CaptureRate = v / 100
+		} else {
+			c.errorf("capture_percent should be between 0 and 100, using %v", c.CaptureRate*100)
+		}
+	}
+	if v := strings.ToLower(conf.GetDefault("trace.debug", "capture_enabled", "")); v == "yes" || v == "true" {
+		c.CaptureEnabled = true
+	}
+
+	if v, e := conf.GetStrArray("trace.sublayers", "breakdowns", ','); e == nil {
+		for _, s := range v {
+			b, err := model.ParseSublayerBreakdown(strings.TrimSpace(s))
+			if err != nil {
+				c.errorf("%v, ignoring it", err)
+				continue
+			}
+			c.SublayerBreakdowns = append(c.SublayerBreakdowns, b)
+		}
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.sublayers", "top_level_spans", "")); v == "yes" || v == "true" {
+		c.SublayersTopLevel = true
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.sublayers", "critical_path", "")); v == "yes" || v == "true" {
+		c.CriticalPath = true
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.clock_skew", "enabled", "")); v == "yes" || v == "true" {
+		c.ClockSkewCorrection = true
+	}
+
+	if v, e := conf.GetStrArray("trace.clock_skew", "enabled_services", ','); e == nil {
+		for _, service := range v {
+			c.ClockSkewServices[service] = true
+		}
+	}
+
+	if v, e := conf.GetStrArray("trace.clock_skew", "disabled_services", ','); e == nil {
+		for _, service := range v {
+			c.ClockSkewServices[service] = false
+		}
+	}
+
+	if v, ok := c.getFloat(conf, "trace.watchdog", "max_memory"); ok {
 		c.MaxMemory = v
 	}
 
-	if v, e := conf.GetFloat("trace.watchdog", "max_cpu_percent"); e == nil {
+	if v, ok := c.getFloat(conf, "trace.watchdog", "max_cpu_percent"); ok {
 		c.MaxCPU = v / 100
 	}
 
-	if v, e := conf.GetInt("trace.watchdog", "max_connections"); e == nil {
+	if v, ok := c.getFloat(conf, "trace.watchdog", "presample_memory_percent"); ok {
+		if v >= 0 && v <= 100 {
+			c.PreSampleMemoryTarget = v / 100
+		} else {
+			c.errorf("presample_memory_percent should be between 0 and 100, using %v", c.PreSampleMemoryTarget*100)
+		}
+	}
+
+	if v, ok := c.getFloat(conf, "trace.watchdog", "presample_queue_percent"); ok {
+		if v >= 0 && v <= 100 {
+			c.PreSampleQueueTarget = v / 100
+		} else {
+			c.errorf("presample_queue_percent should be between 0 and 100, using %v", c.PreSampleQueueTarget*100)
+		}
+	}
+
+	if v, ok := c.getInt(conf, "trace.watchdog", "max_connections"); ok {
 		c.MaxConnections = v
 	}
 
-	if v, e := conf.GetInt("trace.watchdog", "check_delay_seconds"); e == nil {
+	if v, ok := c.getInt(conf, "trace.watchdog", "check_delay_seconds"); ok {
 		c.WatchdogInterval = time.Duration(v) * time.Second
 	}
 
-ENV_CONF:
-	// environment variables have precedence among defaults and the config file
-	mergeEnv(c)
+	if v, ok := c.getFloat(conf, "trace.watchdog", "soft_limit_percent"); ok {
+		if v >= 0 && v <= 100 {
+			c.WatchdogSoftLimit = v / 100
+		} else {
+			c.errorf("soft_limit_percent should be between 0 and 100, using %v", c.WatchdogSoftLimit*100)
+		}
+	}
 
-	// check for api-endpoint parity after all possible overrides have been applied
-	if c.APIKey == "" {
-		return c, errors.New("you must specify an API Key, either via a configuration file or the DD_API_KEY env var")
+	if v, ok := c.getInt(conf, "trace.watchdog", "hard_limit_checks"); ok {
+		if v >= 1 {
+			c.WatchdogHardLimitChecks = v
+		} else {
+			c.errorf("hard_limit_checks should be at least 1, using %d", c.WatchdogHardLimitChecks)
+		}
 	}
 
-	return c, nil
+	return c
 }
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// Sources of the config values, as reported by CheckConfig.
+const (
+	SourceDefault    = "default"
+	SourceDDAgentINI = "dd-agent ini"
+	SourceTraceINI   = "trace ini"
+	SourceEnv        = "env"
+	SourceCgroup     = "cgroup"
+)
+
+// ConfigValue is a resolved config value, along with where it comes from.
+type ConfigValue struct {
+	Name   string
+	Value  interface{}
+	Source string
+}
+
+// CheckConfig loads the config the same way NewAgentConfig does, lowering it
+// to the given cgroup limits as ApplyCgroupLimits does, and returns all its
+// values with the source of each of them, along with the invalid values found
+// in the config files, the environment, or the resolved config.
+func CheckConfig(conf *File, legacyConf *File, cgroupCPU, cgroupMemory float64) ([]ConfigValue, []error) {
+	// load the config a layer at a time, the source of a value being
+	// the last layer which changed it
+	type layer struct {
+		c      *AgentConfig
+		source string
+	}
+	layers := []layer{{NewDefaultAgentConfig(), SourceDefault}}
+	traceSource := SourceDDAgentINI
+	if conf != nil {
+		layers = append(layers, layer{loadConfigFiles(conf, &File{instance: ini.Empty()}), SourceDDAgentINI})
+	}
+	if legacyConf != nil {
+		traceSource = SourceTraceINI
+	}
+	layers = append(layers, layer{loadConfigFiles(conf, legacyConf), traceSource})
+
+	c, err := NewAgentConfig(conf, legacyConf)
+	layers = append(layers, layer{c, SourceEnv})
+
+	limited := *c
+	limited.ApplyCgroupLimits(cgroupCPU, cgroupMemory)
+	layers = append(layers, layer{&limited, SourceCgroup})
+	c = &limited
+
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, c.errs...)
+	errs = append(errs, c.validate()...)
+
+	var values []ConfigValue
+	t := reflect.TypeOf(*c)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue // unexported, or never to be published
+		}
+
+		source := SourceDefault
+		for j := 1; j < len(layers); j++ {
+			prev := reflect.ValueOf(layers[j-1].c).Elem().Field(i).Interface()
+			cur := reflect.ValueOf(layers[j].c).Elem().Field(i).Interface()
+			if !reflect.DeepEqual(prev, cur) {
+				source = layers[j].source
+			}
+		}
+
+		value := reflect.ValueOf(c).Elem().Field(i).Interface()
+		if p, ok := value.(*ProxySettings); ok && p != nil {
+			proxy := *p
+			proxy.Password = "" // never to be published either
+			value = &proxy
+		}
+		values = append(values, ConfigValue{Name: field.Name, Value: value, Source: source})
+	}
+
+	return values, errs
+}
+
+// validate returns the errors found in the resolved config values.
+func (c *AgentConfig) validate() []error {
+	var errs []error
+
+	for _, port := range []struct {
+		name  string
+		value int
+	}{
+		{"receiver port", c.ReceiverPort},
+		{"statsd port", c.StatsdPort},
+	} {
+		if port.value <= 0 || port.value > 65535 {
+			errs = append(errs, fmt.Errorf("invalid %s %d, should be between 1 and 65535", port.name, port.value))
+		}
+	}
+
+	for _, rate := range []struct {
+		name  string
+		value float64
+	}{
+		{"extra sample rate", c.ExtraSampleRate},
+		{"pre-sample rate", c.PreSampleRate},
+	} {
+		if rate.value < 0 || rate.value > 1 {
+			errs = append(errs, fmt.Errorf("invalid %s %v, should be between 0 and 1", rate.name, rate.value))
+		}
+	}
+	if c.MaxTPS < 0 {
+		errs = append(errs, fmt.Errorf("invalid max traces per second %v, should be positive", c.MaxTPS))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"bucket interval", c.BucketInterval},
+		{"watchdog interval", c.WatchdogInterval},
+		{"assembler timeout", c.AssemblerTimeout},
+	} {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("invalid %s %v, should be positive", d.name, d.value))
+		}
+	}
+	if c.ExitFlushTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid exit flush timeout %v, should be positive", c.ExitFlushTimeout))
+	}
+
+	for _, entry := range c.Ignore["resource"] {
+		if _, err := regexp.Compile(entry); err != nil {
+			errs = append(errs, fmt.Errorf("invalid resource filter %q: %v", entry, err))
+		}
+	}
+
+	if u, err := url.Parse(c.APIEndpoint); err != nil {
+		errs = append(errs, fmt.Errorf("invalid API endpoint %q: %v", c.APIEndpoint, err))
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid API endpoint %q, should be an http(s) URL", c.APIEndpoint))
+	}
+
+	if c.Proxy != nil {
+		if u, err := c.Proxy.URL(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid proxy %s://%s:%d: %v", c.Proxy.Scheme, c.Proxy.Host, c.Proxy.Port, err))
+		} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			errs = append(errs, fmt.Errorf("invalid proxy scheme %q", u.Scheme))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-ini/ini"
+	"github.com/stretchr/testify/assert"
+)
+
+func checkConfigValues(values []ConfigValue) map[string]ConfigValue {
+	m := make(map[string]ConfigValue, len(values))
+	for _, v := range values {
+		m[v.Name] = v
+	}
+	return m
+}
+
+func TestCheckConfigSources(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"hostname = thing",
+		"api_key = apikey_12",
+		"proxy_host = myproxy.com",
+		"proxy_user = user",
+		"proxy_password = secret",
+	}, "\n")))
+	legacy, _ := ini.Load([]byte(strings.Join([]string{
+		"[trace.config]",
+		"env = prod",
+		"[trace.receiver]",
+		"receiver_port = 8200",
+	}, "\n")))
+	os.Setenv("DD_RECEIVER_PORT", "8300")
+	defer os.Unsetenv("DD_RECEIVER_PORT")
+
+	values, errs := CheckConfig(&File{instance: dd, Path: "datadog.conf"}, &File{instance: legacy, Path: "trace-agent.ini"}, 0, 0)
+	assert.Empty(errs)
+
+	m := checkConfigValues(values)
+	assert.Equal(ConfigValue{"HostName", "thing", SourceDDAgentINI}, m["HostName"])
+	assert.Equal(ConfigValue{"DefaultEnv", "prod", SourceTraceINI}, m["DefaultEnv"])
+	assert.Equal(ConfigValue{"ReceiverPort", 8300, SourceEnv}, m["ReceiverPort"])
+	assert.Equal(ConfigValue{"StatsdPort", 8125, SourceDefault}, m["StatsdPort"])
+
+	_, ok := m["APIKey"]
+	assert.False(ok, "API keys should *NEVER* be shown")
+	proxy := m["Proxy"].Value.(*ProxySettings)
+	assert.Equal("user", proxy.User)
+	assert.Equal("", proxy.Password, "proxy passwords should not be shown")
+}
+
+func TestCheckConfigTraceSectionsOfDDAgentConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.sampler]",
+		"extra_sample_rate = 0.5",
+	}, "\n")))
+
+	values, errs := CheckConfig(&File{instance: dd, Path: "datadog.conf"}, nil, 0, 0)
+	assert.Empty(errs)
+	assert.Equal(ConfigValue{"ExtraSampleRate", 0.5, SourceDDAgentINI}, checkConfigValues(values)["ExtraSampleRate"])
+}
+
+func TestCheckConfigErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.api]",
+		"endpoint = trace.agent.datadoghq.com",
+		"[trace.ignore]",
+		`resource = "GET /healthcheck", "["`,
+		"[trace.sampler]",
+		"extra_sample_rate = 1.5",
+		"pre_sample_rate = lots",
+		"[trace.concentrator]",
+		"late_span_policy = whatever",
+		"max_grains_per_bucket = 1e4",
+		"[trace.receiver]",
+		"timeout = 5s",
+		"[trace.watchdog]",
+		"max_connections = many",
+	}, "\n")))
+	os.Setenv("DD_DOGSTATSD_PORT", "statsd")
+	defer os.Unsetenv("DD_DOGSTATSD_PORT")
+
+	_, errs := CheckConfig(&File{instance: dd, Path: "datadog.conf"}, nil, 0, 0)
+
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal([]string{
+		`datadog.conf: invalid max_grains_per_bucket in [trace.concentrator]: "1e4"`,
+		`unknown late span policy "whatever", using "drop"`,
+		`datadog.conf: invalid pre_sample_rate in [trace.sampler]: "lots"`,
+		`datadog.conf: invalid timeout in [trace.receiver]: "5s"`,
+		`datadog.conf: invalid max_connections in [trace.watchdog]: "many"`,
+		`failed to parse DD_DOGSTATSD_PORT: it should be a port number, got "statsd"`,
+		`invalid extra sample rate 1.5, should be between 0 and 1`,
+		"invalid resource filter \"[\": error parsing regexp: missing closing ]: `[`",
+		`invalid API endpoint "trace.agent.datadoghq.com", should be an http(s) URL`,
+	}, msgs)
+}
+
+func TestCheckConfigCgroupLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.watchdog]",
+		"max_memory = 1e9",
+	}, "\n")))
+
+	values, errs := CheckConfig(&File{instance: dd, Path: "datadog.conf"}, nil, 0.5, 5e8)
+	assert.Empty(errs)
+	m := checkConfigValues(values)
+	assert.Equal(ConfigValue{"MaxMemory", 4e8, SourceCgroup}, m["MaxMemory"])
+	assert.Equal(ConfigValue{"MaxCPU", 0.4, SourceCgroup}, m["MaxCPU"])
+
+	// limits above the configured ones leave them as they are
+	values, _ = CheckConfig(&File{instance: dd, Path: "datadog.conf"}, nil, 8, 8e9)
+	assert.Equal(ConfigValue{"MaxMemory", 1e9, SourceDDAgentINI}, checkConfigValues(values)["MaxMemory"])
+}
+
+func TestCheckConfigNoAPIKey(t *testing.T) {
+	_, errs := CheckConfig(nil, nil, 0, 0)
+	assert.Len(t, errs, 1)
+}
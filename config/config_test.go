@@ -3,12 +3,15 @@ package config
 import (
 	"os"
 	"strings"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"testing"
 
 	"github.com/go-ini/ini"
+
+	"github.com/DataDog/datadog-trace-agent/model"
 )
 
 func TestGetStrArray(t *testing.T) {
@@ -185,6 +188,356 @@ func TestEmptyExtraAggregatorsFromConfig(t *testing.T) {
 	assert.Equal([]string{"http.status_code"}, agentConfig.ExtraAggregators)
 }
 
+func TestAssemblerConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"buckets_kept_open = 4",
+		"[trace.assembler]",
+		"enabled = true",
+		"timeout_seconds = 30",
+		"max_pending_spans = 500",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.True(agentConfig.AssemblerEnabled)
+	assert.Equal(30*time.Second, agentConfig.AssemblerTimeout)
+	assert.Equal(500, agentConfig.AssemblerMaxSpans)
+	assert.Len(agentConfig.errs, 0)
+
+	// the timeout has to fit in the buckets kept open, 2*10s by default
+	dd, _ = ini.Load([]byte("[trace.assembler]\nenabled = true\ntimeout_seconds = 30"))
+	agentConfig, _ = NewAgentConfig(&File{instance: dd, Path: "whatever"}, nil)
+	assert.Equal(10*time.Second, agentConfig.AssemblerTimeout)
+	assert.Len(agentConfig.errs, 1)
+}
+
+func TestTraceRepairStrategyConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(model.RepairNone, agentConfig.TraceRepairStrategy)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.receiver]",
+		"trace_repair_strategy = Reparent",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(model.RepairReparent, agentConfig.TraceRepairStrategy)
+}
+
+func TestClockSkewConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.clock_skew]",
+		"enabled = true",
+		"disabled_services = db, cache",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.True(agentConfig.ClockSkewCorrectionEnabled("web"))
+	assert.False(agentConfig.ClockSkewCorrectionEnabled("db"))
+	assert.False(agentConfig.ClockSkewCorrectionEnabled("cache"))
+
+	agentConfig = NewDefaultAgentConfig()
+	agentConfig.ClockSkewServices["web"] = true
+	assert.True(agentConfig.ClockSkewCorrectionEnabled("web"))
+	assert.False(agentConfig.ClockSkewCorrectionEnabled("db"))
+}
+
+func TestExtraMetricsConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"extra_metrics = db.rows, http.response_size",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal([]string{"db.rows", "http.response_size"}, agentConfig.ExtraMetrics)
+}
+
+func TestStatsDistributionConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(GKDistribution, agentConfig.StatsDistribution)
+
+	lines := []string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"distribution = sketch",
+		"sketch_relative_accuracy = 0.02",
+	}
+	dd, _ := ini.Load([]byte(strings.Join(append(lines, "[trace.api]", "payload_version = v0.2"), "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(SketchDistribution, agentConfig.StatsDistribution)
+	assert.Equal(0.02, agentConfig.SketchRelativeAccuracy)
+	assert.Len(agentConfig.errs, 0)
+
+	// v0.1 payloads can't carry sketches
+	dd, _ = ini.Load([]byte(strings.Join(lines, "\n")))
+	agentConfig, _ = NewAgentConfig(&File{instance: dd, Path: "whatever"}, nil)
+	assert.Equal(GKDistribution, agentConfig.StatsDistribution)
+	assert.Len(agentConfig.errs, 1)
+}
+
+func TestLateSpanConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(2, agentConfig.BucketsKeptOpen)
+	assert.Equal(LateSpanDrop, agentConfig.LateSpanPolicy)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"buckets_kept_open = 5",
+		"late_span_policy = Correct",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(5, agentConfig.BucketsKeptOpen)
+	assert.Equal(LateSpanCorrect, agentConfig.LateSpanPolicy)
+
+	dd, _ = ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.concentrator]",
+		"buckets_kept_open = 0",
+		"late_span_policy = keep",
+	}, "\n")))
+
+	conf = &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(2, agentConfig.BucketsKeptOpen)
+	assert.Equal(LateSpanDrop, agentConfig.LateSpanPolicy)
+}
+
+func TestSublayersConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.sublayers]",
+		"breakdowns = name, meta:db.instance, resource",
+		"top_level_spans = yes",
+		"critical_path = true",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal([]model.SublayerBreakdown{{}, {MetaKey: "db.instance"}}, agentConfig.SublayerBreakdowns)
+	assert.True(agentConfig.SublayersTopLevel)
+	assert.True(agentConfig.CriticalPath)
+}
+
+func TestDebugTraceBufferConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(0, agentConfig.DebugTraceBufferSize)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.debug]",
+		"trace_buffer_size = 500",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(500, agentConfig.DebugTraceBufferSize)
+}
+
+func TestWatchdogSoftLimitConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.watchdog]",
+		"soft_limit_percent = 50",
+		"hard_limit_checks = 5",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal(0.5, agentConfig.WatchdogSoftLimit)
+	assert.Equal(5, agentConfig.WatchdogHardLimitChecks)
+}
+
+func TestPreSampleTargetsConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(0.5, agentConfig.PreSampleMemoryTarget)
+	assert.Equal(0.5, agentConfig.PreSampleQueueTarget)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.watchdog]",
+		"presample_memory_percent = 30",
+		"presample_queue_percent = 150",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(0.3, agentConfig.PreSampleMemoryTarget)
+	assert.Equal(0.5, agentConfig.PreSampleQueueTarget, "invalid value, should use the default")
+}
+
+func TestCaptureConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal("", agentConfig.CaptureFile)
+	assert.False(agentConfig.CaptureEnabled)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.debug]",
+		"capture_file = /tmp/capture.ndjson",
+		"capture_max_size_mb = 0",
+		"capture_percent = 5",
+		"capture_enabled = yes",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal("/tmp/capture.ndjson", agentConfig.CaptureFile)
+	assert.Equal(int64(10*1024*1024), agentConfig.CaptureMaxSize, "invalid value, should use the default")
+	assert.Equal(0.05, agentConfig.CaptureRate)
+	assert.True(agentConfig.CaptureEnabled)
+}
+
+func TestAPIOutputConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	load := func(lines ...string) *AgentConfig {
+		dd, _ := ini.Load([]byte(strings.Join(append([]string{"[trace.api]"}, lines...), "\n")))
+		c, err := NewAgentConfig(&File{instance: dd, Path: "whatever"}, nil)
+		if c.APIOutput != "" {
+			assert.Nil(err, "no API key needed with a local output")
+		}
+		return c
+	}
+
+	c := load(
+		"output = file:///var/lib/trace-agent",
+		"output_gzip = true",
+		"output_max_size_mb = 5",
+		"output_max_files = 3",
+	)
+	assert.Equal("file:///var/lib/trace-agent", c.APIOutput)
+	assert.True(c.APIOutputGzip)
+	assert.Equal(int64(5*1024*1024), c.APIOutputMaxSize)
+	assert.Equal(3, c.APIOutputMaxFiles)
+
+	assert.Equal("stdout", load("output = stdout").APIOutput)
+
+	c = load("output = /var/lib/trace-agent", "output_max_size_mb = -1")
+	assert.Equal("", c.APIOutput)
+	assert.Equal(int64(100*1024*1024), c.APIOutputMaxSize)
+	assert.Len(c.errs, 2)
+}
+
+func TestRelayConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[trace.api]",
+		"relay = http://trace-relay:8126/",
+		"[trace.receiver]",
+		"accept_agent_payloads = true",
+	}, "\n")))
+	c, err := NewAgentConfig(&File{instance: dd, Path: "whatever"}, nil)
+	assert.Nil(err, "no API key needed with a relay")
+	assert.Equal("http://trace-relay:8126", c.APIRelay)
+	assert.True(c.AcceptAgentPayloads)
+
+	dd, _ = ini.Load([]byte("[trace.api]\nrelay = trace-relay:8126"))
+	c, err = NewAgentConfig(&File{instance: dd, Path: "whatever"}, nil)
+	assert.NotNil(err)
+	assert.Equal("", c.APIRelay)
+	assert.False(c.AcceptAgentPayloads)
+	assert.Len(c.errs, 1)
+}
+
+func TestPayloadVersionConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	load := func(lines ...string) *AgentConfig {
+		dd, _ := ini.Load([]byte(strings.Join(append([]string{"[trace.api]", "api_key = apikey_12"}, lines...), "\n")))
+		c, err := NewAgentConfig(&File{instance: dd, Path: "whatever"}, nil)
+		assert.Nil(err)
+		return c
+	}
+
+	c := load()
+	assert.Equal(model.AgentPayloadV01, c.APIPayloadVersion)
+	assert.Equal(model.GzipCompression, c.APIPayloadCompression)
+
+	c = load("payload_version = v0.2", "payload_compression = ZSTD")
+	assert.Equal(model.AgentPayloadV02, c.APIPayloadVersion)
+	if model.CompressionSupported(model.ZstdCompression) {
+		assert.Equal(model.ZstdCompression, c.APIPayloadCompression)
+		assert.Len(c.errs, 0)
+	} else {
+		assert.Equal(model.GzipCompression, c.APIPayloadCompression)
+		assert.Len(c.errs, 1)
+	}
+
+	c = load("payload_version = v1", "payload_compression = lz4")
+	assert.Equal(model.AgentPayloadV01, c.APIPayloadVersion)
+	assert.Equal(model.GzipCompression, c.APIPayloadCompression)
+	assert.Len(c.errs, 2)
+}
+
+func TestCgroupLimitsConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewDefaultAgentConfig()
+	c.ApplyCgroupLimits(0.25, 2.5e8)
+	assert.Equal(0.2, c.MaxCPU)
+	assert.Equal(2e8, c.MaxMemory)
+
+	// the limits are only used if below the configured values
+	c = NewDefaultAgentConfig()
+	c.ApplyCgroupLimits(4, 8e9)
+	assert.Equal(0.5, c.MaxCPU)
+	assert.Equal(5e8, c.MaxMemory)
+
+	// no limits
+	c = NewDefaultAgentConfig()
+	c.ApplyCgroupLimits(0, 0)
+	assert.Equal(0.5, c.MaxCPU)
+	assert.Equal(5e8, c.MaxMemory)
+}
+
 func TestConfigNewIfExists(t *testing.T) {
 	// The file does not exist: no error returned
 	conf, err := NewIfExists("/does-not-exist")
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"sort"
+)
+
+// ComputeCriticalPath attributes the duration of the root of the trace to
+// the spans of its critical path, i.e. the chain of spans the end-to-end
+// latency waited on, and returns the time spent on that path by service.
+//
+// The path is walked backwards from the end of the root: the child of a span
+// finishing last is on the critical path, up to its end, then the child
+// finishing last before that child started, and so on. The time not covered
+// by any child on the path is attributed to the span itself. Children running
+// concurrently with one on the path are off the path, and the sum of the
+// values is the duration of the root.
+func ComputeCriticalPath(trace Trace) []SublayerValue {
+	root := trace.GetRoot()
+	if root == nil {
+		return nil
+	}
+
+	durations := make(map[string]int64)
+	walkCriticalPath(root, root.End(), trace.ChildrenMap(), map[uint64]bool{}, durations)
+
+	values := make([]SublayerValue, 0, len(durations))
+	for service, duration := range durations {
+		values = append(values, SublayerValue{
+			Metric: "_critical_path.duration.by_service",
+			Tag:    Tag{"critical_path_service", service},
+			Value:  float64(duration),
+		})
+	}
+	return values
+}
+
+// walkCriticalPath adds the critical path time of s, and of its children,
+// until end to durations.
+func walkCriticalPath(s *Span, end int64, childrenMap map[uint64]Spans, visited map[uint64]bool, durations map[string]int64) {
+	visited[s.SpanID] = true
+
+	children := append(Spans(nil), childrenMap[s.SpanID]...)
+	sort.Slice(children, func(i, j int) bool { return children[i].End() > children[j].End() })
+
+	cursor := end
+	for _, child := range children {
+		if visited[child.SpanID] || child.Start >= cursor || child.End() <= s.Start {
+			continue
+		}
+		childEnd := child.End()
+		if childEnd > cursor {
+			childEnd = cursor
+		}
+		durations[s.Service] += cursor - childEnd
+		walkCriticalPath(child, childEnd, childrenMap, visited, durations)
+
+		cursor = child.Start
+		if cursor < s.Start {
+			cursor = s.Start
+		}
+	}
+	if cursor > s.Start {
+		durations[s.Service] += cursor - s.Start
+	}
+}
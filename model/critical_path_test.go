@@ -0,0 +1,79 @@
+package model
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func criticalPathValue(service string, value float64) SublayerValue {
+	return SublayerValue{
+		Metric: "_critical_path.duration.by_service",
+		Tag:    Tag{"critical_path_service", service},
+		Value:  value,
+	}
+}
+
+func TestComputeCriticalPath(t *testing.T) {
+	assert := assert.New(t)
+
+	span := func(id, parentID uint64, service string, start, duration int64) Span {
+		return Span{TraceID: 1, SpanID: id, ParentID: parentID, Service: service, Start: start, Duration: duration}
+	}
+
+	// 0  10  20  30  40  50  60  70  80  90 100
+	// |===|===|===|===|===|===|===|===|===|===|
+	// <-1------------------------------------->
+	//     <-2------------->
+	//         <-3----->
+	//             <-4--------------->
+	//                                 <-5->
+	tests := []struct {
+		name   string
+		trace  Trace
+		values []SublayerValue
+	}{
+		{
+			"single span",
+			Trace{span(1, 0, "web", 0, 100)},
+			[]SublayerValue{criticalPathValue("web", 100)},
+		},
+		{
+			"sequential and concurrent children",
+			Trace{
+				span(1, 0, "web", 0, 100),
+				span(2, 1, "db", 10, 40),
+				span(3, 2, "cache", 20, 20),
+				span(4, 1, "rpc", 30, 40),
+				span(5, 1, "db", 80, 10),
+			},
+			// web: 0-10, 70-80, 90-100, rpc: 30-70, db: 10-20, 80-90, cache: 20-30
+			[]SublayerValue{
+				criticalPathValue("cache", 10),
+				criticalPathValue("db", 20),
+				criticalPathValue("rpc", 40),
+				criticalPathValue("web", 30),
+			},
+		},
+		{
+			"child ending after its parent",
+			Trace{
+				span(1, 0, "web", 0, 100),
+				span(2, 1, "worker", 50, 100),
+			},
+			[]SublayerValue{
+				criticalPathValue("web", 50),
+				criticalPathValue("worker", 50),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		values := ComputeCriticalPath(test.trace)
+		sort.Sort(sublayerValues(values))
+		assert.Equal(test.values, values, test.name)
+	}
+
+	assert.Nil(ComputeCriticalPath(Trace{}))
+}
@@ -6,17 +6,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"sync"
+
+	"github.com/tinylib/msgp/msgp"
 )
 
+//go:generate msgp -marshal=false -tests=false
+//msgp:ignore AgentPayloadVersion AgentPayloadCompression
+
 // AgentPayload is the main payload to carry data that has been
 // pre-processed to the Datadog mothership
 type AgentPayload struct {
-	HostName string        `json:"hostname"` // the host name that will be resolved by the API
-	Env      string        `json:"env"`      // the default environment this agent uses
-	Traces   []Trace       `json:"traces"`   // the traces we sampled
-	Stats    []StatsBucket `json:"stats"`    // the statistics we pre-computed
+	HostName string        `json:"hostname" msg:"hostname"` // the host name that will be resolved by the API
+	Env      string        `json:"env" msg:"env"`           // the default environment this agent uses
+	Traces   []Trace       `json:"traces" msg:"traces"`     // the traces we sampled
+	Stats    []StatsBucket `json:"stats" msg:"stats"`       // the statistics we pre-computed
 
 	// private
 	mu     sync.RWMutex
@@ -55,14 +61,54 @@ type AgentPayloadVersion string
 const (
 	// AgentPayloadV01 is a simple json'd/gzip'd dump of the payload
 	AgentPayloadV01 AgentPayloadVersion = "v0.1"
+	// AgentPayloadV02 is a msgpack'd dump of the payload, compressed
+	// according to GlobalAgentPayloadCompression
+	AgentPayloadV02 AgentPayloadVersion = "v0.2"
+)
+
+// AgentPayloadCompression is the compression of the payloads, for the
+// versions which support several ones. Its value is the Content-Encoding.
+type AgentPayloadCompression string
+
+const (
+	// GzipCompression compresses the payloads with gzip
+	GzipCompression AgentPayloadCompression = "gzip"
+	// ZstdCompression compresses the payloads with zstd, which is faster
+	// and compresses better than gzip
+	ZstdCompression AgentPayloadCompression = "zstd"
 )
 
 var (
 	// GlobalAgentPayloadVersion is a default that will be used
 	// in all the AgentPayload method. Override for special cases.
 	GlobalAgentPayloadVersion = AgentPayloadV01
+	// GlobalAgentPayloadCompression is the compression used along with
+	// GlobalAgentPayloadVersion, if it supports several ones.
+	GlobalAgentPayloadCompression = GzipCompression
+
+	// maxDecodedAgentPayloadSize is the maximum size of a decoded payload
+	// once decompressed, so that a small compressed request can't expand
+	// to an arbitrary amount of memory.
+	maxDecodedAgentPayloadSize int64 = 100 * 1024 * 1024
+
+	// errZstdNotBuilt is returned when zstd is used by an agent built
+	// without it, see payload_zstd.go.
+	errZstdNotBuilt = errors.New("zstd compression requires building the agent with the zstd tag")
 )
 
+// CompressionSupported tells if this build of the agent can compress and
+// decompress payloads with c.
+func CompressionSupported(c AgentPayloadCompression) bool {
+	switch c {
+	case GzipCompression:
+		return true
+	case ZstdCompression:
+		return zstdSupported
+	default:
+		return false
+	}
+}
+
 // EncodeAgentPayload will return a slice of bytes representing the
 // payload (according to GlobalAgentPayloadVersion)
 func EncodeAgentPayload(p *AgentPayload) ([]byte, error) {
@@ -77,6 +123,16 @@ func EncodeAgentPayload(p *AgentPayload) ([]byte, error) {
 		}
 		err = json.NewEncoder(gz).Encode(p)
 		gz.Close()
+	case AgentPayloadV02:
+		var w io.WriteCloser
+		w, err = newCompressWriter(&b, GlobalAgentPayloadCompression)
+		if err != nil {
+			return nil, err
+		}
+		err = msgp.Encode(w, p)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
 	default:
 		err = errors.New("unknown payload version")
 	}
@@ -84,6 +140,75 @@ func EncodeAgentPayload(p *AgentPayload) ([]byte, error) {
 	return b.Bytes(), err
 }
 
+// newCompressWriter returns a writer compressing to w, favoring speed.
+func newCompressWriter(w io.Writer, c AgentPayloadCompression) (io.WriteCloser, error) {
+	switch c {
+	case GzipCompression:
+		return gzip.NewWriterLevel(w, gzip.BestSpeed)
+	case ZstdCompression:
+		return newZstdWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown payload compression %q", c)
+	}
+}
+
+// newDecompressReader returns a reader decompressing r, compressed as
+// told by its Content-Encoding.
+func newDecompressReader(r io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	switch AgentPayloadCompression(contentEncoding) {
+	case GzipCompression:
+		return gzip.NewReader(r)
+	case ZstdCompression:
+		return newZstdReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", contentEncoding)
+	}
+}
+
+// DecodeAgentPayload decodes a payload encoded by EncodeAgentPayload with
+// the given version, such as the ones relayed by other agents, given the
+// headers set along with it by SetAgentPayloadHeaders.
+func DecodeAgentPayload(v AgentPayloadVersion, h http.Header, r io.Reader) (*AgentPayload, error) {
+	var p AgentPayload
+	var dr io.ReadCloser
+	var err error
+
+	switch v {
+	case AgentPayloadV01:
+		dr, err = gzip.NewReader(r)
+	case AgentPayloadV02:
+		dr, err = newDecompressReader(r, h.Get("Content-Encoding"))
+	default:
+		return nil, errors.New("unknown payload version")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	lr := NewLimitedReader(dr, maxDecodedAgentPayloadSize)
+	if v == AgentPayloadV01 {
+		err = json.NewDecoder(lr).Decode(&p)
+	} else {
+		err = msgp.Decode(lr, &p)
+	}
+	if err != nil {
+		if lr.limit <= 0 {
+			return nil, ErrLimitedReaderLimitReached
+		}
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// AgentPayloadRelayPath returns the path to which the payload should be
+// sent to be understood by another trace-agent relaying it, given the
+// configured payload version.
+func AgentPayloadRelayPath() string {
+	return fmt.Sprintf("/%s/agent_payload", GlobalAgentPayloadVersion)
+}
+
 // AgentPayloadAPIPath returns the path (after the first slash) to which
 // the payload should be sent to be understood by the API given the
 // configured payload version.
@@ -99,6 +224,13 @@ func SetAgentPayloadHeaders(h http.Header, extras map[string]string) {
 		h.Set("Content-Type", "application/json")
 		h.Set("Content-Encoding", "gzip")
 
+		for key, value := range extras {
+			h.Set(key, value)
+		}
+	case AgentPayloadV02:
+		h.Set("Content-Type", "application/msgpack")
+		h.Set("Content-Encoding", string(GlobalAgentPayloadCompression))
+
 		for key, value := range extras {
 			h.Set(key, value)
 		}
@@ -0,0 +1,17 @@
+// +build !zstd
+
+package model
+
+import "io"
+
+// zstdSupported tells if the agent is built with zstd compression, see
+// payload_zstd.go.
+const zstdSupported = false
+
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	return nil, errZstdNotBuilt
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	return nil, errZstdNotBuilt
+}
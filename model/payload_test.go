@@ -0,0 +1,194 @@
+package model
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testAgentPayload() *AgentPayload {
+	srb := NewStatsRawBucket(0, 1e9)
+	for _, s := range testSpans() {
+		srb.HandleSpan(s, defaultEnv, nil, nil)
+	}
+	sketches := NewStatsRawBucket(1e9, 1e9)
+	sketches.UseSketches(0.01)
+	for _, s := range testSpans() {
+		sketches.HandleSpan(s, defaultEnv, nil, nil)
+	}
+	return &AgentPayload{
+		HostName: "relayed.host",
+		Env:      "prod",
+		Traces:   []Trace{testTrace()},
+		Stats:    []StatsBucket{srb.Export(), sketches.Export()},
+	}
+}
+
+func TestDecodeAgentPayload(t *testing.T) {
+	defer func(v AgentPayloadVersion, c AgentPayloadCompression) {
+		GlobalAgentPayloadVersion = v
+		GlobalAgentPayloadCompression = c
+	}(GlobalAgentPayloadVersion, GlobalAgentPayloadCompression)
+
+	p := testAgentPayload()
+	for _, tc := range []struct {
+		version     AgentPayloadVersion
+		compression AgentPayloadCompression
+		contentType string
+	}{
+		{AgentPayloadV01, GzipCompression, "application/json"},
+		{AgentPayloadV02, GzipCompression, "application/msgpack"},
+		{AgentPayloadV02, ZstdCompression, "application/msgpack"},
+	} {
+		if !CompressionSupported(tc.compression) {
+			continue
+		}
+		assert := assert.New(t)
+		GlobalAgentPayloadVersion = tc.version
+		GlobalAgentPayloadCompression = tc.compression
+
+		data, err := EncodeAgentPayload(p)
+		assert.Nil(err)
+		h := http.Header{}
+		SetAgentPayloadHeaders(h, map[string]string{"X-Datadog-Reported-Languages": "go"})
+		assert.Equal(tc.contentType, h.Get("Content-Type"))
+		assert.Equal(string(tc.compression), h.Get("Content-Encoding"))
+		assert.Equal("go", h.Get("X-Datadog-Reported-Languages"))
+
+		decoded, err := DecodeAgentPayload(tc.version, h, bytes.NewReader(data))
+		assert.Nil(err, "%s %s", tc.version, tc.compression)
+		assert.Equal("relayed.host", decoded.HostName)
+		assert.Equal("prod", decoded.Env)
+		assert.Len(decoded.Traces, 1)
+		assert.Len(decoded.Traces[0], len(p.Traces[0]))
+		for i, s := range decoded.Traces[0] {
+			// leave out what is cached on the spans
+			expected := p.Traces[0][i]
+			expected.weight, expected.topLevel = 0, false
+			assert.Equal(expected, s)
+		}
+		assert.Len(decoded.Stats, 2)
+		for i, sb := range p.Stats {
+			assert.Equal(sb.Start, decoded.Stats[i].Start)
+			assert.Equal(sb.Counts, decoded.Stats[i].Counts)
+			assert.Len(decoded.Stats[i].Distributions, len(sb.Distributions))
+			for k, d := range sb.Distributions {
+				assert.Equal(d.Size(), decoded.Stats[i].Distributions[k].Size(), k)
+				if d.Sketch != nil {
+					assert.Equal(d.Sketch.Quantile(0.5), decoded.Stats[i].Distributions[k].Sketch.Quantile(0.5), k)
+				} else {
+					assert.Equal(d.Summary, decoded.Stats[i].Distributions[k].Summary, k)
+				}
+			}
+		}
+
+		_, err = DecodeAgentPayload(tc.version, h, bytes.NewReader([]byte("not compressed")))
+		assert.NotNil(err)
+	}
+
+	// v0.2 needs to know the compression
+	GlobalAgentPayloadVersion = AgentPayloadV02
+	data, err := EncodeAgentPayload(p)
+	assert.Nil(t, err)
+	_, err = DecodeAgentPayload(AgentPayloadV02, http.Header{}, bytes.NewReader(data))
+	assert.EqualError(t, err, `unsupported content encoding ""`)
+
+	_, err = DecodeAgentPayload("v9.9", http.Header{}, bytes.NewReader(data))
+	assert.NotNil(t, err)
+
+	if !CompressionSupported(ZstdCompression) {
+		// without the zstd tag, zstd payloads can't be encoded nor decoded
+		GlobalAgentPayloadCompression = ZstdCompression
+		_, err = EncodeAgentPayload(p)
+		assert.Equal(t, errZstdNotBuilt, err)
+		_, err = DecodeAgentPayload(AgentPayloadV02, http.Header{"Content-Encoding": {"zstd"}}, bytes.NewReader(data))
+		assert.Equal(t, errZstdNotBuilt, err)
+	}
+
+	GlobalAgentPayloadCompression = "lz4"
+	_, err = EncodeAgentPayload(p)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeAgentPayloadTooLarge(t *testing.T) {
+	defer func(v AgentPayloadVersion, c AgentPayloadCompression, max int64) {
+		GlobalAgentPayloadVersion = v
+		GlobalAgentPayloadCompression = c
+		maxDecodedAgentPayloadSize = max
+	}(GlobalAgentPayloadVersion, GlobalAgentPayloadCompression, maxDecodedAgentPayloadSize)
+
+	// a payload compressing well, far bigger once decompressed
+	p := &AgentPayload{HostName: strings.Repeat("a", 64*1024)}
+	for _, tc := range []struct {
+		version     AgentPayloadVersion
+		compression AgentPayloadCompression
+	}{
+		{AgentPayloadV01, GzipCompression},
+		{AgentPayloadV02, GzipCompression},
+		{AgentPayloadV02, ZstdCompression},
+	} {
+		if !CompressionSupported(tc.compression) {
+			continue
+		}
+		GlobalAgentPayloadVersion = tc.version
+		GlobalAgentPayloadCompression = tc.compression
+		maxDecodedAgentPayloadSize = 100 * 1024 * 1024
+
+		data, err := EncodeAgentPayload(p)
+		assert.Nil(t, err)
+		assert.True(t, len(data) < 16*1024)
+		h := http.Header{}
+		SetAgentPayloadHeaders(h, nil)
+
+		_, err = DecodeAgentPayload(tc.version, h, bytes.NewReader(data))
+		assert.Nil(t, err, "%s %s", tc.version, tc.compression)
+
+		maxDecodedAgentPayloadSize = 16 * 1024
+		_, err = DecodeAgentPayload(tc.version, h, bytes.NewReader(data))
+		assert.Equal(t, ErrLimitedReaderLimitReached, err, "%s %s", tc.version, tc.compression)
+	}
+}
+
+func TestAgentPayloadPaths(t *testing.T) {
+	defer func(v AgentPayloadVersion) { GlobalAgentPayloadVersion = v }(GlobalAgentPayloadVersion)
+
+	assert.Equal(t, "/v0.1/agent_payload", AgentPayloadRelayPath())
+	assert.Equal(t, "/api/v0.1/collector", AgentPayloadAPIPath())
+
+	GlobalAgentPayloadVersion = AgentPayloadV02
+	assert.Equal(t, "/v0.2/agent_payload", AgentPayloadRelayPath())
+	assert.Equal(t, "/api/v0.2/collector", AgentPayloadAPIPath())
+
+	// services are still sent as JSON
+	h := http.Header{}
+	SetServicesPayloadHeaders(h)
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+}
+
+func BenchmarkEncodeAgentPayloadVersions(b *testing.B) {
+	defer func(v AgentPayloadVersion, c AgentPayloadCompression) {
+		GlobalAgentPayloadVersion = v
+		GlobalAgentPayloadCompression = c
+	}(GlobalAgentPayloadVersion, GlobalAgentPayloadCompression)
+
+	p := testAgentPayload()
+	for _, v := range []AgentPayloadVersion{AgentPayloadV01, AgentPayloadV02} {
+		for _, c := range []AgentPayloadCompression{GzipCompression, ZstdCompression} {
+			if (v == AgentPayloadV01 && c == ZstdCompression) || !CompressionSupported(c) {
+				continue
+			}
+			GlobalAgentPayloadVersion = v
+			GlobalAgentPayloadCompression = c
+			b.Run(string(v)+"-"+string(c), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					data, _ := EncodeAgentPayload(p)
+					b.SetBytes(int64(len(data)))
+				}
+			})
+		}
+	}
+}
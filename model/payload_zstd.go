@@ -0,0 +1,26 @@
+// +build zstd
+
+package model
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdSupported tells if the agent is built with zstd compression. Its
+// package requires a newer Go than the rest of the agent, so it is only
+// built with the zstd tag.
+const zstdSupported = true
+
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
@@ -0,0 +1,234 @@
+package model
+
+import (
+	"fmt"
+)
+
+// RepairStrategy tells how structural anomalies found in a trace are handled.
+type RepairStrategy string
+
+const (
+	// RepairNone only detects anomalies and leaves the trace untouched.
+	RepairNone RepairStrategy = "none"
+	// RepairDrop removes the anomalous spans, along with their children,
+	// only keeping what is attached to the main root.
+	RepairDrop RepairStrategy = "drop"
+	// RepairReparent attaches orphans, extra roots and cycles to the main root.
+	RepairReparent RepairStrategy = "reparent"
+	// RepairSplit turns orphans, extra roots, cycles and spans from other
+	// traces into traces of their own.
+	RepairSplit RepairStrategy = "split"
+)
+
+// ParseRepairStrategy returns the repair strategy matching s, or an error
+// if s is not a known strategy.
+func ParseRepairStrategy(s string) (RepairStrategy, error) {
+	switch rs := RepairStrategy(s); rs {
+	case RepairNone, RepairDrop, RepairReparent, RepairSplit:
+		return rs, nil
+	}
+	return RepairNone, fmt.Errorf("unknown repair strategy %q", s)
+}
+
+// TraceAnomalies counts the structural anomalies found in a trace.
+type TraceAnomalies struct {
+	// MultipleRoots is the number of spans without parent, besides the main root.
+	MultipleRoots int
+	// Orphans is the number of spans whose parent is not part of the trace,
+	// besides the main root.
+	Orphans int
+	// Cycles is the number of spans which are their own ancestor.
+	Cycles int
+	// DuplicateSpanIDs is the number of spans sharing their ID with a previous span.
+	DuplicateSpanIDs int
+	// MixedTraceIDs is the number of spans with a trace ID different from the trace one.
+	MixedTraceIDs int
+}
+
+// IsEmpty returns true if no anomaly was found.
+func (a TraceAnomalies) IsEmpty() bool {
+	return a == TraceAnomalies{}
+}
+
+// isRootSpan returns true if the span has no parent. As in Normalize, a span
+// with ParentID == TraceID == SpanID is a Zipkin-style root.
+func isRootSpan(s *Span) bool {
+	return s.ParentID == 0 || (s.ParentID == s.TraceID && s.ParentID == s.SpanID)
+}
+
+// RepairTrace checks the structure of a trace, reporting multiple roots,
+// orphans, parent cycles, duplicate span IDs and mixed trace IDs, then
+// repairs it according to the given strategy. It returns the resulting
+// traces, which can be empty if everything was dropped, or more than one
+// if the trace was split.
+func RepairTrace(t Trace, strategy RepairStrategy) (Traces, TraceAnomalies) {
+	var anomalies TraceAnomalies
+	if len(t) == 0 {
+		return Traces{t}, anomalies
+	}
+
+	traceID := t[0].TraceID
+
+	// dedupe span IDs and set aside spans from other traces
+	spans := make(Spans, 0, len(t))
+	byID := make(map[uint64]*Span, len(t))
+	others := make(map[uint64]Trace)
+	for i := range t {
+		s := &t[i]
+		if s.TraceID != traceID {
+			anomalies.MixedTraceIDs++
+			others[s.TraceID] = append(others[s.TraceID], *s)
+			continue
+		}
+		if _, ok := byID[s.SpanID]; ok {
+			anomalies.DuplicateSpanIDs++
+			continue
+		}
+		byID[s.SpanID] = s
+		spans = append(spans, s)
+	}
+
+	parentOf := func(s *Span) *Span {
+		if isRootSpan(s) {
+			return nil
+		}
+		return byID[s.ParentID]
+	}
+
+	// find the spans which are part of a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[uint64]int, len(spans))
+	inCycle := make(map[uint64]bool)
+	for _, s := range spans {
+		var path Spans
+		cur := s
+		for cur != nil && state[cur.SpanID] == unvisited {
+			state[cur.SpanID] = visiting
+			path = append(path, cur)
+			cur = parentOf(cur)
+		}
+		if cur != nil && state[cur.SpanID] == visiting {
+			// we came back to a span of the current path
+			for i := len(path) - 1; i >= 0; i-- {
+				inCycle[path[i].SpanID] = true
+				if path[i] == cur {
+					break
+				}
+			}
+		}
+		for _, p := range path {
+			state[p.SpanID] = visited
+		}
+	}
+	anomalies.Cycles = len(inCycle)
+
+	// spans to which no parent can be found: roots, orphans and one span per cycle
+	var roots, orphans, breakers Spans
+	brokenCycle := make(map[uint64]bool)
+	for _, s := range spans {
+		switch {
+		case isRootSpan(s):
+			roots = append(roots, s)
+		case inCycle[s.SpanID]:
+			if brokenCycle[s.SpanID] {
+				continue
+			}
+			// break the cycle on its earliest span
+			breaker := s
+			for cur := parentOf(s); cur != s; cur = parentOf(cur) {
+				brokenCycle[cur.SpanID] = true
+				if cur.Start < breaker.Start {
+					breaker = cur
+				}
+			}
+			brokenCycle[s.SpanID] = true
+			breakers = append(breakers, breaker)
+		case byID[s.ParentID] == nil:
+			orphans = append(orphans, s)
+		}
+	}
+
+	var mainRoot *Span
+	for _, candidates := range []Spans{roots, orphans, breakers} {
+		for _, s := range candidates {
+			if mainRoot == nil || s.Start < mainRoot.Start ||
+				(s.Start == mainRoot.Start && s.Duration > mainRoot.Duration) {
+				mainRoot = s
+			}
+		}
+		if mainRoot != nil {
+			break
+		}
+	}
+
+	if len(roots) > 1 {
+		anomalies.MultipleRoots = len(roots) - 1
+	}
+	anomalies.Orphans = len(orphans)
+	if len(roots) == 0 && len(orphans) > 0 {
+		// the main root is an orphan, which is the normal case for the local
+		// part of a distributed trace
+		anomalies.Orphans--
+	}
+
+	if strategy == RepairNone || strategy == "" || anomalies.IsEmpty() {
+		return Traces{t}, anomalies
+	}
+
+	// tops are the spans which become the top of a tree once repaired
+	var tops Spans
+	for _, candidates := range []Spans{roots, orphans, breakers} {
+		for _, s := range candidates {
+			if s == mainRoot {
+				continue
+			}
+			switch strategy {
+			case RepairReparent:
+				s.ParentID = mainRoot.SpanID
+			case RepairSplit:
+				if inCycle[s.SpanID] {
+					s.ParentID = 0
+				}
+				tops = append(tops, s)
+			}
+		}
+	}
+	if inCycle[mainRoot.SpanID] {
+		mainRoot.ParentID = 0
+	}
+
+	childrenOf := make(map[uint64]Spans, len(spans))
+	for _, s := range spans {
+		if s == mainRoot || isRootSpan(s) {
+			continue
+		}
+		childrenOf[s.ParentID] = append(childrenOf[s.ParentID], s)
+	}
+	subtree := func(top *Span) Trace {
+		tree := Trace{*top}
+		for i := 0; i < len(tree); i++ {
+			for _, child := range childrenOf[tree[i].SpanID] {
+				if child != top {
+					tree = append(tree, *child)
+				}
+			}
+		}
+		return tree
+	}
+
+	traces := Traces{subtree(mainRoot)}
+	if strategy == RepairSplit {
+		for _, top := range tops {
+			traces = append(traces, subtree(top))
+		}
+		for _, other := range others {
+			traces = append(traces, other)
+		}
+	}
+
+	return traces, anomalies
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func repairTestSpan(traceID, spanID, parentID uint64, start int64) Span {
+	return Span{TraceID: traceID, SpanID: spanID, ParentID: parentID, Start: start, Duration: 10}
+}
+
+func spanIDs(t Trace) []uint64 {
+	ids := make([]uint64, 0, len(t))
+	for _, s := range t {
+		ids = append(ids, s.SpanID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestRepairTraceValid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, trace := range []Trace{
+		{repairTestSpan(1, 1, 0, 0), repairTestSpan(1, 2, 1, 1), repairTestSpan(1, 3, 2, 2)},
+		// local part of a distributed trace
+		{repairTestSpan(1, 2, 42, 1), repairTestSpan(1, 3, 2, 2)},
+		// zipkin-style root
+		{repairTestSpan(1, 1, 1, 0), repairTestSpan(1, 2, 1, 1)},
+	} {
+		traces, anomalies := RepairTrace(trace, RepairSplit)
+		assert.True(anomalies.IsEmpty(), "%v", anomalies)
+		assert.Equal(Traces{trace}, traces)
+	}
+}
+
+func TestRepairTraceAnomalies(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		repairTestSpan(1, 1, 0, 0),
+		repairTestSpan(1, 2, 1, 1),
+		repairTestSpan(1, 2, 1, 1), // duplicate
+		repairTestSpan(2, 3, 1, 1), // other trace
+		repairTestSpan(1, 4, 0, 5), // second root
+		repairTestSpan(1, 5, 4, 6),
+		repairTestSpan(1, 6, 42, 7), // orphan
+		repairTestSpan(1, 7, 8, 8),  // cycle
+		repairTestSpan(1, 8, 7, 9),  // cycle
+		repairTestSpan(1, 9, 8, 10), // child of the cycle
+	}
+
+	traces, anomalies := RepairTrace(trace, RepairNone)
+	assert.Equal(TraceAnomalies{
+		MultipleRoots:    1,
+		Orphans:          1,
+		Cycles:           2,
+		DuplicateSpanIDs: 1,
+		MixedTraceIDs:    1,
+	}, anomalies)
+	assert.Equal(Traces{trace}, traces)
+}
+
+func TestRepairTraceDrop(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		repairTestSpan(1, 1, 0, 0),
+		repairTestSpan(1, 2, 1, 1),
+		repairTestSpan(1, 2, 1, 1),
+		repairTestSpan(2, 3, 1, 1),
+		repairTestSpan(1, 4, 0, 5),
+		repairTestSpan(1, 5, 4, 6),
+		repairTestSpan(1, 6, 42, 7),
+		repairTestSpan(1, 7, 8, 8),
+		repairTestSpan(1, 8, 7, 9),
+	}
+
+	traces, _ := RepairTrace(trace, RepairDrop)
+	if assert.Len(traces, 1) {
+		assert.Equal([]uint64{1, 2}, spanIDs(traces[0]))
+	}
+}
+
+func TestRepairTraceReparent(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		repairTestSpan(1, 1, 0, 0),
+		repairTestSpan(1, 2, 1, 1),
+		repairTestSpan(1, 4, 0, 5),
+		repairTestSpan(1, 5, 4, 6),
+		repairTestSpan(1, 6, 42, 7),
+		repairTestSpan(1, 7, 8, 8),
+		repairTestSpan(1, 8, 7, 9),
+	}
+
+	traces, _ := RepairTrace(trace, RepairReparent)
+	if assert.Len(traces, 1) {
+		repaired := traces[0]
+		assert.Equal([]uint64{1, 2, 4, 5, 6, 7, 8}, spanIDs(repaired))
+		assert.Equal(uint64(1), repaired.GetRoot().SpanID)
+
+		parents := make(map[uint64]uint64)
+		for _, s := range repaired {
+			parents[s.SpanID] = s.ParentID
+		}
+		assert.Equal(uint64(1), parents[4])
+		assert.Equal(uint64(1), parents[6])
+		// the cycle is broken on its earliest span
+		assert.Equal(uint64(1), parents[7])
+		assert.Equal(uint64(7), parents[8])
+	}
+}
+
+func TestRepairTraceSplit(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		repairTestSpan(1, 1, 0, 0),
+		repairTestSpan(1, 2, 1, 1),
+		repairTestSpan(2, 3, 1, 1),
+		repairTestSpan(1, 4, 0, 5),
+		repairTestSpan(1, 5, 4, 6),
+		repairTestSpan(1, 7, 8, 8),
+		repairTestSpan(1, 8, 7, 9),
+	}
+
+	traces, _ := RepairTrace(trace, RepairSplit)
+	var got [][]uint64
+	for _, t := range traces {
+		got = append(got, spanIDs(t))
+	}
+	assert.Equal([][]uint64{{1, 2}, {4, 5}, {7, 8}, {3}}, got)
+	assert.Equal(uint64(7), traces[2].GetRoot().SpanID)
+}
+
+func TestRepairTraceOnlyCycle(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{repairTestSpan(1, 1, 2, 1), repairTestSpan(1, 2, 1, 0)}
+
+	traces, anomalies := RepairTrace(trace, RepairDrop)
+	assert.Equal(2, anomalies.Cycles)
+	if assert.Len(traces, 1) {
+		assert.Equal([]uint64{1, 2}, spanIDs(traces[0]))
+		assert.Equal(uint64(2), traces[0].GetRoot().SpanID)
+	}
+}
+
+func TestParseRepairStrategy(t *testing.T) {
+	assert := assert.New(t)
+
+	rs, err := ParseRepairStrategy("split")
+	assert.NoError(err)
+	assert.Equal(RepairSplit, rs)
+
+	_, err = ParseRepairStrategy("fix-it")
+	assert.Error(err)
+}
@@ -45,7 +45,7 @@ func ServicesPayloadAPIPath() string {
 // header keys for the API to be able to decode the services metadata.
 func SetServicesPayloadHeaders(h http.Header) {
 	switch GlobalAgentPayloadVersion {
-	case AgentPayloadV01:
+	case AgentPayloadV01, AgentPayloadV02:
 		h.Set("Content-Type", "application/json")
 	default:
 	}
@@ -0,0 +1,91 @@
+package model
+
+// ClockSkewKey is the metric set on a span whose subtree was shifted to
+// correct the clock skew between its host and its parent's. Its value is
+// the applied offset, in nanoseconds.
+const ClockSkewKey = "_dd.clock_skew"
+
+// CorrectClockSkew shifts the subtrees of spans from a different service
+// than their parent so that they start within their parent span. Services
+// are on different hosts, possibly with skewed clocks, so a child starting
+// before its parent is assumed to be the effect of that skew. A child ending
+// after its parent is not, as asynchronous calls commonly outlive their
+// caller. shouldCorrect tells if the subtrees of a given service can be
+// shifted; a nil func allows all of them. It returns the number of shifted
+// subtrees.
+func (t Trace) CorrectClockSkew(shouldCorrect func(service string) bool) int {
+	if len(t) < 2 {
+		return 0
+	}
+
+	childrenMap := t.ChildrenMap()
+	byID := make(map[uint64]*Span, len(t))
+	for i := range t {
+		byID[t[i].SpanID] = &t[i]
+	}
+
+	// walk the trees top-down, so that children are compared with the
+	// already corrected position of their parent
+	var queue Spans
+	for i := range t {
+		if _, ok := byID[t[i].ParentID]; !ok || t[i].ParentID == 0 {
+			queue = append(queue, &t[i])
+		}
+	}
+	visited := make(map[uint64]bool, len(t))
+	corrected := 0
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		if visited[parent.SpanID] {
+			continue
+		}
+		visited[parent.SpanID] = true
+
+		for _, child := range childrenMap[parent.SpanID] {
+			queue = append(queue, child)
+			if child.Service == parent.Service {
+				continue
+			}
+			if shouldCorrect != nil && !shouldCorrect(child.Service) {
+				continue
+			}
+			offset := skewOffset(parent, child)
+			if offset == 0 {
+				continue
+			}
+			shiftSubtree(child, offset, childrenMap)
+			if child.Metrics == nil {
+				child.Metrics = make(map[string]float64, 1)
+			}
+			child.Metrics[ClockSkewKey] = float64(offset)
+			corrected++
+		}
+	}
+	return corrected
+}
+
+// skewOffset returns by how much child should be shifted to start within
+// parent.
+func skewOffset(parent, child *Span) int64 {
+	if child.Start < parent.Start {
+		return parent.Start - child.Start
+	}
+	return 0
+}
+
+// shiftSubtree shifts the start of top and all of its descendants.
+func shiftSubtree(top *Span, offset int64, childrenMap map[uint64]Spans) {
+	seen := map[uint64]bool{}
+	spans := Spans{top}
+	for len(spans) > 0 {
+		s := spans[len(spans)-1]
+		spans = spans[:len(spans)-1]
+		if seen[s.SpanID] {
+			continue
+		}
+		seen[s.SpanID] = true
+		s.Start += offset
+		spans = append(spans, childrenMap[s.SpanID]...)
+	}
+}
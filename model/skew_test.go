@@ -0,0 +1,90 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func skewTestSpan(spanID, parentID uint64, service string, start, duration int64) Span {
+	return Span{TraceID: 1, SpanID: spanID, ParentID: parentID, Service: service, Start: start, Duration: duration}
+}
+
+func TestCorrectClockSkewEarlyChild(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		skewTestSpan(1, 0, "web", 100, 100),
+		skewTestSpan(2, 1, "web", 110, 50),
+		// db host is 80ns late
+		skewTestSpan(3, 2, "db", 40, 20),
+		skewTestSpan(4, 3, "db", 45, 10),
+		skewTestSpan(5, 4, "cache", 50, 2),
+	}
+
+	assert.Equal(1, trace.CorrectClockSkew(nil))
+
+	assert.Equal(int64(110), trace[2].Start)
+	assert.Equal(int64(115), trace[3].Start)
+	assert.Equal(int64(120), trace[4].Start)
+	assert.Equal(float64(70), trace[2].Metrics[ClockSkewKey])
+	assert.Nil(trace[3].Metrics)
+	assert.Nil(trace[4].Metrics)
+}
+
+func TestCorrectClockSkewAsyncChild(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		skewTestSpan(1, 0, "web", 100, 100),
+		// an asynchronous call outliving its caller
+		skewTestSpan(2, 1, "db", 190, 30),
+		skewTestSpan(3, 1, "worker", 150, 300),
+	}
+
+	assert.Equal(0, trace.CorrectClockSkew(nil))
+	assert.Equal(int64(190), trace[1].Start)
+	assert.Equal(int64(150), trace[2].Start)
+	assert.Nil(trace[1].Metrics)
+	assert.Nil(trace[2].Metrics)
+}
+
+func TestCorrectClockSkewSameService(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		skewTestSpan(1, 0, "web", 100, 100),
+		// same host, so same clock: leave it alone
+		skewTestSpan(2, 1, "web", 90, 30),
+	}
+
+	assert.Equal(0, trace.CorrectClockSkew(nil))
+	assert.Equal(int64(90), trace[1].Start)
+}
+
+func TestCorrectClockSkewPerService(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		skewTestSpan(1, 0, "web", 100, 100),
+		skewTestSpan(2, 1, "db", 10, 30),
+		skewTestSpan(3, 1, "cache", 10, 30),
+	}
+
+	n := trace.CorrectClockSkew(func(service string) bool { return service == "cache" })
+	assert.Equal(1, n)
+	assert.Equal(int64(10), trace[1].Start)
+	assert.Equal(int64(100), trace[2].Start)
+}
+
+func TestCorrectClockSkewLongerChild(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		skewTestSpan(1, 0, "web", 100, 100),
+		skewTestSpan(2, 1, "worker", 50, 300),
+	}
+
+	assert.Equal(1, trace.CorrectClockSkew(nil))
+	assert.Equal(int64(100), trace[1].Start)
+}
@@ -33,8 +33,9 @@ type Span struct {
 	// Those are cached information, they are here not only for optimization,
 	// but because the func which fill their values read
 	// the Metrics map and causes map read/write concurrent accesses.
-	weight   float64 // caches the result of Weight() called on the root span
-	topLevel bool    // caches the result of TopLevel()
+	weight   float64            // caches the result of Weight() called on the root span
+	topLevel bool               // caches the result of TopLevel()
+	metrics  map[string]float64 // copies the Metrics values tracked in stats
 }
 
 // String formats a Span struct to be displayed as a string
@@ -6,6 +6,8 @@ import (
 	"github.com/DataDog/datadog-trace-agent/quantile"
 )
 
+//go:generate msgp -marshal=false
+
 // Hardcoded measures names for ease of reference
 const (
 	HITS     string = "hits"
@@ -17,32 +19,58 @@ var (
 	// DefaultCounts is an array of the measures we represent as Count by default
 	DefaultCounts = [...]string{HITS, ERRORS, DURATION}
 	// DefaultDistributions is an array of the measures we represent as Distribution by default
-	// Not really used right now as we don't have a way to easily add new distros
+	// Span metrics tracked with StatsRawBucket.TrackMetrics come as extra ones
 	DefaultDistributions = [...]string{DURATION}
 )
 
 // Count represents one specific "metric" we track for a given tagset
 type Count struct {
-	Key     string `json:"key"`
-	Name    string `json:"name"`    // the name of the trace/spans we count (was a member of TagSet)
-	Measure string `json:"measure"` // represents the entity we count, e.g. "hits", "errors", "time" (was Name)
-	TagSet  TagSet `json:"tagset"`  // set of tags for which we account this Distribution
+	Key     string `json:"key" msg:"key"`
+	Name    string `json:"name" msg:"name"`       // the name of the trace/spans we count (was a member of TagSet)
+	Measure string `json:"measure" msg:"measure"` // represents the entity we count, e.g. "hits", "errors", "time" (was Name)
+	TagSet  TagSet `json:"tagset" msg:"tagset"`   // set of tags for which we account this Distribution
 
-	TopLevel float64 `json:"top_level"` // number of top-level spans contributing to this count
+	TopLevel float64 `json:"top_level" msg:"top_level"` // number of top-level spans contributing to this count
 
-	Value float64 `json:"value"` // accumulated values
+	Value float64 `json:"value" msg:"value"` // accumulated values
 }
 
 // Distribution represents a true image of the spectrum of values, allowing arbitrary quantile queries
 type Distribution struct {
-	Key     string `json:"key"`
-	Name    string `json:"name"`    // the name of the trace/spans we count (was a member of TagSet)
-	Measure string `json:"measure"` // represents the entity we count, e.g. "hits", "errors", "time"
-	TagSet  TagSet `json:"tagset"`  // set of tags for which we account this Distribution
+	Key     string `json:"key" msg:"key"`
+	Name    string `json:"name" msg:"name"`       // the name of the trace/spans we count (was a member of TagSet)
+	Measure string `json:"measure" msg:"measure"` // represents the entity we count, e.g. "hits", "errors", "time"
+	TagSet  TagSet `json:"tagset" msg:"tagset"`   // set of tags for which we account this Distribution
+
+	TopLevel float64 `json:"top_level" msg:"top_level"` // number of top-level spans contributing to this count
+
+	Summary *quantile.SliceSummary `json:"summary" msg:"summary"`         // actual representation of data, as a GK summary
+	Sketch  *quantile.LogSketch    `json:"sketch,omitempty" msg:"sketch"` // actual representation of data, as a log sketch, instead of Summary
+}
+
+// ServiceEdge counts the calls from a service to another one, i.e. the
+// spans of the callee service whose parent span is from the caller service.
+type ServiceEdge struct {
+	Env    string `json:"env" msg:"env"`
+	Caller string `json:"caller" msg:"caller"`
+	Callee string `json:"callee" msg:"callee"`
 
-	TopLevel float64 `json:"top_level"` // number of top-level spans contributing to this count
+	Hits     float64 `json:"hits" msg:"hits"`
+	Errors   float64 `json:"errors" msg:"errors"`
+	Duration float64 `json:"duration" msg:"duration"` // total duration of the callee spans, in nanoseconds
+}
+
+// ServiceEdgeKey generates the key used to aggregate service edges
+// which is of the form: env|caller|callee
+func ServiceEdgeKey(env, caller, callee string) string {
+	return env + "|" + caller + "|" + callee
+}
 
-	Summary *quantile.SliceSummary `json:"summary"` // actual representation of data
+// Merge adds the counts of e2 to the edge.
+func (e *ServiceEdge) Merge(e2 ServiceEdge) {
+	e.Hits += e2.Hits
+	e.Errors += e2.Errors
+	e.Duration += e2.Duration
 }
 
 // GrainKey generates the key used to aggregate counts and distributions
@@ -77,6 +105,7 @@ func (c Count) Merge(c2 Count) Count {
 	}
 
 	c.Value += c2.Value
+	c.TopLevel += c2.TopLevel
 	return c
 }
 
@@ -91,14 +120,34 @@ func NewDistribution(m, ckey, name string, tgs TagSet) Distribution {
 	}
 }
 
+// NewSketchDistribution returns a new Distribution backed by a log sketch
+// with the given relative accuracy, for a metric and a given tag set
+func NewSketchDistribution(m, ckey, name string, tgs TagSet, alpha float64) Distribution {
+	return Distribution{
+		Key:     ckey,
+		Name:    name,
+		Measure: m,
+		TagSet:  tgs,
+		Sketch:  quantile.NewLogSketch(alpha, quantile.DefaultSketchMaxBins),
+	}
+}
+
 // Add inserts the proper values in a given distribution from a span
 func (d Distribution) Add(v float64, sampleID uint64) {
+	if d.Sketch != nil {
+		d.Sketch.Insert(v, sampleID)
+		return
+	}
 	d.Summary.Insert(v, sampleID)
 }
 
 // Merge is used when 2 Distributions represent the same thing and it merges the 2 underlying summaries
 func (d Distribution) Merge(d2 Distribution) {
 	// We don't check tagsets for distributions as we reaggregate without reallocating new structs
+	if d.Sketch != nil {
+		d.Sketch.Merge(d2.Sketch)
+		return
+	}
 	d.Summary.Merge(d2.Summary)
 }
 
@@ -106,6 +155,10 @@ func (d Distribution) Merge(d2 Distribution) {
 // new distribution.
 func (d Distribution) Weigh(weight float64) Distribution {
 	d2 := Distribution(d)
+	if d.Sketch != nil {
+		d2.Sketch = d.Sketch.Weigh(weight)
+		return d2
+	}
 	d2.Summary = quantile.WeighSummary(d.Summary, weight)
 	return d2
 }
@@ -113,10 +166,22 @@ func (d Distribution) Weigh(weight float64) Distribution {
 // Copy returns a distro with the same data but a different underlying summary
 func (d Distribution) Copy() Distribution {
 	d2 := Distribution(d)
+	if d.Sketch != nil {
+		d2.Sketch = d.Sketch.Copy()
+		return d2
+	}
 	d2.Summary = d.Summary.Copy()
 	return d2
 }
 
+// Size returns the number of values inserted in the distribution.
+func (d Distribution) Size() float64 {
+	if d.Sketch != nil {
+		return d.Sketch.Count()
+	}
+	return float64(d.Summary.N)
+}
+
 // StatsBucket is a time bucket to track statistic around multiple Counts
 type StatsBucket struct {
 	Start    int64 // Timestamp of start in our format
@@ -126,6 +191,16 @@ type StatsBucket struct {
 	Counts           map[string]Count        // All the counts
 	Distributions    map[string]Distribution // All the distributions (e.g.: for quantile queries)
 	ErrDistributions map[string]Distribution // All the error distributions (e.g.: for apdex, as they account for frustrated)
+
+	// Correction is true if the bucket only holds late spans, reported after
+	// the stats for its time frame were already flushed
+	Correction bool `json:",omitempty"`
+	// LateSpans is the number of spans which ended before this bucket, but
+	// were accounted in it as their own bucket was already flushed
+	LateSpans int64 `json:",omitempty"`
+
+	// ServiceEdges are the calls between services, indexed by ServiceEdgeKey
+	ServiceEdges map[string]ServiceEdge `json:",omitempty"`
 }
 
 // NewStatsBucket opens a new bucket for time ts and initializes it properly
@@ -144,3 +219,66 @@ func NewStatsBucket(ts, d int64) StatsBucket {
 func (sb StatsBucket) IsEmpty() bool {
 	return len(sb.Counts) == 0 && len(sb.Distributions) == 0 && len(sb.ErrDistributions) == 0
 }
+
+// Merge adds the stats of sb2, a bucket for the same time frame, to the
+// bucket. The distributions of sb2 backed by another structure than the
+// ones of the bucket cannot be merged, they are ignored and their number is
+// returned. The bucket may share data with sb2 afterwards, so sb2 should not
+// be used anymore.
+func (sb *StatsBucket) Merge(sb2 StatsBucket) (skipped int) {
+	if sb.Counts == nil {
+		sb.Counts = make(map[string]Count, len(sb2.Counts))
+	}
+	for k, c2 := range sb2.Counts {
+		if c, ok := sb.Counts[k]; ok {
+			sb.Counts[k] = c.Merge(c2)
+		} else {
+			sb.Counts[k] = c2
+		}
+	}
+
+	if sb.Distributions == nil {
+		sb.Distributions = make(map[string]Distribution, len(sb2.Distributions))
+	}
+	skipped += mergeDistributions(sb.Distributions, sb2.Distributions)
+	if sb.ErrDistributions == nil {
+		sb.ErrDistributions = make(map[string]Distribution, len(sb2.ErrDistributions))
+	}
+	skipped += mergeDistributions(sb.ErrDistributions, sb2.ErrDistributions)
+
+	sb.LateSpans += sb2.LateSpans
+
+	if len(sb2.ServiceEdges) > 0 && sb.ServiceEdges == nil {
+		sb.ServiceEdges = make(map[string]ServiceEdge, len(sb2.ServiceEdges))
+	}
+	for k, e2 := range sb2.ServiceEdges {
+		e := sb.ServiceEdges[k]
+		if e.Caller == "" {
+			e = ServiceEdge{Env: e2.Env, Caller: e2.Caller, Callee: e2.Callee}
+		}
+		e.Merge(e2)
+		sb.ServiceEdges[k] = e
+	}
+
+	return skipped
+}
+
+// mergeDistributions merges the distributions of d2 into d, by key, and
+// returns the number of the ones it could not merge.
+func mergeDistributions(d, d2 map[string]Distribution) (skipped int) {
+	for k, v2 := range d2 {
+		v, ok := d[k]
+		if !ok {
+			d[k] = v2
+			continue
+		}
+		if (v.Sketch == nil) != (v2.Sketch == nil) {
+			skipped++
+			continue
+		}
+		v.Merge(v2)
+		v.TopLevel += v2.TopLevel
+		d[k] = v
+	}
+	return skipped
+}
@@ -294,6 +294,52 @@ func TestStatsBucketMany(t *testing.T) {
 	}
 }
 
+func TestStatsBucketMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	spans := testSpans()
+	all := NewStatsRawBucket(0, 1e9)
+	halves := []*StatsRawBucket{NewStatsRawBucket(0, 1e9), NewStatsRawBucket(0, 1e9)}
+	for i, s := range spans {
+		all.HandleSpan(s, defaultEnv, nil, nil)
+		halves[i%2].HandleSpan(s, defaultEnv, nil, nil)
+	}
+	halves[0].HandleServiceEdge("A", spans[2], defaultEnv)
+	halves[1].HandleServiceEdge("A", spans[3], defaultEnv)
+
+	expected := all.Export()
+	sb := halves[0].Export()
+	assert.Equal(0, sb.Merge(halves[1].Export()))
+
+	assert.Len(sb.Counts, len(expected.Counts))
+	for k, c := range expected.Counts {
+		assert.Equal(c.Value, sb.Counts[k].Value, k)
+		assert.Equal(c.TopLevel, sb.Counts[k].TopLevel, k)
+	}
+	assert.Len(sb.Distributions, len(expected.Distributions))
+	for k, d := range expected.Distributions {
+		assert.Equal(d.Size(), sb.Distributions[k].Size(), k)
+		assert.Equal(d.TopLevel, sb.Distributions[k].TopLevel, k)
+	}
+	assert.Equal(map[string]ServiceEdge{
+		"default|A|B": {Env: defaultEnv, Caller: "A", Callee: "B", Hits: 2, Errors: 1, Duration: 7},
+	}, sb.ServiceEdges)
+
+	// distributions backed by different structures are not merged
+	sketches := NewStatsRawBucket(0, 1e9)
+	sketches.UseSketches(0.01)
+	sketches.HandleSpan(spans[0], defaultEnv, nil, nil)
+	size := sb.Distributions["A.foo|duration|env:default,resource:α,service:A"].Size()
+	assert.Equal(2, sb.Merge(sketches.Export())) // its duration and error distributions
+	assert.Equal(size, sb.Distributions["A.foo|duration|env:default,resource:α,service:A"].Size())
+	assert.Equal(float64(2), sb.Counts["A.foo|hits|env:default,resource:α,service:A"].Value)
+
+	// buckets decoded from JSON may lack some maps
+	var empty StatsBucket
+	empty.Merge(expected)
+	assert.Len(empty.Counts, len(expected.Counts))
+}
+
 func TestStatsBucketSublayers(t *testing.T) {
 	assert := assert.New(t)
 
@@ -7,6 +7,10 @@ import (
 	"github.com/DataDog/datadog-trace-agent/quantile"
 )
 
+// OverflowResource is the resource of the grain spans are folded into once
+// the cardinality limits of a bucket are reached.
+const OverflowResource = "_other"
+
 // Most "algorithm" stuff here is tested with stats_test.go as what is important
 // is that the final data, the one with send after a call to Export(), is correct.
 
@@ -18,8 +22,42 @@ type groupedStats struct {
 	hits                    float64
 	errors                  float64
 	duration                float64
-	durationDistribution    *quantile.SliceSummary
-	errDurationDistribution *quantile.SliceSummary
+	durationDistribution    rawDistribution
+	errDurationDistribution rawDistribution
+
+	// metrics holds the stats of the span metrics tracked by the bucket,
+	// only allocated when a span of this grain has one of them
+	metrics map[string]*metricStats
+}
+
+// metricStats aggregates the values of a span metric.
+type metricStats struct {
+	sum          float64
+	distribution rawDistribution
+}
+
+// rawDistribution is the distribution of the values of a measure, backed by
+// a GK summary, or by a log sketch if the bucket was set to use them.
+type rawDistribution struct {
+	summary *quantile.SliceSummary
+	sketch  *quantile.LogSketch
+}
+
+// newRawDistribution returns a distribution backed by a log sketch with the
+// relative accuracy sketchAlpha, or by a GK summary if sketchAlpha is 0.
+func newRawDistribution(sketchAlpha float64) rawDistribution {
+	if sketchAlpha > 0 {
+		return rawDistribution{sketch: quantile.NewLogSketch(sketchAlpha, quantile.DefaultSketchMaxBins)}
+	}
+	return rawDistribution{summary: quantile.NewSliceSummary()}
+}
+
+func (d rawDistribution) insert(v float64, t uint64) {
+	if d.sketch != nil {
+		d.sketch.Insert(v, t)
+		return
+	}
+	d.summary.Insert(v, t)
 }
 
 type sublayerStats struct {
@@ -30,11 +68,11 @@ type sublayerStats struct {
 	value int64
 }
 
-func newGroupedStats(tags TagSet) groupedStats {
+func newGroupedStats(tags TagSet, sketchAlpha float64) groupedStats {
 	return groupedStats{
 		tags:                    tags,
-		durationDistribution:    quantile.NewSliceSummary(),
-		errDurationDistribution: quantile.NewSliceSummary(),
+		durationDistribution:    newRawDistribution(sketchAlpha),
+		errDurationDistribution: newRawDistribution(sketchAlpha),
 	}
 }
 
@@ -68,6 +106,27 @@ type StatsRawBucket struct {
 	data         map[statsKey]groupedStats
 	sublayerData map[statsSubKey]sublayerStats
 
+	// metrics are the span Metrics keys for which we compute sums and distributions
+	metrics []string
+
+	// cardinality limits, 0 meaning no limit
+	maxGrains        int
+	maxServiceGrains int
+	serviceGrains    map[string]int   // number of distinct grains per service
+	serviceOverflow  map[string]int64 // number of spans folded into the overflow grain per service
+
+	// edges are the calls between services, indexed by ServiceEdgeKey
+	edges map[string]ServiceEdge
+
+	// correction is true if the bucket holds late spans for a time frame which was already flushed
+	correction bool
+	// lateSpans is the number of spans from older, already flushed, time frames accounted in this bucket
+	lateSpans int64
+
+	// sketchAlpha is the relative accuracy of the log sketches used for
+	// distributions, 0 meaning GK summaries are used instead
+	sketchAlpha float64
+
 	// internal buffer for aggregate strings - not threadsafe
 	keyBuf bytes.Buffer
 }
@@ -80,7 +139,93 @@ func NewStatsRawBucket(ts, d int64) *StatsRawBucket {
 		duration:     d,
 		data:         make(map[statsKey]groupedStats),
 		sublayerData: make(map[statsSubKey]sublayerStats),
+
+		serviceGrains:   make(map[string]int),
+		serviceOverflow: make(map[string]int64),
+	}
+}
+
+// TrackMetrics sets the span Metrics keys, such as "db.rows", for which the
+// bucket computes sums and distributions, in addition to the default measures.
+func (sb *StatsRawBucket) TrackMetrics(keys []string) {
+	sb.metrics = keys
+}
+
+// SetCorrection marks the bucket as a correction, holding late spans for
+// a time frame whose stats were already flushed.
+func (sb *StatsRawBucket) SetCorrection() {
+	sb.correction = true
+}
+
+// HandleLateSpan adds a span which ended before this bucket, as its own
+// bucket was already flushed, to this bucket stats, see HandleSpan.
+func (sb *StatsRawBucket) HandleLateSpan(s Span, env string, aggregators []string, sublayers *[]SublayerValue) {
+	sb.HandleSpan(s, env, aggregators, sublayers)
+	sb.lateSpans++
+}
+
+// HandleServiceEdge accounts the span s, whose parent span is from the
+// caller service, as a call from caller to the service of s.
+func (sb *StatsRawBucket) HandleServiceEdge(caller string, s Span, env string) {
+	if sb.edges == nil {
+		sb.edges = make(map[string]ServiceEdge)
+	}
+	key := ServiceEdgeKey(env, caller, s.Service)
+	e, ok := sb.edges[key]
+	if !ok {
+		e = ServiceEdge{Env: env, Caller: caller, Callee: s.Service}
+	}
+	e.Hits += s.weight
+	if s.Error != 0 {
+		e.Errors += s.weight
 	}
+	e.Duration += float64(s.Duration) * s.weight
+	sb.edges[key] = e
+}
+
+// UseSketches makes the bucket build its distributions with log sketches of
+// relative accuracy alpha, instead of GK summaries.
+func (sb *StatsRawBucket) UseSketches(alpha float64) {
+	sb.sketchAlpha = alpha
+}
+
+// LimitGrains sets the maximum number of distinct grains of the bucket, and
+// of each of its services. Once a limit is reached, spans which would create
+// a new grain are aggregated with resource OverflowResource and without
+// extra aggregators instead. These overflow grains count against the limits:
+// once the bucket is full, spans which would create one are aggregated with
+// name and service OverflowResource too, so that a bucket holds at most one
+// grain per env above maxGrains. A limit of 0 means no limit.
+func (sb *StatsRawBucket) LimitGrains(maxGrains, maxServiceGrains int) {
+	sb.maxGrains = maxGrains
+	sb.maxServiceGrains = maxServiceGrains
+}
+
+// ServiceCardinality reports the number of distinct grains of a service in a bucket.
+type ServiceCardinality struct {
+	Service  string
+	Grains   int   // number of distinct grains
+	Overflow int64 // number of spans folded into the overflow grain
+}
+
+// Cardinality returns the number of distinct grains of each service,
+// highest first.
+func (sb *StatsRawBucket) Cardinality() []ServiceCardinality {
+	card := make([]ServiceCardinality, 0, len(sb.serviceGrains))
+	for service, grains := range sb.serviceGrains {
+		card = append(card, ServiceCardinality{
+			Service:  service,
+			Grains:   grains,
+			Overflow: sb.serviceOverflow[service],
+		})
+	}
+	sort.Slice(card, func(i, j int) bool {
+		if card[i].Grains != card[j].Grains {
+			return card[i].Grains > card[j].Grains
+		}
+		return card[i].Service < card[j].Service
+	})
+	return card
 }
 
 // Export transforms a StatsRawBucket into a StatsBucket, typically used
@@ -88,6 +233,14 @@ func NewStatsRawBucket(ts, d int64) *StatsRawBucket {
 // type while StatsBucket is the public, shared one.
 func (sb *StatsRawBucket) Export() StatsBucket {
 	ret := NewStatsBucket(sb.start, sb.duration)
+	ret.Correction = sb.correction
+	ret.LateSpans = sb.lateSpans
+	if len(sb.edges) > 0 {
+		ret.ServiceEdges = make(map[string]ServiceEdge, len(sb.edges))
+		for k, e := range sb.edges {
+			ret.ServiceEdges[k] = e
+		}
+	}
 	for k, v := range sb.data {
 		hitsKey := GrainKey(k.name, HITS, k.aggr)
 		ret.Counts[hitsKey] = Count{
@@ -122,7 +275,8 @@ func (sb *StatsRawBucket) Export() StatsBucket {
 			Measure:  DURATION,
 			TagSet:   v.tags,
 			TopLevel: v.topLevel,
-			Summary:  v.durationDistribution,
+			Summary:  v.durationDistribution.summary,
+			Sketch:   v.durationDistribution.sketch,
 		}
 		ret.ErrDistributions[durationKey] = Distribution{
 			Key:      durationKey,
@@ -130,7 +284,28 @@ func (sb *StatsRawBucket) Export() StatsBucket {
 			Measure:  DURATION,
 			TagSet:   v.tags,
 			TopLevel: v.topLevel,
-			Summary:  v.errDurationDistribution,
+			Summary:  v.errDurationDistribution.summary,
+			Sketch:   v.errDurationDistribution.sketch,
+		}
+		for measure, ms := range v.metrics {
+			key := GrainKey(k.name, measure, k.aggr)
+			ret.Counts[key] = Count{
+				Key:      key,
+				Name:     k.name,
+				Measure:  measure,
+				TagSet:   v.tags,
+				TopLevel: v.topLevel,
+				Value:    ms.sum,
+			}
+			ret.Distributions[key] = Distribution{
+				Key:      key,
+				Name:     k.name,
+				Measure:  measure,
+				TagSet:   v.tags,
+				TopLevel: v.topLevel,
+				Summary:  ms.distribution.summary,
+				Sketch:   ms.distribution.sketch,
+			}
 		}
 	}
 	for k, v := range sb.sublayerData {
@@ -200,6 +375,15 @@ func (sb *StatsRawBucket) HandleSpan(s Span, env string, aggregators []string, s
 	}
 
 	grain, tags := assembleGrain(&sb.keyBuf, env, s.Resource, s.Service, m)
+	if sb.overflows(s, grain) {
+		sb.serviceOverflow[s.Service]++
+		grain, tags = assembleGrain(&sb.keyBuf, env, OverflowResource, s.Service, nil)
+		if sb.full() && !sb.hasGrain(s, grain) {
+			// no room left for the overflow grain of the service either
+			s.Name, s.Service = OverflowResource, OverflowResource
+			grain, tags = assembleGrain(&sb.keyBuf, env, OverflowResource, OverflowResource, nil)
+		}
+	}
 	sb.add(s, grain, tags)
 
 	// sublayers - special case
@@ -210,13 +394,37 @@ func (sb *StatsRawBucket) HandleSpan(s Span, env string, aggregators []string, s
 	}
 }
 
+// overflows returns true if the span would create a new grain while the
+// cardinality limits are reached.
+func (sb *StatsRawBucket) overflows(s Span, aggr string) bool {
+	if sb.maxGrains <= 0 && sb.maxServiceGrains <= 0 {
+		return false
+	}
+	if sb.hasGrain(s, aggr) {
+		return false
+	}
+	return sb.full() || (sb.maxServiceGrains > 0 && sb.serviceGrains[s.Service] >= sb.maxServiceGrains)
+}
+
+// full returns true if the bucket reached its maximum number of grains.
+func (sb *StatsRawBucket) full() bool {
+	return sb.maxGrains > 0 && len(sb.data) >= sb.maxGrains
+}
+
+// hasGrain returns true if the span would be aggregated in an existing grain.
+func (sb *StatsRawBucket) hasGrain(s Span, aggr string) bool {
+	_, ok := sb.data[statsKey{name: s.Name, aggr: aggr}]
+	return ok
+}
+
 func (sb *StatsRawBucket) add(s Span, aggr string, tags TagSet) {
 	var gs groupedStats
 	var ok bool
 
 	key := statsKey{name: s.Name, aggr: aggr}
 	if gs, ok = sb.data[key]; !ok {
-		gs = newGroupedStats(tags)
+		gs = newGroupedStats(tags, sb.sketchAlpha)
+		sb.serviceGrains[s.Service]++
 	}
 
 	if s.topLevel {
@@ -229,13 +437,29 @@ func (sb *StatsRawBucket) add(s Span, aggr string, tags TagSet) {
 	}
 	gs.duration += float64(s.Duration) * s.weight
 
-	// TODO add for s.Metrics ability to define arbitrary counts and distros, check some config?
 	// alter resolution of duration distro
 	trundur := nsTimestampToFloat(s.Duration)
-	gs.durationDistribution.Insert(trundur, s.SpanID)
+	gs.durationDistribution.insert(trundur, s.SpanID)
 
 	if s.Error != 0 {
-		gs.errDurationDistribution.Insert(trundur, s.SpanID)
+		gs.errDurationDistribution.insert(trundur, s.SpanID)
+	}
+
+	for _, m := range sb.metrics {
+		v, ok := s.metrics[m]
+		if !ok {
+			continue
+		}
+		if gs.metrics == nil {
+			gs.metrics = make(map[string]*metricStats, len(sb.metrics))
+		}
+		ms, ok := gs.metrics[m]
+		if !ok {
+			ms = &metricStats{distribution: newRawDistribution(sb.sketchAlpha)}
+			gs.metrics[m] = ms
+		}
+		ms.sum += v * s.weight
+		ms.distribution.insert(v, s.SpanID)
 	}
 
 	sb.data[key] = gs
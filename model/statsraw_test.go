@@ -27,3 +27,142 @@ func TestGrainWithExtraTags(t *testing.T) {
 	assert.Equal("env:default,resource:yo,service:thing,meta1:ONE,meta2:two", aggr)
 	assert.Equal(TagSet{Tag{"env", "default"}, Tag{"resource", "yo"}, Tag{"service", "thing"}, Tag{"meta1", "ONE"}, Tag{"meta2", "two"}}, tgs)
 }
+
+func TestStatsRawBucketTrackMetrics(t *testing.T) {
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.TrackMetrics([]string{"db.rows"})
+	assert := assert.New(t)
+
+	for i, rows := range []float64{10, 20, 30} {
+		t := Trace{Span{SpanID: uint64(i + 1), Service: "db", Name: "query", Resource: "SELECT", Duration: 1, Metrics: map[string]float64{"db.rows": rows}}}
+		t.ComputeTrackedMetrics([]string{"db.rows"})
+		t[0].weight = 2
+		srb.HandleSpan(t[0], "default", nil, nil)
+	}
+	// spans without the metric don't contribute to it
+	srb.HandleSpan(Span{SpanID: 4, Service: "db", Name: "query", Resource: "SELECT", Duration: 1, weight: 1}, "default", nil, nil)
+	srb.HandleSpan(Span{SpanID: 5, Service: "db", Name: "query", Resource: "INSERT", Duration: 1, weight: 1}, "default", nil, nil)
+
+	sb := srb.Export()
+	key := "query|db.rows|env:default,resource:SELECT,service:db"
+	if assert.Contains(sb.Counts, key) {
+		assert.Equal("db.rows", sb.Counts[key].Measure)
+		assert.Equal(float64(120), sb.Counts[key].Value)
+	}
+	if assert.Contains(sb.Distributions, key) {
+		d := sb.Distributions[key]
+		assert.Equal(3, d.Summary.N)
+		assert.Equal(float64(20), d.Summary.Quantile(0.5))
+	}
+	assert.NotContains(sb.Counts, "query|db.rows|env:default,resource:INSERT,service:db")
+	// one count per default measure for each resource, plus ours
+	assert.Len(sb.Counts, 7)
+}
+
+func TestStatsRawBucketLimitGrains(t *testing.T) {
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.LimitGrains(0, 2)
+	assert := assert.New(t)
+
+	for i, resource := range []string{"r1", "r2", "r3", "r4", "r1"} {
+		s := Span{SpanID: uint64(i + 1), Service: "web", Name: "request", Resource: resource, Duration: 1, weight: 1}
+		srb.HandleSpan(s, "default", nil, nil)
+	}
+	srb.HandleSpan(Span{SpanID: 6, Service: "db", Name: "query", Resource: "SELECT", Duration: 1, weight: 1}, "default", nil, nil)
+
+	sb := srb.Export()
+	assert.Equal(float64(2), sb.Counts["request|hits|env:default,resource:r1,service:web"].Value)
+	assert.Equal(float64(1), sb.Counts["request|hits|env:default,resource:r2,service:web"].Value)
+	assert.NotContains(sb.Counts, "request|hits|env:default,resource:r3,service:web")
+	assert.Equal(float64(2), sb.Counts["request|hits|env:default,resource:_other,service:web"].Value)
+	// other services have their own limit
+	assert.Contains(sb.Counts, "query|hits|env:default,resource:SELECT,service:db")
+
+	assert.Equal([]ServiceCardinality{
+		{Service: "web", Grains: 3, Overflow: 2},
+		{Service: "db", Grains: 1},
+	}, srb.Cardinality())
+}
+
+func TestStatsRawBucketLimitGrainsPerBucket(t *testing.T) {
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.LimitGrains(1, 0)
+	assert := assert.New(t)
+
+	aggr := []string{"user_id"}
+	for i, user := range []string{"1", "2", "3"} {
+		s := Span{SpanID: uint64(i + 1), Service: "web", Name: "request", Resource: "GET /", Duration: 1, weight: 1, Meta: map[string]string{"user_id": user}}
+		srb.HandleSpan(s, "default", aggr, nil)
+	}
+
+	sb := srb.Export()
+	assert.Equal(float64(1), sb.Counts["request|hits|env:default,resource:GET /,service:web,user_id:1"].Value)
+	// the overflow grain drops the extra aggregators, and the service too
+	// since the bucket is full
+	assert.Equal(float64(2), sb.Counts["_other|hits|env:default,resource:_other,service:_other"].Value)
+	assert.Len(sb.Counts, 6)
+}
+
+func TestStatsRawBucketLimitGrainsBoundary(t *testing.T) {
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.LimitGrains(3, 1)
+	assert := assert.New(t)
+
+	for i, s := range []struct{ service, resource string }{
+		{"web", "r1"},
+		{"web", "r2"}, // overflow grain of web
+		{"db", "q1"},  // the bucket is full
+		{"web", "r3"}, // overflow grain of web, which exists
+		{"db", "q2"},  // no room for the overflow grain of db
+		{"cache", "c1"},
+	} {
+		srb.HandleSpan(Span{SpanID: uint64(i + 1), Service: s.service, Name: "request", Resource: s.resource, Duration: 1, weight: 1}, "default", nil, nil)
+	}
+
+	sb := srb.Export()
+	assert.Len(sb.Counts, 4*3, "one overflow grain above the limit")
+	assert.Equal(float64(2), sb.Counts["request|hits|env:default,resource:_other,service:web"].Value)
+	assert.Equal(float64(2), sb.Counts["_other|hits|env:default,resource:_other,service:_other"].Value)
+	assert.NotContains(sb.Counts, "request|hits|env:default,resource:_other,service:db")
+}
+
+func TestStatsRawBucketUseSketches(t *testing.T) {
+	srb := NewStatsRawBucket(0, 1e9)
+	srb.UseSketches(0.01)
+	assert := assert.New(t)
+
+	for i, d := range []int64{100, 200, 300, 400} {
+		s := Span{SpanID: uint64(i + 1), Service: "web", Name: "request", Resource: "GET /", Duration: d, Error: int32(i % 2), weight: 1}
+		srb.HandleSpan(s, "default", nil, nil)
+	}
+
+	sb := srb.Export()
+	key := "request|duration|env:default,resource:GET /,service:web"
+	if assert.Contains(sb.Distributions, key) {
+		d := sb.Distributions[key]
+		assert.Nil(d.Summary)
+		assert.Equal(float64(4), d.Size())
+		assert.InDelta(200, d.Sketch.Quantile(0.5), 2)
+	}
+	if assert.Contains(sb.ErrDistributions, key) {
+		d := sb.ErrDistributions[key]
+		assert.Equal(float64(2), d.Size())
+		assert.InDelta(400, d.Sketch.Quantile(1), 4)
+	}
+}
+
+func TestStatsRawBucketHandleServiceEdge(t *testing.T) {
+	srb := NewStatsRawBucket(0, 1e9)
+	assert := assert.New(t)
+
+	assert.Nil(srb.Export().ServiceEdges)
+
+	srb.HandleServiceEdge("web", Span{Service: "db", Duration: 100, weight: 1}, "default")
+	srb.HandleServiceEdge("web", Span{Service: "db", Duration: 300, Error: 1, weight: 2}, "default")
+	srb.HandleServiceEdge("web", Span{Service: "cache", Duration: 10, weight: 1}, "default")
+
+	assert.Equal(map[string]ServiceEdge{
+		"default|web|db":    {Env: "default", Caller: "web", Callee: "db", Hits: 3, Errors: 2, Duration: 700},
+		"default|web|cache": {Env: "default", Caller: "web", Callee: "cache", Hits: 1, Duration: 10},
+	}, srb.Export().ServiceEdges)
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"fmt"
 	"sort"
+	"strings"
 )
 
 // SublayerValue is just a span-metric placeholder for a given sublayer val
@@ -26,7 +27,55 @@ func (v SublayerValue) GoString() string {
 	return v.String()
 }
 
-// ComputeSublayers extracts sublayer values by type and service for a trace
+// sublayerMetaPrefix prefixes the sublayer breakdowns by a span Meta tag.
+const sublayerMetaPrefix = "meta:"
+
+// SublayerBreakdown is an extra dimension sublayer durations are broken
+// down by, on top of the service and the type of spans.
+type SublayerBreakdown struct {
+	// MetaKey is the span Meta tag durations are broken down by, if empty
+	// they are broken down by span Name.
+	MetaKey string
+}
+
+// ParseSublayerBreakdown returns the breakdown described by s, either "name"
+// or "meta:<tag>", or an error if s is not a known breakdown.
+func ParseSublayerBreakdown(s string) (SublayerBreakdown, error) {
+	switch {
+	case s == "name":
+		return SublayerBreakdown{}, nil
+	case strings.HasPrefix(s, sublayerMetaPrefix) && len(s) > len(sublayerMetaPrefix):
+		return SublayerBreakdown{MetaKey: s[len(sublayerMetaPrefix):]}, nil
+	}
+	return SublayerBreakdown{}, fmt.Errorf("unknown sublayer breakdown %q", s)
+}
+
+// String returns the description of the breakdown, as parsed by ParseSublayerBreakdown.
+func (b SublayerBreakdown) String() string {
+	if b.MetaKey == "" {
+		return "name"
+	}
+	return sublayerMetaPrefix + b.MetaKey
+}
+
+// metric returns the sublayer metric and tag name of the breakdown.
+func (b SublayerBreakdown) metric() (string, string) {
+	if b.MetaKey == "" {
+		return "_sublayers.duration.by_name", "sublayer_name"
+	}
+	return "_sublayers.duration.by_meta", "sublayer_" + b.MetaKey
+}
+
+// selector returns the attribute of spans the breakdown is about.
+func (b SublayerBreakdown) selector() attrSelector {
+	if b.MetaKey == "" {
+		return func(s *Span) string { return s.Name }
+	}
+	return func(s *Span) string { return s.Meta[b.MetaKey] }
+}
+
+// ComputeSublayers extracts sublayer values by type and service for a
+// trace, and by each of the given extra breakdowns
 //
 // Description of the algorithm, with the following trace as an example:
 //
@@ -93,7 +142,7 @@ func (v SublayerValue) GoString() string {
 //             db: 55,
 //             rpc: 55,
 //         }
-func ComputeSublayers(trace Trace) []SublayerValue {
+func ComputeSublayers(trace Trace, breakdowns ...SublayerBreakdown) []SublayerValue {
 	timestamps := buildTraceTimestamps(trace)
 	activeSpans := buildTraceActiveSpansMapping(trace, timestamps)
 
@@ -125,6 +174,17 @@ func ComputeSublayers(trace Trace) []SublayerValue {
 		})
 	}
 
+	for _, b := range breakdowns {
+		metric, tagName := b.metric()
+		for attr, duration := range computeDurationByAttr(timestamps, activeSpans, b.selector()) {
+			values = append(values, SublayerValue{
+				Metric: metric,
+				Tag:    Tag{tagName, attr},
+				Value:  float64(int64(duration)),
+			})
+		}
+	}
+
 	values = append(values, SublayerValue{
 		Metric: "_sublayers.span_count",
 		Value:  float64(len(trace)),
@@ -133,6 +193,45 @@ func ComputeSublayers(trace Trace) []SublayerValue {
 	return values
 }
 
+// ComputeTopLevelSublayers extracts the sublayer values of the subtree of
+// each top-level span of the trace, but its root, keyed by span ID. Top-level
+// spans must already be computed, see ComputeTopLevel.
+func ComputeTopLevelSublayers(trace Trace, breakdowns ...SublayerBreakdown) map[uint64][]SublayerValue {
+	root := trace.GetRoot()
+	childrenMap := trace.ChildrenMap()
+
+	var sublayers map[uint64][]SublayerValue
+	for i := range trace {
+		s := &trace[i]
+		if !s.TopLevel() || s == root {
+			continue
+		}
+		if sublayers == nil {
+			sublayers = make(map[uint64][]SublayerValue)
+		}
+		sublayers[s.SpanID] = ComputeSublayers(subtree(s, childrenMap), breakdowns...)
+	}
+	return sublayers
+}
+
+// subtree returns a trace made of top and all of its descendants.
+func subtree(top *Span, childrenMap map[uint64]Spans) Trace {
+	var t Trace
+	seen := map[uint64]bool{}
+	spans := Spans{top}
+	for len(spans) > 0 {
+		s := spans[len(spans)-1]
+		spans = spans[:len(spans)-1]
+		if seen[s.SpanID] {
+			continue
+		}
+		seen[s.SpanID] = true
+		t = append(t, *s)
+		spans = append(spans, childrenMap[s.SpanID]...)
+	}
+	return t
+}
+
 // int64Slice is used by buildTraceTimestamps as a sortable slice of
 // int64
 type int64Slice []int64
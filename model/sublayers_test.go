@@ -242,6 +242,90 @@ func TestComputeSublayers(t *testing.T) {
 	}
 }
 
+func TestComputeSublayersBreakdowns(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		Span{TraceID: 1, SpanID: 1, Service: "web", Name: "http.request", Type: "web", Start: 0, Duration: 100},
+		Span{TraceID: 1, SpanID: 2, ParentID: 1, Service: "db", Name: "pg.query", Type: "db", Start: 10, Duration: 30,
+			Meta: map[string]string{"db.instance": "users"}},
+		Span{TraceID: 1, SpanID: 3, ParentID: 1, Service: "db", Name: "pg.query", Type: "db", Start: 50, Duration: 20,
+			Meta: map[string]string{"db.instance": "orders"}},
+	}
+
+	values := ComputeSublayers(trace, SublayerBreakdown{}, SublayerBreakdown{MetaKey: "db.instance"})
+	sort.Sort(sublayerValues(values))
+
+	assert.Equal([]SublayerValue{
+		{Metric: "_sublayers.duration.by_meta", Tag: Tag{"sublayer_db.instance", "orders"}, Value: 20},
+		{Metric: "_sublayers.duration.by_meta", Tag: Tag{"sublayer_db.instance", "users"}, Value: 30},
+		{Metric: "_sublayers.duration.by_name", Tag: Tag{"sublayer_name", "http.request"}, Value: 50},
+		{Metric: "_sublayers.duration.by_name", Tag: Tag{"sublayer_name", "pg.query"}, Value: 50},
+		{Metric: "_sublayers.duration.by_service", Tag: Tag{"sublayer_service", "db"}, Value: 50},
+		{Metric: "_sublayers.duration.by_service", Tag: Tag{"sublayer_service", "web"}, Value: 50},
+		{Metric: "_sublayers.duration.by_type", Tag: Tag{"sublayer_type", "db"}, Value: 50},
+		{Metric: "_sublayers.duration.by_type", Tag: Tag{"sublayer_type", "web"}, Value: 50},
+		{Metric: "_sublayers.span_count", Value: 3},
+	}, values)
+}
+
+func TestComputeTopLevelSublayers(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		Span{TraceID: 1, SpanID: 1, Service: "web", Type: "web", Start: 0, Duration: 100},
+		Span{TraceID: 1, SpanID: 2, ParentID: 1, Service: "web", Type: "web", Start: 0, Duration: 80},
+		Span{TraceID: 1, SpanID: 3, ParentID: 2, Service: "api", Name: "http.client", Type: "http", Start: 10, Duration: 50},
+		Span{TraceID: 1, SpanID: 4, ParentID: 3, Service: "db", Name: "pg.query", Type: "db", Start: 20, Duration: 20},
+	}
+	trace.ComputeTopLevel()
+
+	sublayers := ComputeTopLevelSublayers(trace, SublayerBreakdown{})
+	assert.Len(sublayers, 2)
+	assert.NotContains(sublayers, uint64(1), "the root sublayers are computed on their own")
+	assert.NotContains(sublayers, uint64(2), "not a top-level span")
+
+	values := sublayers[3]
+	sort.Sort(sublayerValues(values))
+	assert.Equal([]SublayerValue{
+		{Metric: "_sublayers.duration.by_name", Tag: Tag{"sublayer_name", "http.client"}, Value: 30},
+		{Metric: "_sublayers.duration.by_name", Tag: Tag{"sublayer_name", "pg.query"}, Value: 20},
+		{Metric: "_sublayers.duration.by_service", Tag: Tag{"sublayer_service", "api"}, Value: 30},
+		{Metric: "_sublayers.duration.by_service", Tag: Tag{"sublayer_service", "db"}, Value: 20},
+		{Metric: "_sublayers.duration.by_type", Tag: Tag{"sublayer_type", "db"}, Value: 20},
+		{Metric: "_sublayers.duration.by_type", Tag: Tag{"sublayer_type", "http"}, Value: 30},
+		{Metric: "_sublayers.span_count", Value: 2},
+	}, values)
+
+	values = sublayers[4]
+	sort.Sort(sublayerValues(values))
+	assert.Equal([]SublayerValue{
+		{Metric: "_sublayers.duration.by_name", Tag: Tag{"sublayer_name", "pg.query"}, Value: 20},
+		{Metric: "_sublayers.duration.by_service", Tag: Tag{"sublayer_service", "db"}, Value: 20},
+		{Metric: "_sublayers.duration.by_type", Tag: Tag{"sublayer_type", "db"}, Value: 20},
+		{Metric: "_sublayers.span_count", Value: 1},
+	}, values)
+}
+
+func TestParseSublayerBreakdown(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := ParseSublayerBreakdown("name")
+	assert.NoError(err)
+	assert.Equal(SublayerBreakdown{}, b)
+	assert.Equal("name", b.String())
+
+	b, err = ParseSublayerBreakdown("meta:db.instance")
+	assert.NoError(err)
+	assert.Equal(SublayerBreakdown{MetaKey: "db.instance"}, b)
+	assert.Equal("meta:db.instance", b.String())
+
+	for _, s := range []string{"meta:", "resource", ""} {
+		_, err = ParseSublayerBreakdown(s)
+		assert.Error(err, s)
+	}
+}
+
 func TestBuildTraceTimestamps(t *testing.T) {
 	assert := assert.New(t)
 
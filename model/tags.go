@@ -7,12 +7,14 @@ import (
 	"unicode"
 )
 
+//go:generate msgp -marshal=false
+
 const maxTagLength = 200
 
 // Tag represents a key / value dimension on traces and stats.
 type Tag struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	Name  string `json:"name" msg:"name"`
+	Value string `json:"value" msg:"value"`
 }
 
 // String returns a string representation of a tag
@@ -2,8 +2,8 @@ package model
 
 const (
 	// TraceMetricsKey is a tag key which, if set to true,
-	// ensures all statistics are computed for this span.
-	// [FIXME] *not implemented yet*
+	// ensures all statistics are computed for this span,
+	// even if it is not top-level.
 	TraceMetricsKey = "datadog.trace_metrics"
 
 	// This is a special metric, it's 1 if the span is top-level, 0 if not.
@@ -66,6 +66,13 @@ func (s *Span) TopLevel() bool {
 	return s.Metrics[topLevelKey] == 1
 }
 
+// ComputedTopLevel returns true if span was marked top-level by the last call
+// to ComputeTopLevel. Unlike TopLevel, it does not read the Metrics map, so it
+// can be called while other goroutines update the span metrics.
+func (s *Span) ComputedTopLevel() bool {
+	return s.topLevel
+}
+
 // ForceMetrics returns true if statistics computation should be forced for this span.
 func (s *Span) ForceMetrics() bool {
 	return s.Meta[TraceMetricsKey] == trueTagValue
@@ -138,9 +138,11 @@ func TestTopLevelGetSetBlackBox(t *testing.T) {
 	span.setTopLevel(true)
 	assert.True(span.TopLevel(), "marked as top-level")
 	assert.True(span.topLevel, "marked as top-level")
+	assert.True(span.ComputedTopLevel(), "marked as top-level")
 	span.setTopLevel(false)
 	assert.False(span.TopLevel(), "no more top-level")
 	assert.False(span.topLevel, "no more top-level")
+	assert.False(span.ComputedTopLevel(), "no more top-level")
 
 	span.Metrics = map[string]float64{"custom": 42}
 
@@ -110,3 +110,26 @@ func (t Trace) ComputeWeight(root Span) {
 		t[i].weight = weight
 	}
 }
+
+// ComputeTrackedMetrics sets the metrics private attribute of each span to
+// a copy of its Metrics values for the given keys, which stats are computed
+// from since the Metrics map may be updated by the sampler meanwhile.
+func (t Trace) ComputeTrackedMetrics(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	for i := range t {
+		var metrics map[string]float64
+		for _, k := range keys {
+			v, ok := t[i].Metrics[k]
+			if !ok {
+				continue
+			}
+			if metrics == nil {
+				metrics = make(map[string]float64, len(keys))
+			}
+			metrics[k] = v
+		}
+		t[i].metrics = metrics
+	}
+}
@@ -53,3 +53,20 @@ func TestTraceChildrenMap(t *testing.T) {
 	assert.Equal(Spans{}, childrenMap[5])
 	assert.Equal(Spans{}, childrenMap[6])
 }
+
+func TestTraceComputeTrackedMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := Trace{
+		Span{SpanID: 1, Metrics: map[string]float64{"db.rows": 10, "other": 1}},
+		Span{SpanID: 2, ParentID: 1},
+	}
+	trace.ComputeTrackedMetrics([]string{"db.rows"})
+
+	assert.Equal(map[string]float64{"db.rows": 10}, trace[0].metrics)
+	assert.Nil(trace[1].metrics)
+
+	// later updates of the Metrics map are not seen by stats
+	trace[0].Metrics["db.rows"] = 20
+	assert.Equal(float64(10), trace[0].metrics["db.rows"])
+}
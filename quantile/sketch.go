@@ -0,0 +1,347 @@
+package quantile
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+//go:generate msgp -marshal=false -unexported
+//msgp:ignore sketchStore LogSketch
+
+/*
+LogSketch is a relative-error quantile sketch, along the lines of DDSketch
+"DDSketch: A Fast and Fully-Mergeable Quantile Sketch with Relative-Error
+Guarantees" (Masson, Rim, Lee 2019)
+
+https://arxiv.org/abs/1908.10693
+
+Values are counted in buckets whose boundaries grow exponentially, so that
+any value is within a relative error alpha of the representative value of
+its bucket. Quantiles on tail latencies are then accurate relatively to
+their value, not to their rank, and two sketches built with the same alpha
+merge without any loss.
+*/
+
+const (
+	// DefaultSketchAlpha is the default relative accuracy of a LogSketch.
+	DefaultSketchAlpha = 0.01
+	// DefaultSketchMaxBins is the default maximum number of buckets per
+	// sign of a LogSketch. Above it, the lowest buckets are collapsed.
+	DefaultSketchMaxBins = 2048
+
+	// sketchMinValue is the smallest magnitude we index, anything closer
+	// to 0 is counted as 0.
+	sketchMinValue = 1e-9
+)
+
+// sketchStore holds the counts of contiguous buckets.
+type sketchStore struct {
+	offset int       // key of counts[0]
+	counts []float64 // counts per key, starting at offset
+}
+
+// add adds c to the bucket with the given key, collapsing the lowest buckets
+// if there are more than maxBins of them.
+func (s *sketchStore) add(key int, c float64, maxBins int) {
+	switch {
+	case len(s.counts) == 0:
+		s.offset = key
+		s.counts = []float64{0}
+	case key < s.offset:
+		grown := make([]float64, s.offset-key+len(s.counts))
+		copy(grown[s.offset-key:], s.counts)
+		s.counts = grown
+		s.offset = key
+	case key-s.offset >= len(s.counts):
+		s.counts = append(s.counts, make([]float64, key-s.offset-len(s.counts)+1)...)
+	}
+	s.counts[key-s.offset] += c
+
+	if maxBins > 0 && len(s.counts) > maxBins {
+		n := len(s.counts) - maxBins
+		var sum float64
+		for _, c := range s.counts[:n] {
+			sum += c
+		}
+		s.counts = append([]float64(nil), s.counts[n:]...)
+		s.counts[0] += sum
+		s.offset += n
+	}
+}
+
+func (s *sketchStore) copy() sketchStore {
+	return sketchStore{
+		offset: s.offset,
+		counts: append([]float64(nil), s.counts...),
+	}
+}
+
+// LogSketch is a mergeable sketch answering quantile queries with a
+// relative accuracy, see above.
+type LogSketch struct {
+	alpha    float64
+	maxBins  int
+	logGamma float64 // log((1+alpha)/(1-alpha))
+
+	pos  sketchStore // values >= sketchMinValue
+	neg  sketchStore // values <= -sketchMinValue, keyed by their magnitude
+	zero float64     // count of the values in between
+
+	count    float64
+	min, max float64
+	sum      float64
+}
+
+// NewLogSketch returns a new sketch with the given relative accuracy,
+// between 0 and 1, and maximum number of buckets per sign. Invalid values
+// fall back to the defaults, and a maxBins of 0 means no limit.
+func NewLogSketch(alpha float64, maxBins int) *LogSketch {
+	if alpha <= 0 || alpha >= 1 {
+		alpha = DefaultSketchAlpha
+	}
+	if maxBins < 0 {
+		maxBins = DefaultSketchMaxBins
+	}
+	return &LogSketch{
+		alpha:    alpha,
+		maxBins:  maxBins,
+		logGamma: math.Log((1 + alpha) / (1 - alpha)),
+	}
+}
+
+// Alpha returns the relative accuracy of the sketch.
+func (s *LogSketch) Alpha() float64 {
+	return s.alpha
+}
+
+// Count returns the (weighted) number of values inserted in the sketch.
+func (s *LogSketch) Count() float64 {
+	return s.count
+}
+
+// Sum returns the (weighted) sum of the values inserted in the sketch.
+func (s *LogSketch) Sum() float64 {
+	return s.sum
+}
+
+func (s *LogSketch) key(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns the representative value of a bucket, which is within
+// alpha of any value of the bucket.
+func (s *LogSketch) value(key int) float64 {
+	return 2 * math.Exp(float64(key)*s.logGamma) / (1 + math.Exp(s.logGamma))
+}
+
+// Insert inserts a new value v in the sketch. t, the ID of the span it was
+// reported from, is not used, it only makes LogSketch a drop-in replacement
+// for SliceSummary.
+func (s *LogSketch) Insert(v float64, t uint64) {
+	s.insert(v, 1)
+}
+
+func (s *LogSketch) insert(v, c float64) {
+	switch {
+	case v >= sketchMinValue:
+		s.pos.add(s.key(v), c, s.maxBins)
+	case v <= -sketchMinValue:
+		s.neg.add(s.key(-v), c, s.maxBins)
+	default:
+		s.zero += c
+	}
+
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count += c
+	s.sum += v * c
+}
+
+// Quantile returns an estimate of the element at quantile 'q' (0 <= q <= 1),
+// within a relative error alpha.
+func (s *LogSketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	rank := q * (s.count - 1)
+	var n float64
+
+	// from the lowest value up: negative values by decreasing magnitude,
+	// then zeros, then positive values
+	for i := len(s.neg.counts) - 1; i >= 0; i-- {
+		n += s.neg.counts[i]
+		if n > rank {
+			return s.clamp(-s.value(s.neg.offset + i))
+		}
+	}
+	n += s.zero
+	if n > rank {
+		return 0
+	}
+	for i, c := range s.pos.counts {
+		n += c
+		if n > rank {
+			return s.clamp(s.value(s.pos.offset + i))
+		}
+	}
+	return s.max
+}
+
+func (s *LogSketch) clamp(v float64) float64 {
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+// Merge merges s2 into s. Sketches with the same accuracy are merged without
+// loss, otherwise the buckets of s2 are inserted with their representative value.
+func (s *LogSketch) Merge(s2 *LogSketch) {
+	if s2 == nil || s2.count == 0 {
+		return
+	}
+	if s.count == 0 || s2.min < s.min {
+		s.min = s2.min
+	}
+	if s.count == 0 || s2.max > s.max {
+		s.max = s2.max
+	}
+	s.count += s2.count
+	s.sum += s2.sum
+	s.zero += s2.zero
+
+	sameMapping := s.logGamma == s2.logGamma
+	for i, c := range s2.pos.counts {
+		if c == 0 {
+			continue
+		}
+		key := s2.pos.offset + i
+		if !sameMapping {
+			key = s.key(s2.value(key))
+		}
+		s.pos.add(key, c, s.maxBins)
+	}
+	for i, c := range s2.neg.counts {
+		if c == 0 {
+			continue
+		}
+		key := s2.neg.offset + i
+		if !sameMapping {
+			key = s.key(s2.value(key))
+		}
+		s.neg.add(key, c, s.maxBins)
+	}
+}
+
+// Copy allocates a new sketch with the same data.
+func (s *LogSketch) Copy() *LogSketch {
+	s2 := *s
+	s2.pos = s.pos.copy()
+	s2.neg = s.neg.copy()
+	return &s2
+}
+
+// Weigh returns a copy of the sketch with all its counts multiplied by weight.
+func (s *LogSketch) Weigh(weight float64) *LogSketch {
+	s2 := s.Copy()
+	for i := range s2.pos.counts {
+		s2.pos.counts[i] *= weight
+	}
+	for i := range s2.neg.counts {
+		s2.neg.counts[i] *= weight
+	}
+	s2.zero *= weight
+	s2.count *= weight
+	s2.sum *= weight
+	return s2
+}
+
+// sketchStoreData and logSketchData are the serialized forms of the
+// stores and of the sketch.
+type sketchStoreData struct {
+	Offset int       `json:"offset" msg:"offset"`
+	Counts []float64 `json:"counts" msg:"counts"`
+}
+
+type logSketchData struct {
+	Alpha   float64         `json:"alpha" msg:"alpha"`
+	MaxBins int             `json:"max_bins" msg:"max_bins"`
+	Count   float64         `json:"count" msg:"count"`
+	Min     float64         `json:"min" msg:"min"`
+	Max     float64         `json:"max" msg:"max"`
+	Sum     float64         `json:"sum" msg:"sum"`
+	Zero    float64         `json:"zero" msg:"zero"`
+	Pos     sketchStoreData `json:"pos" msg:"pos"`
+	Neg     sketchStoreData `json:"neg" msg:"neg"`
+}
+
+func (s *LogSketch) data() *logSketchData {
+	return &logSketchData{
+		Alpha:   s.alpha,
+		MaxBins: s.maxBins,
+		Count:   s.count,
+		Min:     s.min,
+		Max:     s.max,
+		Sum:     s.sum,
+		Zero:    s.zero,
+		Pos:     sketchStoreData{Offset: s.pos.offset, Counts: s.pos.counts},
+		Neg:     sketchStoreData{Offset: s.neg.offset, Counts: s.neg.counts},
+	}
+}
+
+func (s *LogSketch) load(sd *logSketchData) {
+	*s = *NewLogSketch(sd.Alpha, sd.MaxBins)
+	s.count = sd.Count
+	s.min = sd.Min
+	s.max = sd.Max
+	s.sum = sd.Sum
+	s.zero = sd.Zero
+	s.pos = sketchStore{offset: sd.Pos.Offset, counts: sd.Pos.Counts}
+	s.neg = sketchStore{offset: sd.Neg.Offset, counts: sd.Neg.Counts}
+}
+
+// MarshalJSON serializes the sketch.
+func (s *LogSketch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.data())
+}
+
+// UnmarshalJSON deserializes a sketch serialized with MarshalJSON.
+func (s *LogSketch) UnmarshalJSON(b []byte) error {
+	var sd logSketchData
+	if err := json.Unmarshal(b, &sd); err != nil {
+		return err
+	}
+	s.load(&sd)
+	return nil
+}
+
+// EncodeMsg implements msgp.Encodable, with the same fields as MarshalJSON.
+func (s *LogSketch) EncodeMsg(en *msgp.Writer) error {
+	return s.data().EncodeMsg(en)
+}
+
+// DecodeMsg implements msgp.Decodable, for a sketch encoded with EncodeMsg.
+func (s *LogSketch) DecodeMsg(dc *msgp.Reader) error {
+	var sd logSketchData
+	if err := sd.DecodeMsg(dc); err != nil {
+		return err
+	}
+	s.load(&sd)
+	return nil
+}
+
+// Msgsize returns an upper bound of the size of the sketch encoded with
+// EncodeMsg.
+func (s *LogSketch) Msgsize() int {
+	return s.data().Msgsize()
+}
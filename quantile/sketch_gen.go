@@ -0,0 +1,365 @@
+package quantile
+
+// NOTE: THIS FILE WAS PRODUCED BY THE
+// MSGP CODE GENERATION TOOL (github.com/tinylib/msgp)
+// DO NOT EDIT
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+// DecodeMsg implements msgp.Decodable
+func (z *logSketchData) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "alpha":
+			z.Alpha, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "max_bins":
+			z.MaxBins, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "count":
+			z.Count, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "min":
+			z.Min, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "max":
+			z.Max, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "sum":
+			z.Sum, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "zero":
+			z.Zero, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "pos":
+			var zb0002 uint32
+			zb0002, err = dc.ReadMapHeader()
+			if err != nil {
+				return
+			}
+			for zb0002 > 0 {
+				zb0002--
+				field, err = dc.ReadMapKeyPtr()
+				if err != nil {
+					return
+				}
+				switch msgp.UnsafeString(field) {
+				case "offset":
+					z.Pos.Offset, err = dc.ReadInt()
+					if err != nil {
+						return
+					}
+				case "counts":
+					var zb0003 uint32
+					zb0003, err = dc.ReadArrayHeader()
+					if err != nil {
+						return
+					}
+					if cap(z.Pos.Counts) >= int(zb0003) {
+						z.Pos.Counts = (z.Pos.Counts)[:zb0003]
+					} else {
+						z.Pos.Counts = make([]float64, zb0003)
+					}
+					for za0001 := range z.Pos.Counts {
+						z.Pos.Counts[za0001], err = dc.ReadFloat64()
+						if err != nil {
+							return
+						}
+					}
+				default:
+					err = dc.Skip()
+					if err != nil {
+						return
+					}
+				}
+			}
+		case "neg":
+			var zb0004 uint32
+			zb0004, err = dc.ReadMapHeader()
+			if err != nil {
+				return
+			}
+			for zb0004 > 0 {
+				zb0004--
+				field, err = dc.ReadMapKeyPtr()
+				if err != nil {
+					return
+				}
+				switch msgp.UnsafeString(field) {
+				case "offset":
+					z.Neg.Offset, err = dc.ReadInt()
+					if err != nil {
+						return
+					}
+				case "counts":
+					var zb0005 uint32
+					zb0005, err = dc.ReadArrayHeader()
+					if err != nil {
+						return
+					}
+					if cap(z.Neg.Counts) >= int(zb0005) {
+						z.Neg.Counts = (z.Neg.Counts)[:zb0005]
+					} else {
+						z.Neg.Counts = make([]float64, zb0005)
+					}
+					for za0002 := range z.Neg.Counts {
+						z.Neg.Counts[za0002], err = dc.ReadFloat64()
+						if err != nil {
+							return
+						}
+					}
+				default:
+					err = dc.Skip()
+					if err != nil {
+						return
+					}
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *logSketchData) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 9
+	// write "alpha"
+	err = en.Append(0x89, 0xa5, 0x61, 0x6c, 0x70, 0x68, 0x61)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Alpha)
+	if err != nil {
+		return
+	}
+	// write "max_bins"
+	err = en.Append(0xa8, 0x6d, 0x61, 0x78, 0x5f, 0x62, 0x69, 0x6e, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.MaxBins)
+	if err != nil {
+		return
+	}
+	// write "count"
+	err = en.Append(0xa5, 0x63, 0x6f, 0x75, 0x6e, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Count)
+	if err != nil {
+		return
+	}
+	// write "min"
+	err = en.Append(0xa3, 0x6d, 0x69, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Min)
+	if err != nil {
+		return
+	}
+	// write "max"
+	err = en.Append(0xa3, 0x6d, 0x61, 0x78)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Max)
+	if err != nil {
+		return
+	}
+	// write "sum"
+	err = en.Append(0xa3, 0x73, 0x75, 0x6d)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Sum)
+	if err != nil {
+		return
+	}
+	// write "zero"
+	err = en.Append(0xa4, 0x7a, 0x65, 0x72, 0x6f)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Zero)
+	if err != nil {
+		return
+	}
+	// write "pos"
+	// map header, size 2
+	// write "offset"
+	err = en.Append(0xa3, 0x70, 0x6f, 0x73, 0x82, 0xa6, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Pos.Offset)
+	if err != nil {
+		return
+	}
+	// write "counts"
+	err = en.Append(0xa6, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Pos.Counts)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Pos.Counts {
+		err = en.WriteFloat64(z.Pos.Counts[za0001])
+		if err != nil {
+			return
+		}
+	}
+	// write "neg"
+	// map header, size 2
+	// write "offset"
+	err = en.Append(0xa3, 0x6e, 0x65, 0x67, 0x82, 0xa6, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Neg.Offset)
+	if err != nil {
+		return
+	}
+	// write "counts"
+	err = en.Append(0xa6, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Neg.Counts)))
+	if err != nil {
+		return
+	}
+	for za0002 := range z.Neg.Counts {
+		err = en.WriteFloat64(z.Neg.Counts[za0002])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *logSketchData) Msgsize() (s int) {
+	s = 1 + 6 + msgp.Float64Size + 9 + msgp.IntSize + 6 + msgp.Float64Size + 4 + msgp.Float64Size + 4 + msgp.Float64Size + 4 + msgp.Float64Size + 5 + msgp.Float64Size + 4 + 1 + 7 + msgp.IntSize + 7 + msgp.ArrayHeaderSize + (len(z.Pos.Counts) * (msgp.Float64Size)) + 4 + 1 + 7 + msgp.IntSize + 7 + msgp.ArrayHeaderSize + (len(z.Neg.Counts) * (msgp.Float64Size))
+	return
+}
+
+// DecodeMsg implements msgp.Decodable
+func (z *sketchStoreData) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "offset":
+			z.Offset, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "counts":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Counts) >= int(zb0002) {
+				z.Counts = (z.Counts)[:zb0002]
+			} else {
+				z.Counts = make([]float64, zb0002)
+			}
+			for za0001 := range z.Counts {
+				z.Counts[za0001], err = dc.ReadFloat64()
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *sketchStoreData) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 2
+	// write "offset"
+	err = en.Append(0x82, 0xa6, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Offset)
+	if err != nil {
+		return
+	}
+	// write "counts"
+	err = en.Append(0xa6, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Counts)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Counts {
+		err = en.WriteFloat64(z.Counts[za0001])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *sketchStoreData) Msgsize() (s int) {
+	s = 1 + 7 + msgp.IntSize + 7 + msgp.ArrayHeaderSize + (len(z.Counts) * (msgp.Float64Size))
+	return
+}
@@ -0,0 +1,177 @@
+package quantile
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// exactQuantile returns the element at quantile q of sorted values, the
+// same way LogSketch ranks them.
+func exactQuantile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func assertRelativeAccuracy(t *testing.T, s *LogSketch, vals []float64) {
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+
+	for _, q := range testQuantiles {
+		exact := exactQuantile(sorted, q)
+		got := s.Quantile(q)
+		assert.InDelta(t, exact, got, s.Alpha()*math.Abs(exact)+1e-9, "wrong value at quantile %v", q)
+	}
+}
+
+func TestLogSketchUniform(t *testing.T) {
+	s := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	vals := randSlice(10000)
+	for i, v := range vals {
+		s.Insert(v, uint64(i))
+	}
+
+	assert.Equal(t, float64(10000), s.Count())
+	assertRelativeAccuracy(t, s, vals)
+}
+
+func TestLogSketchLongTail(t *testing.T) {
+	s := NewLogSketch(0.02, DefaultSketchMaxBins)
+	vals := make([]float64, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		// durations in ns, mostly ~1ms with a few calls up to minutes
+		v := math.Exp(rand.NormFloat64()*3) * 1e6
+		vals = append(vals, v)
+		s.Insert(v, uint64(i))
+	}
+
+	assertRelativeAccuracy(t, s, vals)
+}
+
+func TestLogSketchNegativeAndZero(t *testing.T) {
+	s := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	vals := []float64{-1000, -10, -1, 0, 0, 1, 10, 100, 1000, 10000}
+	for i, v := range vals {
+		s.Insert(v, uint64(i))
+	}
+
+	assertRelativeAccuracy(t, s, vals)
+	assert.Equal(t, float64(-1000), s.Quantile(0))
+	assert.Equal(t, float64(10000), s.Quantile(1))
+}
+
+func TestLogSketchEmpty(t *testing.T) {
+	s := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	assert.Equal(t, float64(0), s.Quantile(0.5))
+	assert.Equal(t, float64(0), s.Count())
+}
+
+func TestLogSketchMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	s1 := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	s2 := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	all := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	vals := randSlice(2000)
+	for i, v := range vals {
+		if i%2 == 0 {
+			s1.Insert(v, uint64(i))
+		} else {
+			s2.Insert(v*1000, uint64(i))
+			vals[i] = v * 1000
+		}
+		all.Insert(vals[i], uint64(i))
+	}
+
+	s1.Merge(s2)
+	assert.Equal(all.Count(), s1.Count())
+	assert.InDelta(all.Sum(), s1.Sum(), 1e-6*all.Sum())
+	for _, q := range testQuantiles {
+		assert.Equal(all.Quantile(q), s1.Quantile(q), "wrong value at quantile %v", q)
+	}
+	assertRelativeAccuracy(t, s1, vals)
+
+	// merging with a different accuracy is lossy but stays close
+	s3 := NewLogSketch(0.001, 0)
+	s3.Merge(all)
+	assert.Equal(all.Count(), s3.Count())
+	assert.InDelta(all.Quantile(0.5), s3.Quantile(0.5), 0.011*all.Quantile(0.5))
+}
+
+func TestLogSketchMaxBins(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewLogSketch(DefaultSketchAlpha, 10)
+	for i := 0; i < 1000; i++ {
+		s.Insert(float64(i+1), uint64(i))
+	}
+
+	assert.Len(s.pos.counts, 10)
+	assert.Equal(float64(1000), s.Count())
+	// the highest quantiles are still accurate
+	assert.InDelta(990, s.Quantile(0.99), 0.01*990)
+	assert.Equal(float64(1000), s.Quantile(1))
+}
+
+func TestLogSketchWeighCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	for i := 0; i < 100; i++ {
+		s.Insert(float64(i+1), uint64(i))
+	}
+
+	s2 := s.Weigh(2)
+	assert.Equal(float64(200), s2.Count())
+	assert.Equal(s.Quantile(0.5), s2.Quantile(0.5))
+	assert.Equal(float64(100), s.Count(), "the original sketch should be left untouched")
+
+	s3 := s.Copy()
+	s3.Insert(1000, 0)
+	assert.Equal(float64(100), s.Count())
+	assert.Equal(float64(100), s.Quantile(1))
+}
+
+func TestLogSketchJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewLogSketch(0.05, 100)
+	for i, v := range []float64{-3, 0, 1, 2, 3, 1e6} {
+		s.Insert(v, uint64(i))
+	}
+
+	blob, err := json.Marshal(s)
+	assert.Nil(err)
+
+	var s2 LogSketch
+	assert.Nil(json.Unmarshal(blob, &s2))
+	assert.Equal(s, &s2)
+	for _, q := range testQuantiles {
+		assert.Equal(s.Quantile(q), s2.Quantile(q))
+	}
+}
+
+func TestLogSketchMsgpack(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewLogSketch(0.05, 100)
+	for i, v := range []float64{-3, 0, 1, 2, 3, 1e6} {
+		s.Insert(v, uint64(i))
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(msgp.Encode(&buf, s))
+	assert.True(buf.Len() <= s.Msgsize())
+
+	var s2 LogSketch
+	assert.Nil(msgp.Decode(&buf, &s2))
+	assert.Equal(s, &s2)
+	for _, q := range testQuantiles {
+		assert.Equal(s.Quantile(q), s2.Quantile(q))
+	}
+}
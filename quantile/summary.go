@@ -9,6 +9,9 @@ import (
 	"math/rand"
 )
 
+//go:generate msgp -marshal=false
+//msgp:ignore Summary SummarySlice Skiplist SkiplistNode
+
 /*
 DEPRECATED: this code is not used anymore, SliceSummary is used instead
 "Space-Efficient Online Computation of Quantile Summaries" (Greenwald, Khanna 2001)
@@ -32,9 +35,9 @@ type Summary struct {
 
 // Entry is an element of the skiplist, see GK paper for description
 type Entry struct {
-	V     float64 `json:"v"`
-	G     int     `json:"g"`
-	Delta int     `json:"delta"`
+	V     float64 `json:"v" msg:"v"`
+	G     int     `json:"g" msg:"g"`
+	Delta int     `json:"delta" msg:"delta"`
 }
 
 // NewSummary returns a new approx-summary with accuracy EPSILON
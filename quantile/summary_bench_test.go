@@ -174,3 +174,112 @@ func BenchmarkGKSliceEncoding100(b *testing.B) {
 func BenchmarkGKSliceEncoding1000(b *testing.B) {
 	BGKSliceEncoding(b, 1000)
 }
+
+// Log sketch, to compare with the GK summaries above
+
+func BenchmarkLogSketchInsertion(b *testing.B) {
+	s := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+
+	vals := randSlice(randlen)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		s.Insert(vals[n%randlen], uint64(n))
+	}
+}
+
+func BLogSketchQuantiles(b *testing.B, n int) {
+	s := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	vals := randSlice(n)
+	for i := 0; i < n; i++ {
+		s.Insert(vals[i], uint64(i))
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		s.Quantile(rand.Float64())
+	}
+}
+func BenchmarkLogSketchQuantiles10(b *testing.B) {
+	BLogSketchQuantiles(b, 10)
+}
+func BenchmarkLogSketchQuantiles100(b *testing.B) {
+	BLogSketchQuantiles(b, 100)
+}
+func BenchmarkLogSketchQuantiles1000(b *testing.B) {
+	BLogSketchQuantiles(b, 1000)
+}
+func BenchmarkLogSketchQuantiles10000(b *testing.B) {
+	BLogSketchQuantiles(b, 10000)
+}
+func BenchmarkLogSketchQuantiles100000(b *testing.B) {
+	BLogSketchQuantiles(b, 100000)
+}
+
+func BLogSketchMerge(b *testing.B, n int) {
+	s := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	vals := randSlice(n)
+	for i := 0; i < n; i++ {
+		s.Insert(vals[i], uint64(i))
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		s2 := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+		s2.Merge(s)
+	}
+}
+func BenchmarkLogSketchMerge1000(b *testing.B) {
+	BLogSketchMerge(b, 1000)
+}
+
+func BGKSliceMerge(b *testing.B, n int) {
+	s := NewSliceSummary()
+	vals := randSlice(n)
+	for i := 0; i < n; i++ {
+		s.Insert(vals[i], uint64(i))
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		s2 := NewSliceSummary()
+		s2.Merge(s)
+	}
+}
+func BenchmarkGKSliceMerge1000(b *testing.B) {
+	BGKSliceMerge(b, 1000)
+}
+
+func BLogSketchEncoding(b *testing.B, n int) {
+	s := NewLogSketch(DefaultSketchAlpha, DefaultSketchMaxBins)
+	vals := randSlice(n)
+	for i := 0; i < n; i++ {
+		s.Insert(vals[i], uint64(i))
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		blob, _ := json.Marshal(s)
+		var ss LogSketch
+		json.Unmarshal(blob, &ss)
+	}
+}
+func BenchmarkLogSketchEncoding10(b *testing.B) {
+	BLogSketchEncoding(b, 10)
+}
+func BenchmarkLogSketchEncoding100(b *testing.B) {
+	BLogSketchEncoding(b, 100)
+}
+func BenchmarkLogSketchEncoding1000(b *testing.B) {
+	BLogSketchEncoding(b, 1000)
+}
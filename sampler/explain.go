@@ -0,0 +1,83 @@
+package sampler
+
+import (
+	"sort"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// SignatureInfo explains the sampling decisions taken for a signature.
+type SignatureInfo struct {
+	Signature Signature
+
+	// Service, Name and Resource are those of the root of the last trace
+	// with this signature, representing what the signature is about.
+	Service  string
+	Name     string
+	Resource string
+
+	// Score is the decayed number of traces per second with this signature.
+	Score float64
+	// SampleRate is the rate currently applied to traces with this
+	// signature, extra rate included, before the pre-sampling and max TPS rates.
+	SampleRate float64
+
+	Seen int64 // number of traces with this signature
+	Kept int64 // number of those which were sampled
+}
+
+// recordSignature keeps track of the decision taken for a trace with the
+// given signature and root.
+func (s *Sampler) recordSignature(signature Signature, root *model.Span, sampled bool) {
+	s.signaturesMu.Lock()
+	si, ok := s.signatures[signature]
+	if !ok {
+		si = &SignatureInfo{Signature: signature}
+		s.signatures[signature] = si
+	}
+	si.Service = root.Service
+	si.Name = root.Name
+	si.Resource = root.Resource
+	si.Seen++
+	if sampled {
+		si.Kept++
+	}
+	s.signaturesMu.Unlock()
+}
+
+// pruneSignatures forgets the signatures which were not seen recently
+// enough to still have a score.
+func (s *Sampler) pruneSignatures() {
+	s.signaturesMu.Lock()
+	for signature := range s.signatures {
+		if s.Backend.GetSignatureScore(signature) == 0 {
+			delete(s.signatures, signature)
+		}
+	}
+	s.signaturesMu.Unlock()
+}
+
+// TopSignatures returns the n signatures with the highest score, highest
+// first, to explain why their traces are sampled or not.
+func (s *Sampler) TopSignatures(n int) []SignatureInfo {
+	s.signaturesMu.Lock()
+	infos := make([]SignatureInfo, 0, len(s.signatures))
+	for signature, si := range s.signatures {
+		info := *si
+		info.Score = s.Backend.GetSignatureScore(signature)
+		info.SampleRate = s.GetSignatureSampleRate(signature) * s.extraRate
+		infos = append(infos, info)
+	}
+	s.signaturesMu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Score != infos[j].Score {
+			return infos[i].Score > infos[j].Score
+		}
+		return infos[i].Signature < infos[j].Signature
+	})
+	if len(infos) > n {
+		infos = infos[:n]
+	}
+	return infos
+}
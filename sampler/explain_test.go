@@ -0,0 +1,48 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+func TestTopSignatures(t *testing.T) {
+	assert := assert.New(t)
+	s := getTestSampler()
+
+	sample := func(service, resource string, n int) {
+		for i := 0; i < n; i++ {
+			trace := model.Trace{
+				model.Span{TraceID: randomTraceID(), SpanID: 1, Service: service, Name: "request", Resource: resource, Duration: 100},
+			}
+			s.Sample(trace, &trace[0], defaultEnv)
+		}
+	}
+	sample("web", "GET /", 1000)
+	sample("web", "POST /", 10)
+	sample("db", "SELECT", 1)
+
+	top := s.TopSignatures(2)
+	if assert.Len(top, 2) {
+		assert.Equal("web", top[0].Service)
+		assert.Equal("request", top[0].Name)
+		assert.Equal("GET /", top[0].Resource)
+		assert.Equal(int64(1000), top[0].Seen)
+		assert.True(top[0].Kept < top[0].Seen, "high throughput signatures are sampled")
+		assert.True(top[0].SampleRate < 1)
+		assert.True(top[0].Score > top[1].Score)
+
+		assert.Equal("POST /", top[1].Resource)
+		assert.Equal(int64(10), top[1].Seen)
+	}
+	assert.Len(s.TopSignatures(10), 3)
+
+	// signatures whose score fully decayed are forgotten
+	for i := 0; i < 100; i++ {
+		s.Backend.DecayScore()
+	}
+	s.pruneSignatures()
+	assert.Len(s.TopSignatures(10), 0)
+}
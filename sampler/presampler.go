@@ -33,6 +33,9 @@ type PreSamplerStats struct {
 	RecentTracesSeen float64
 	// RecentTracesDropped is the number of traces that were dropped.
 	RecentTracesDropped float64
+	// LimitingSignal is the resource usage signal which lowered the rate,
+	// one of "cpu", "memory" or "queue", empty if none did.
+	LimitingSignal string
 }
 
 // PreSampler tries to tell wether we should keep a payload, even
@@ -108,6 +111,13 @@ func (ps *PreSampler) SetError(err error) {
 	ps.mu.Unlock()
 }
 
+// SetLimitingSignal set the signal which lowered the pre-sample rate, thread-safe.
+func (ps *PreSampler) SetLimitingSignal(signal string) {
+	ps.mu.Lock()
+	ps.stats.LimitingSignal = signal
+	ps.mu.Unlock()
+}
+
 // RealRate returns the current real pre-sample rate, thread-safe.
 // This is the value obtained by counting what was kept and dropped.
 func (ps *PreSampler) RealRate() float64 {
@@ -239,3 +249,51 @@ func CalcPreSampleRate(maxUserAvg, currentUserAvg, currentRate float64) (float64
 
 	return newRate, nil
 }
+
+// Names of the signals CalcMultiPreSampleRate adapts the pre-sample rate to.
+const (
+	SignalCPU    = "cpu"
+	SignalMemory = "memory"
+	SignalQueue  = "queue"
+)
+
+// PreSampleSignals are the resource usage measures the pre-sample rate adapts
+// to, along with their targets. A Max of 0 disables the memory and queue signals.
+type PreSampleSignals struct {
+	MaxUserAvg, UserAvg     float64 // user CPU average, 1 meaning a full core
+	MaxAlloc, Alloc         float64 // heap, in bytes allocated
+	MaxQueueFill, QueueFill float64 // fill ratio of the receiver queue, between 0 and 1
+}
+
+// CalcMultiPreSampleRate gives the new sample rate to apply so that CPU, heap
+// and receiver queue all stay below their targets. Each of them is assumed to
+// grow along with the rate, as in CalcPreSampleRate, and the lowest of the rates
+// they call for is returned, along with the name of the signal it comes from,
+// empty if no signal lowers the rate. The error is the one of that signal if
+// any, else the first one met.
+func CalcMultiPreSampleRate(s PreSampleSignals, currentRate float64) (float64, string, error) {
+	rate, limiting := float64(1), ""
+	var err error
+
+	for _, signal := range []struct {
+		name         string
+		max, current float64
+	}{
+		{SignalCPU, s.MaxUserAvg, s.UserAvg},
+		{SignalMemory, s.MaxAlloc, s.Alloc},
+		{SignalQueue, s.MaxQueueFill, s.QueueFill},
+	} {
+		if signal.name != SignalCPU && signal.max <= 0 {
+			continue // disabled, the CPU one is mandatory
+		}
+		r, e := CalcPreSampleRate(signal.max, signal.current, currentRate)
+		switch {
+		case r < rate:
+			rate, limiting, err = r, signal.name, e
+		case limiting == "" && err == nil:
+			err = e
+		}
+	}
+
+	return rate, limiting, err
+}
@@ -82,6 +82,40 @@ func TestCalcPreSampleRate(t *testing.T) {
 	}
 }
 
+func TestCalcMultiPreSampleRate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		signals     PreSampleSignals
+		currentRate float64
+		rate        float64
+		limiting    string
+		err         bool
+	}{
+		// everything below its target
+		{PreSampleSignals{MaxUserAvg: 0.1, UserAvg: 0.05, MaxAlloc: 1e8, Alloc: 1e7, MaxQueueFill: 0.5, QueueFill: 0.1}, 1, 1, "", false},
+		// only the CPU is above its target, same as CalcPreSampleRate
+		{PreSampleSignals{MaxUserAvg: 0.1, UserAvg: 0.2, MaxAlloc: 1e8, Alloc: 1e7}, 1, 0.75, SignalCPU, false},
+		// the heap is further above its target than the CPU
+		{PreSampleSignals{MaxUserAvg: 0.1, UserAvg: 0.2, MaxAlloc: 1e8, Alloc: 1e9}, 1, 0.55, SignalMemory, false},
+		// the queue is filling up
+		{PreSampleSignals{MaxUserAvg: 0.1, UserAvg: 0.05, MaxAlloc: 1e8, Alloc: 1e7, MaxQueueFill: 0.5, QueueFill: 1}, 1, 0.75, SignalQueue, false},
+		// disabled signals are ignored
+		{PreSampleSignals{MaxUserAvg: 0.1, UserAvg: 0.05, Alloc: 1e9, QueueFill: 1}, 1, 1, "", false},
+		// keeping the current rate, close enough to the target
+		{PreSampleSignals{MaxUserAvg: 0.1, UserAvg: 0.05, MaxAlloc: 1e8, Alloc: 1.05e8}, 0.5, 0.5, SignalMemory, false},
+		// the limiting signal reports its error
+		{PreSampleSignals{MaxUserAvg: 0.1, UserAvg: 0.05, MaxAlloc: 1e8, Alloc: 1e11}, 0.05, 0.05, SignalMemory, true},
+		// invalid CPU input is still reported
+		{PreSampleSignals{UserAvg: 0.05, MaxAlloc: 1e8, Alloc: 1e7}, 1, 1, "", true},
+	} {
+		rate, limiting, err := CalcMultiPreSampleRate(tc.signals, tc.currentRate)
+		assert.Equal(tc.rate, rate, "%+v", tc.signals)
+		assert.Equal(tc.limiting, limiting, "%+v", tc.signals)
+		assert.Equal(tc.err, err != nil, "%+v: %v", tc.signals, err)
+	}
+}
+
 func TestPreSamplerRace(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -169,3 +203,12 @@ func TestPreSamplerError(t *testing.T) {
 	ps.SetError(nil)
 	assert.Equal("", ps.stats.Error, "after reset, error should be empty")
 }
+
+func TestPreSamplerLimitingSignal(t *testing.T) {
+	assert := assert.New(t)
+
+	ps := NewPreSampler(1.0)
+	assert.Equal("", ps.Stats().LimitingSignal, "fresh pre-sampler should have no limiting signal")
+	ps.SetLimitingSignal(SignalMemory)
+	assert.Equal(SignalMemory, ps.Stats().LimitingSignal)
+}
@@ -15,6 +15,7 @@ package sampler
 
 import (
 	"math"
+	"sync"
 	"time"
 
 	"github.com/DataDog/datadog-trace-agent/model"
@@ -48,6 +49,10 @@ type Sampler struct {
 	// signatureScoreFactor = math.Pow(signatureScoreSlope, math.Log10(scoreSamplingOffset))
 	signatureScoreFactor float64
 
+	// signatures explains the decisions taken for the signatures seen recently
+	signatures   map[Signature]*SignatureInfo
+	signaturesMu sync.Mutex
+
 	exit chan struct{}
 }
 
@@ -60,6 +65,8 @@ func NewSampler(extraRate float64, maxTPS float64) *Sampler {
 		extraRate: extraRate,
 		maxTPS:    maxTPS,
 
+		signatures: make(map[Signature]*SignatureInfo),
+
 		exit: make(chan struct{}),
 	}
 
@@ -109,12 +116,30 @@ func (s *Sampler) RunAdjustScoring() {
 		select {
 		case <-t.C:
 			s.AdjustScoring()
+			s.pruneSignatures()
 		case <-s.exit:
 			return
 		}
 	}
 }
 
+// Step runs the periodic tasks of Run which are due between from and to.
+// It is meant to drive the sampler with a simulated clock instead of Run.
+func (s *Sampler) Step(from, to time.Time) {
+	for i := ticks(from, to, s.Backend.decayPeriod); i > 0; i-- {
+		s.Backend.DecayScore()
+	}
+	for i := ticks(from, to, adjustPeriod); i > 0; i-- {
+		s.AdjustScoring()
+		s.pruneSignatures()
+	}
+}
+
+// ticks returns the number of multiples of period in (from, to].
+func ticks(from, to time.Time, period time.Duration) int64 {
+	return to.UnixNano()/int64(period) - from.UnixNano()/int64(period)
+}
+
 // Sample counts an incoming trace and tells if it is a sample which has to be kept
 func (s *Sampler) Sample(trace model.Trace, root *model.Span, env string) bool {
 	// Extra safety, just in case one trace is empty
@@ -144,6 +169,8 @@ func (s *Sampler) Sample(trace model.Trace, root *model.Span, env string) bool {
 		}
 	}
 
+	s.recordSignature(signature, root, sampled)
+
 	return sampled
 }
 
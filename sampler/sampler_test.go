@@ -54,6 +54,23 @@ func TestSamplerLoop(t *testing.T) {
 	}
 }
 
+func TestSamplerStep(t *testing.T) {
+	assert := assert.New(t)
+	s := getTestSampler()
+
+	trace, root := getTestTrace()
+	s.Sample(trace, root, defaultEnv)
+	score := s.Backend.GetTotalScore()
+
+	start := time.Unix(1000, 0)
+	s.Step(start, start.Add(defaultDecayPeriod-time.Nanosecond))
+	assert.Equal(score, s.Backend.GetTotalScore())
+
+	// two decay periods elapsed
+	s.Step(start, start.Add(2*defaultDecayPeriod))
+	assert.InEpsilon(score/s.Backend.decayFactor/s.Backend.decayFactor, s.Backend.GetTotalScore(), 1e-9)
+}
+
 func TestExtraSampleRate(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,240 @@
+package watchdog
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCgroupRoot is where the cgroup filesystem is mounted.
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+
+	// procSelfCgroup lists the cgroups of the process in each hierarchy.
+	procSelfCgroup = "/proc/self/cgroup"
+
+	// cgroupUnlimited is the threshold above which a cgroup v1 memory
+	// limit means "no limit", the kernel reporting a huge page-aligned value.
+	cgroupUnlimited = 1 << 62
+)
+
+// CgroupLimits contains the resource limits of the cgroup the agent runs in.
+type CgroupLimits struct {
+	// Version is the cgroup version, 1 or 2.
+	Version int
+	// CPU is the CPU quota, in cores, 0 if unlimited.
+	CPU float64
+	// Memory is the memory limit, in bytes, 0 if unlimited.
+	Memory uint64
+}
+
+// CgroupInfo contains the cgroup limits and throttling info.
+type CgroupInfo struct {
+	CgroupLimits
+	// ThrottledRatio is the fraction of the CPU periods during which the
+	// cgroup was throttled, since last time it was polled.
+	ThrottledRatio float64
+	// ThrottledTime is the time the cgroup was throttled for, since last
+	// time it was polled.
+	ThrottledTime time.Duration
+}
+
+// cgroupCPUStat is the content of the cpu.stat file, the counters being
+// cumulated since the cgroup was created.
+type cgroupCPUStat struct {
+	periods, throttled uint64
+	throttledTime      time.Duration
+}
+
+// cgroup reads the limits and stats of a cgroup from its filesystem.
+type cgroup struct {
+	version int
+	// cpuDir and memoryDir are the directories of the cgroup in the cpu
+	// and memory hierarchies, the same one in v2.
+	cpuDir, memoryDir string
+}
+
+// newCgroup returns the cgroup of the process in the filesystem mounted in
+// root, as listed in procCgroup, or an error if there is none, detecting
+// whether it is a v1 or v2 one.
+func newCgroup(root, procCgroup string) (*cgroup, error) {
+	// not knowing the cgroups of the process, the root ones are used
+	paths, _ := readCgroupPaths(procCgroup)
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		dir := cgroupDir(root, paths[""])
+		return &cgroup{version: 2, cpuDir: dir, memoryDir: dir}, nil
+	}
+	if _, err := os.Stat(filepath.Join(root, "cpu")); err == nil {
+		return &cgroup{
+			version:   1,
+			cpuDir:    cgroupDir(filepath.Join(root, "cpu"), paths["cpu"]),
+			memoryDir: cgroupDir(filepath.Join(root, "memory"), paths["memory"]),
+		}, nil
+	}
+	return nil, fmt.Errorf("no cgroup found in %s", root)
+}
+
+// readCgroupPaths returns the paths of the cgroups of the process relative
+// to their hierarchy, read from a /proc/<pid>/cgroup file: by controller in
+// v1, and for the "" controller in the v2 unified hierarchy.
+func readCgroupPaths(procCgroup string) (map[string]string, error) {
+	f, err := os.Open(procCgroup)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			paths[controller] = fields[2]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// cgroupDir returns the directory of the cgroup at path in the hierarchy
+// mounted in dir. In a container without its own cgroup namespace, path is
+// the one seen from the host while the cgroup of the container is mounted
+// in dir itself, which is returned if path is not found.
+func cgroupDir(dir, path string) string {
+	if path == "" || path == "/" {
+		return dir
+	}
+	if fi, err := os.Stat(filepath.Join(dir, path)); err == nil && fi.IsDir() {
+		return filepath.Join(dir, path)
+	}
+	return dir
+}
+
+func readCgroupFile(dir, name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func readCgroupInt(dir, name string) (int64, error) {
+	s, err := readCgroupFile(dir, name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// limits returns the CPU quota and memory limit of the cgroup.
+func (cg *cgroup) limits() (CgroupLimits, error) {
+	l := CgroupLimits{Version: cg.version}
+
+	if cg.version == 2 {
+		// cpu.max is "$MAX $PERIOD", $MAX being "max" if unlimited
+		s, err := readCgroupFile(cg.cpuDir, "cpu.max")
+		if err != nil {
+			return l, err
+		}
+		if fields := strings.Fields(s); len(fields) == 2 && fields[0] != "max" {
+			quota, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return l, fmt.Errorf("invalid cpu.max %q", s)
+			}
+			period, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || period <= 0 {
+				return l, fmt.Errorf("invalid cpu.max %q", s)
+			}
+			l.CPU = quota / period
+		}
+
+		s, err = readCgroupFile(cg.memoryDir, "memory.max")
+		if err != nil {
+			return l, err
+		}
+		if s != "max" {
+			mem, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return l, fmt.Errorf("invalid memory.max %q", s)
+			}
+			l.Memory = mem
+		}
+		return l, nil
+	}
+
+	quota, err := readCgroupInt(cg.cpuDir, "cpu.cfs_quota_us")
+	if err != nil {
+		return l, err
+	}
+	if quota > 0 {
+		period, err := readCgroupInt(cg.cpuDir, "cpu.cfs_period_us")
+		if err != nil {
+			return l, err
+		}
+		if period > 0 {
+			l.CPU = float64(quota) / float64(period)
+		}
+	}
+
+	mem, err := readCgroupInt(cg.memoryDir, "memory.limit_in_bytes")
+	if err != nil {
+		return l, err
+	}
+	if mem > 0 && mem < cgroupUnlimited {
+		l.Memory = uint64(mem)
+	}
+	return l, nil
+}
+
+// cpuStat returns the CPU throttling counters of the cgroup.
+func (cg *cgroup) cpuStat() (cgroupCPUStat, error) {
+	var st cgroupCPUStat
+
+	f, err := os.Open(filepath.Join(cg.cpuDir, "cpu.stat"))
+	if err != nil {
+		return st, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "nr_periods":
+			st.periods = v
+		case "nr_throttled":
+			st.throttled = v
+		case "throttled_time": // v1, in nanoseconds
+			st.throttledTime = time.Duration(v)
+		case "throttled_usec": // v2
+			st.throttledTime = time.Duration(v) * time.Microsecond
+		}
+	}
+	return st, scanner.Err()
+}
+
+// ReadCgroupLimits returns the limits of the cgroup of the process in the
+// filesystem mounted in root, usually DefaultCgroupRoot.
+func ReadCgroupLimits(root string) (CgroupLimits, error) {
+	cg, err := newCgroup(root, procSelfCgroup)
+	if err != nil {
+		return CgroupLimits{}, err
+	}
+	return cg.limits()
+}
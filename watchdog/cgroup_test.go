@@ -0,0 +1,200 @@
+package watchdog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCgroup creates the given cgroup files in a temporary root, and returns it.
+func fakeCgroup(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCgroupV1(t *testing.T) {
+	assert := assert.New(t)
+
+	root := fakeCgroup(t, map[string]string{
+		"cpu/cpu.cfs_quota_us":         "50000",
+		"cpu/cpu.cfs_period_us":        "100000",
+		"cpu/cpu.stat":                 "nr_periods 100\nnr_throttled 10\nthrottled_time 2000000000",
+		"memory/memory.limit_in_bytes": "268435456",
+	})
+	defer os.RemoveAll(root)
+
+	limits, err := ReadCgroupLimits(root)
+	assert.Nil(err)
+	assert.Equal(CgroupLimits{Version: 1, CPU: 0.5, Memory: 268435456}, limits)
+
+	cg, err := newCgroup(root, filepath.Join(root, "none"))
+	assert.Nil(err)
+	st, err := cg.cpuStat()
+	assert.Nil(err)
+	assert.Equal(cgroupCPUStat{periods: 100, throttled: 10, throttledTime: 2 * time.Second}, st)
+}
+
+func TestCgroupV1Unlimited(t *testing.T) {
+	assert := assert.New(t)
+
+	root := fakeCgroup(t, map[string]string{
+		"cpu/cpu.cfs_quota_us":         "-1",
+		"cpu/cpu.cfs_period_us":        "100000",
+		"memory/memory.limit_in_bytes": "9223372036854771712",
+	})
+	defer os.RemoveAll(root)
+
+	limits, err := ReadCgroupLimits(root)
+	assert.Nil(err)
+	assert.Equal(CgroupLimits{Version: 1}, limits)
+}
+
+func TestCgroupV2(t *testing.T) {
+	assert := assert.New(t)
+
+	root := fakeCgroup(t, map[string]string{
+		"cgroup.controllers": "cpu memory",
+		"cpu.max":            "150000 100000",
+		"cpu.stat":           "usage_usec 1000\nnr_periods 40\nnr_throttled 4\nthrottled_usec 500000",
+		"memory.max":         "536870912",
+	})
+	defer os.RemoveAll(root)
+
+	limits, err := ReadCgroupLimits(root)
+	assert.Nil(err)
+	assert.Equal(CgroupLimits{Version: 2, CPU: 1.5, Memory: 536870912}, limits)
+
+	cg, err := newCgroup(root, filepath.Join(root, "none"))
+	assert.Nil(err)
+	st, err := cg.cpuStat()
+	assert.Nil(err)
+	assert.Equal(cgroupCPUStat{periods: 40, throttled: 4, throttledTime: 500 * time.Millisecond}, st)
+
+	ioutil.WriteFile(filepath.Join(root, "cpu.max"), []byte("max 100000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "memory.max"), []byte("max\n"), 0644)
+	limits, err = ReadCgroupLimits(root)
+	assert.Nil(err)
+	assert.Equal(CgroupLimits{Version: 2}, limits)
+}
+
+func TestCgroupProcessPath(t *testing.T) {
+	assert := assert.New(t)
+
+	// v1, the cgroup of the process being nested in the hierarchies
+	root := fakeCgroup(t, map[string]string{
+		"cpu/cpu.cfs_quota_us":                    "-1",
+		"cpu/cpu.cfs_period_us":                   "100000",
+		"cpu/docker/abc/cpu.cfs_quota_us":         "200000",
+		"cpu/docker/abc/cpu.cfs_period_us":        "100000",
+		"memory/memory.limit_in_bytes":            "9223372036854771712",
+		"memory/docker/abc/memory.limit_in_bytes": "268435456",
+		"proc/cgroup":                             "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc",
+		"proc/cgroup-namespaced":                  "12:memory:/\n4:cpu,cpuacct:/",
+		"proc/cgroup-host":                        "12:memory:/docker/def\n4:cpu,cpuacct:/docker/def",
+	})
+	defer os.RemoveAll(root)
+
+	cg, err := newCgroup(root, filepath.Join(root, "proc/cgroup"))
+	assert.Nil(err)
+	limits, err := cg.limits()
+	assert.Nil(err)
+	assert.Equal(CgroupLimits{Version: 1, CPU: 2, Memory: 268435456}, limits)
+
+	// the cgroup of the process is mounted as the root one
+	for _, proc := range []string{"proc/cgroup-namespaced", "proc/cgroup-host"} {
+		cg, err = newCgroup(root, filepath.Join(root, proc))
+		assert.Nil(err)
+		limits, err = cg.limits()
+		assert.Nil(err)
+		assert.Equal(CgroupLimits{Version: 1}, limits, proc)
+	}
+
+	// v2, with a single unified hierarchy
+	root = fakeCgroup(t, map[string]string{
+		"cgroup.controllers":                    "cpu memory",
+		"cpu.max":                               "max 100000",
+		"memory.max":                            "max",
+		"system.slice/agent.service/cpu.max":    "50000 100000",
+		"system.slice/agent.service/memory.max": "536870912",
+		"proc/cgroup":                           "0::/system.slice/agent.service",
+	})
+	defer os.RemoveAll(root)
+
+	cg, err = newCgroup(root, filepath.Join(root, "proc/cgroup"))
+	assert.Nil(err)
+	limits, err = cg.limits()
+	assert.Nil(err)
+	assert.Equal(CgroupLimits{Version: 2, CPU: 0.5, Memory: 536870912}, limits)
+}
+
+func TestCgroupNone(t *testing.T) {
+	root := fakeCgroup(t, nil)
+	defer os.RemoveAll(root)
+
+	_, err := ReadCgroupLimits(root)
+	assert.NotNil(t, err)
+}
+
+func TestCgroupThrottling(t *testing.T) {
+	assert := assert.New(t)
+
+	root := fakeCgroup(t, map[string]string{
+		"cgroup.controllers": "cpu memory",
+		"cpu.max":            "50000 100000",
+		"cpu.stat":           "nr_periods 100\nnr_throttled 10\nthrottled_usec 1000000",
+		"memory.max":         "max",
+	})
+	defer os.RemoveAll(root)
+
+	cg, err := newCgroup(root, filepath.Join(root, "none"))
+	assert.Nil(err)
+	pi := &CurrentInfo{}
+	pi.watchCgroup(cg)
+
+	// the first poll doesn't report what happened before the cgroup was watched
+	info := pi.Cgroup()
+	assert.Equal(0.5, info.CPU)
+	assert.Equal(0.0, info.ThrottledRatio)
+	assert.Equal(time.Duration(0), info.ThrottledTime)
+
+	ioutil.WriteFile(filepath.Join(root, "cpu.stat"), []byte("nr_periods 150\nnr_throttled 20\nthrottled_usec 2000000\n"), 0644)
+	info = pi.Cgroup()
+	assert.Equal(0.2, info.ThrottledRatio)
+	assert.Equal(time.Second, info.ThrottledTime)
+
+	// only what happened since the previous poll is reported
+	ioutil.WriteFile(filepath.Join(root, "cpu.stat"), []byte("nr_periods 250\nnr_throttled 70\nthrottled_usec 5000000\n"), 0644)
+	info = pi.Cgroup()
+	assert.Equal(0.5, info.ThrottledRatio)
+	assert.Equal(3*time.Second, info.ThrottledTime)
+
+	// the counters start over
+	ioutil.WriteFile(filepath.Join(root, "cpu.stat"), []byte("nr_periods 10\nnr_throttled 5\nthrottled_usec 100\n"), 0644)
+	info = pi.Cgroup()
+	assert.Equal(0.0, info.ThrottledRatio)
+	assert.Equal(time.Duration(0), info.ThrottledTime)
+
+	ioutil.WriteFile(filepath.Join(root, "cpu.stat"), []byte("nr_periods 20\nnr_throttled 6\nthrottled_usec 1000100\n"), 0644)
+	info = pi.Cgroup()
+	assert.Equal(0.1, info.ThrottledRatio)
+	assert.Equal(time.Second, info.ThrottledTime)
+
+	// not in a cgroup
+	assert.Equal(CgroupInfo{}, (&CurrentInfo{}).Cgroup())
+}
@@ -51,6 +51,8 @@ type Info struct {
 	Mem MemInfo
 	// Net contains basic Net info
 	Net NetInfo
+	// Cgroup contains the cgroup limits and throttling info, if any
+	Cgroup CgroupInfo
 }
 
 // CurrentInfo is used to query CPU and Mem info, it keeps data from
@@ -70,6 +72,11 @@ type CurrentInfo struct {
 
 	lastNetTime time.Time
 	lastNet     NetInfo
+
+	cgroup         *cgroup // nil if not running in a cgroup
+	lastCgroupTime time.Time
+	lastCgroupStat cgroupCPUStat
+	lastCgroup     CgroupInfo
 }
 
 // globalCurrentInfo is a global default object one can safely use
@@ -90,10 +97,26 @@ func NewCurrentInfo() (*CurrentInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &CurrentInfo{
+	pi := &CurrentInfo{
 		p:          p,
 		cacheDelay: cacheDelay,
-	}, nil
+	}
+	if cg, err := newCgroup(DefaultCgroupRoot, procSelfCgroup); err == nil {
+		pi.watchCgroup(cg)
+	} else {
+		log.Debugf("not reporting cgroup info: %v", err)
+	}
+	return pi, nil
+}
+
+// watchCgroup sets the cgroup whose info is reported. Its throttling
+// counters are read right away, so that the first poll reports what
+// happened since then and not since the cgroup was created.
+func (pi *CurrentInfo) watchCgroup(cg *cgroup) {
+	pi.cgroup = cg
+	if st, err := cg.cpuStat(); err == nil {
+		pi.lastCgroupStat = st
+	}
 }
 
 // CPU returns basic CPU info.
@@ -150,6 +173,7 @@ func (pi *CurrentInfo) Mem() MemInfo {
 		pi.lastMem.AllocPerSec = float64(time.Second) * float64(dta) / float64(dt)
 	}
 	ret.AllocPerSec = pi.lastMem.AllocPerSec
+	pi.lastMem = ret
 
 	return ret
 }
@@ -170,6 +194,59 @@ func Mem() MemInfo {
 	return globalCurrentInfo.Mem()
 }
 
+// Cgroup returns the limits and throttling info of the cgroup of the
+// process, zero values if it does not run in one.
+func (pi *CurrentInfo) Cgroup() CgroupInfo {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	if pi.cgroup == nil {
+		return pi.lastCgroup
+	}
+
+	now := time.Now()
+	if now.Sub(pi.lastCgroupTime) <= pi.cacheDelay {
+		return pi.lastCgroup // don't query too often, cache a little bit
+	}
+	pi.lastCgroupTime = now
+
+	limits, err := pi.cgroup.limits()
+	if err != nil {
+		log.Debugf("unable to get cgroup limits: %v", err)
+		return pi.lastCgroup
+	}
+	pi.lastCgroup.CgroupLimits = limits
+
+	st, err := pi.cgroup.cpuStat()
+	if err != nil {
+		log.Debugf("unable to get cgroup CPU stats: %v", err)
+		return pi.lastCgroup
+	}
+	// the counters start over if the cgroup is recreated, nothing is
+	// reported then until the next poll
+	pi.lastCgroup.ThrottledRatio = 0
+	dp := int64(st.periods) - int64(pi.lastCgroupStat.periods)
+	if dt := int64(st.throttled) - int64(pi.lastCgroupStat.throttled); dp > 0 && dt >= 0 {
+		pi.lastCgroup.ThrottledRatio = float64(dt) / float64(dp)
+	}
+	pi.lastCgroup.ThrottledTime = 0
+	if dt := st.throttledTime - pi.lastCgroupStat.throttledTime; dt > 0 {
+		pi.lastCgroup.ThrottledTime = dt
+	}
+	pi.lastCgroupStat = st
+
+	return pi.lastCgroup
+}
+
+// Cgroup returns the limits and throttling info of the cgroup of the
+// process, zero values if it does not run in one.
+func Cgroup() CgroupInfo {
+	if globalCurrentInfo == nil {
+		return CgroupInfo{}
+	}
+	return globalCurrentInfo.Cgroup()
+}
+
 // Net returns basic network info.
 func Net() NetInfo {
 	if globalCurrentInfo == nil {
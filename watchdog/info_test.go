@@ -101,6 +101,7 @@ func doTestMemHigh(t *testing.T, n int) {
 
 	done := make(chan struct{}, 1)
 	data := make(chan []byte, 1)
+	globalCurrentInfo.cacheDelay = 0 // get a fresh reading to compare with
 	oldM := Mem()
 	globalCurrentInfo.cacheDelay = testDuration
 	go func() {
@@ -174,6 +175,22 @@ func doTestNetHigh(t *testing.T, n int) {
 	assert.True(info.Connections <= int32(n*3), fmt.Sprintf("not enough connections %d > %d * 3", info.Connections, n))
 }
 
+func TestMemCached(t *testing.T) {
+	assert := assert.New(t)
+
+	pi, err := NewCurrentInfo()
+	assert.Nil(err)
+	pi.cacheDelay = time.Hour
+
+	info := pi.Mem()
+	assert.True(info.Alloc > 0)
+
+	// within the cache delay, the last reading is returned as a whole
+	data := make([]byte, 10*1024*1024)
+	assert.Equal(info, pi.Mem())
+	data[0] = 1
+}
+
 func TestNetHigh(t *testing.T) {
 	doTestNetHigh(t, 10)
 	if testing.Short() {